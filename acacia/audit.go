@@ -0,0 +1,193 @@
+package acacia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/highgrav/taproot/common"
+	"github.com/highgrav/taproot/constants"
+	"github.com/highgrav/taproot/logging"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The source used for policy log messages that don't set one
+const AUDIT_DEFAULT_LOG_SOURCE string = "ACAC"
+
+/*
+An AuditRecord describes a single authorization decision: the request, the policies that matched, the result, and any
+policy log messages that were written.
+*/
+type AuditRecord struct {
+	Time          time.Time         `json:"time"`
+	Route         string            `json:"route"`
+	Generation    uint64            `json:"generation"`
+	CorrelationID string            `json:"correlationId"`
+	UserID        string            `json:"userId"`
+	SourceIP      string            `json:"srcIp"`
+	Path          string            `json:"path"`
+	Policies      []string          `json:"policies"`
+	Decision      RightResponseType `json:"decision"`
+	Rights        []string          `json:"rights"`
+	Redirect      string            `json:"redirectTo,omitempty"`
+	ReturnCode    int               `json:"returnCode,omitempty"`
+	Messages      []AuditMessage    `json:"messages"`
+}
+
+// A policy log message, rendered against the rights request
+type AuditMessage struct {
+	PolicyID string `json:"policyId"`
+	Source   string `json:"src"`
+	Priority string `json:"pri"`
+	Message  string `json:"msg"`
+}
+
+/*
+An AuditSink receives a record of every decision made by PolicyManager.Apply(), whether or not any policy log messages
+were written. Record() is called synchronously on the request path, so sinks that do slow work (writing to a database
+or a remote service) should buffer and do that work elsewhere.
+*/
+type AuditSink interface {
+	Record(ctx context.Context, rec AuditRecord)
+}
+
+// Sets the sink that receives a record of every authorization decision. Pass nil to remove the sink.
+func (pm *PolicyManager) SetAuditSink(sink AuditSink) {
+	pm.sinkMu.Lock()
+	defer pm.sinkMu.Unlock()
+	pm.sink = sink
+}
+
+func (pm *PolicyManager) auditSink() AuditSink {
+	pm.sinkMu.RLock()
+	defer pm.sinkMu.RUnlock()
+	return pm.sink
+}
+
+// Writes the log messages of the matched policies, and hands the decision to the audit sink, if there is one
+func (pm *PolicyManager) audit(ctx context.Context, set *policySet, route string, request *RightsRequest, rr RightResponse, matched []string) {
+	sink := pm.auditSink()
+	matched = common.Dedupe[string](matched)
+	var vars map[string]any
+	messages := make([]AuditMessage, 0)
+	permitted := rr.Type == RESP_TYPE_RIGHTS
+
+	for _, name := range matched {
+		policy := set.policies[name]
+		logs := policy.Logging.OnAny
+		if permitted {
+			logs = append(logs[:len(logs):len(logs)], policy.Logging.OnPermit...)
+		} else {
+			logs = append(logs[:len(logs):len(logs)], policy.Logging.OnDeny...)
+		}
+		for _, l := range logs {
+			if vars == nil {
+				vars = templateVars(ctx, route, request, rr)
+			}
+			msg := AuditMessage{
+				PolicyID: policy.Manifest.ID,
+				Source:   l.Source,
+				Priority: strings.ToLower(l.Priority),
+				Message:  renderLogTemplate(l.Message, vars, policy),
+			}
+			if msg.Source == "" {
+				msg.Source = AUDIT_DEFAULT_LOG_SOURCE
+			}
+			switch msg.Priority {
+			case "info", "warn", "warning", "error":
+			default:
+				// never let a policy pick "fatal", which would exit the server
+				msg.Priority = "info"
+			}
+			logging.LogToDeck(ctx, msg.Priority, msg.Source, msg.Priority, msg.Message)
+			messages = append(messages, msg)
+		}
+	}
+
+	if sink == nil {
+		return
+	}
+	corrId, _ := ctx.Value(constants.HTTP_CONTEXT_CORRELATION_KEY).(string)
+	sink.Record(ctx, AuditRecord{
+		Time:          time.Now(),
+		Route:         route,
+		Generation:    rr.Generation,
+		CorrelationID: corrId,
+		UserID:        request.User.UserID,
+		SourceIP:      request.Http.SourceIPAddress,
+		Path:          request.Http.TargetPath,
+		Policies:      matched,
+		Decision:      rr.Type,
+		Rights:        rr.Rights,
+		Redirect:      rr.Redirect,
+		ReturnCode:    rr.Response.ReturnCode,
+		Messages:      messages,
+	})
+}
+
+var logTemplateVar = regexp.MustCompile(`{{\s*([A-Za-z0-9_.]+)\s*}}`)
+
+/*
+Builds the values available to policy log templates. Request values use the same JSON paths as match patterns (e.g.,
+{{user.userId}}, {{http.srcIp}}, {{http.tgtPath}}), plus {{correlationId}}, {{sessionId}}, {{route}}, and {{decision}}.
+*/
+func templateVars(ctx context.Context, route string, request *RightsRequest, rr RightResponse) map[string]any {
+	vars := make(map[string]any)
+	js, err := json.Marshal(request)
+	if err == nil {
+		json.Unmarshal(js, &vars)
+	}
+	if v, ok := ctx.Value(constants.HTTP_CONTEXT_CORRELATION_KEY).(string); ok {
+		vars["correlationId"] = v
+	}
+	if v, ok := ctx.Value(constants.HTTP_CONTEXT_SESSION_KEY).(string); ok {
+		vars["sessionId"] = v
+	}
+	vars["route"] = route
+	vars["decision"] = string(rr.Type)
+	return vars
+}
+
+// Replaces {{path}} placeholders in a log message. Missing values are written as "-", the same as in LogToDeck().
+func renderLogTemplate(tmpl string, vars map[string]any, policy Policy) string {
+	return logTemplateVar.ReplaceAllStringFunc(tmpl, func(m string) string {
+		path := logTemplateVar.FindStringSubmatch(m)[1]
+		switch path {
+		case "policy.id":
+			return policy.Manifest.ID
+		case "policy.name":
+			return policy.Manifest.Name
+		}
+		var val any = vars
+		for _, key := range strings.Split(path, ".") {
+			obj, ok := val.(map[string]any)
+			if !ok {
+				return "-"
+			}
+			val, ok = obj[key]
+			if !ok || val == nil {
+				return "-"
+			}
+		}
+		switch v := val.(type) {
+		case string:
+			if v == "" {
+				return "-"
+			}
+			return v
+		case []any:
+			strs := make([]string, 0, len(v))
+			for _, s := range v {
+				strs = append(strs, fmt.Sprint(s))
+			}
+			return strings.Join(strs, ",")
+		default:
+			js, err := json.Marshal(v)
+			if err != nil {
+				return "-"
+			}
+			return string(js)
+		}
+	})
+}
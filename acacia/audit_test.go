@@ -0,0 +1,100 @@
+package acacia
+
+import (
+	"context"
+	"github.com/highgrav/taproot/constants"
+	"testing"
+)
+
+type testAuditSink struct {
+	records []AuditRecord
+}
+
+func (s *testAuditSink) Record(ctx context.Context, rec AuditRecord) {
+	s.records = append(s.records, rec)
+}
+
+func TestPolicyLogs(t *testing.T) {
+	p, err := NewParser(`<policy>
+	<manifest>
+		<id>crm-block</id>
+		<priority>10</priority>
+	</manifest>
+	<paths>
+		<path>/crm/:id</path>
+	</paths>
+	<effects>
+		<return>Blocked</return>
+		<returncode>403</returncode>
+	</effects>
+	<log>
+		<permit>
+			<msg>should not be written</msg>
+		</permit>
+		<deny>
+			<msg src="AUDIT" pri="warn">{{policy.id}} blocked {{user.userId}} from {{http.srcIp}} on {{http.tgtPath}} ({{correlationId}})</msg>
+		</deny>
+		<any>
+			<msg pri="fatal">{{decision}} for {{user.username}}</msg>
+		</any>
+	</log>
+	<matches>
+		<match type="json">{"realmId":["test"]}</match>
+	</matches>
+</policy>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Logging.OnPermit) != 1 || len(policy.Logging.OnDeny) != 1 || len(policy.Logging.OnAny) != 1 {
+		t.Fatalf("log section not parsed: %+v", policy.Logging)
+	}
+
+	pm := NewPolicyManager()
+	sink := &testAuditSink{}
+	pm.SetAuditSink(sink)
+	if err := pm.AddPolicy("block", policy); err != nil {
+		t.Fatal(err)
+	}
+	rr := &RightsRequest{RealmID: "test"}
+	rr.User.UserID = "u-123"
+	rr.Http.SourceIPAddress = "10.0.0.1"
+	rr.Http.TargetPath = "/crm/42"
+	ctx := context.WithValue(context.Background(), constants.HTTP_CONTEXT_CORRELATION_KEY, "corr-1")
+	res, err := pm.Apply(ctx, "/crm/:id", rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Type != RESP_TYPE_RESPONSE {
+		t.Fatalf("expected a response, got %s", res.Type)
+	}
+
+	if len(sink.records) != 1 {
+		t.Fatalf("expected 1 audit record, got %d", len(sink.records))
+	}
+	rec := sink.records[0]
+	if rec.Decision != RESP_TYPE_RESPONSE || rec.ReturnCode != 403 || rec.UserID != "u-123" || rec.CorrelationID != "corr-1" {
+		t.Errorf("unexpected audit record %+v", rec)
+	}
+	if len(rec.Messages) != 2 {
+		t.Fatalf("expected 2 messages, got %+v", rec.Messages)
+	}
+	expected := []AuditMessage{
+		{PolicyID: "crm-block", Source: AUDIT_DEFAULT_LOG_SOURCE, Priority: "info", Message: "response for -"},
+		{PolicyID: "crm-block", Source: "AUDIT", Priority: "warn", Message: "crm-block blocked u-123 from 10.0.0.1 on /crm/42 (corr-1)"},
+	}
+	for x, m := range expected {
+		if rec.Messages[x] != m {
+			t.Errorf("expected message %+v, got %+v", m, rec.Messages[x])
+		}
+	}
+
+	// Explain() is for review, and doesn't write to the audit log
+	pm.Explain(ctx, "/crm/:id", rr)
+	if len(sink.records) != 1 {
+		t.Error("Explain wrote an audit record")
+	}
+}
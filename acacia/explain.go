@@ -0,0 +1,56 @@
+package acacia
+
+import "context"
+
+/*
+An Explanation records how a RightResponse was reached: the route patterns that were tried, the policies that matched,
+how rights were allowed and denied at each priority, and which rule won.
+*/
+type Explanation struct {
+	Generation uint64             `json:"generation"`
+	Route      string             `json:"route"`
+	Candidates []ExplainCandidate `json:"candidates"`
+	Matches    []ExplainMatch     `json:"matches"`
+	Steps      []ExplainStep      `json:"steps"`
+	Reason     string             `json:"reason"`
+	Result     RightResponse      `json:"result"`
+}
+
+// A route pattern that was checked for policies, and whether any policies were bound to it
+type ExplainCandidate struct {
+	Pattern string `json:"pattern"`
+	Bound   bool   `json:"bound"`
+}
+
+// A policy whose match pattern matched the rights request
+type ExplainMatch struct {
+	Name       string   `json:"name"`
+	PolicyID   string   `json:"id"`
+	Priority   int      `json:"pri"`
+	Allowed    []string `json:"allowed"`
+	Denied     []string `json:"denied"`
+	Redirect   string   `json:"redirect,omitempty"`
+	ReturnCode int      `json:"returnCode,omitempty"`
+}
+
+// The rights allowed and denied at a single priority, and the resulting set of rights after that priority is applied
+type ExplainStep struct {
+	Priority int      `json:"pri"`
+	Allowed  []string `json:"allowed"`
+	Denied   []string `json:"denied"`
+	Rights   []string `json:"rights"`
+}
+
+/*
+Applies the loaded policies for a route to a rights request, just like Apply(), but returns a step-by-step account of
+how the result was reached. Useful for reviewing policies; use Apply() to serve requests.
+*/
+func (pm *PolicyManager) Explain(ctx context.Context, route string, request *RightsRequest) (Explanation, error) {
+	exp := Explanation{
+		Candidates: make([]ExplainCandidate, 0),
+		Matches:    make([]ExplainMatch, 0),
+		Steps:      make([]ExplainStep, 0),
+	}
+	_, _, err := pm.current.Load().evaluate(ctx, route, request, &exp)
+	return exp, err
+}
@@ -0,0 +1,66 @@
+package acacia
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	pm := NewPolicyManager()
+	match := `{"realmId":["test"]}`
+	err := pm.AddPolicy("grant", Policy{
+		Manifest: PolicyManifest{ID: "grant", Priority: 10},
+		Routes:   []string{"/crm/*"},
+		Rights:   PolicyRights{Allowed: []string{"crm.read", "crm.write", "crm.delete"}},
+		Match:    match,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pm.AddPolicy("restrict", Policy{
+		Manifest: PolicyManifest{ID: "restrict", Priority: 20},
+		Routes:   []string{"/crm/:id"},
+		Rights:   PolicyRights{Allowed: []string{"crm.search"}, Denied: []string{"crm.write", "crm.delete"}},
+		Match:    match,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp, err := pm.Explain(context.Background(), "/crm/:id", &RightsRequest{RealmID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := make([]string, 0)
+	for _, c := range exp.Candidates {
+		patterns = append(patterns, c.Pattern)
+		if c.Bound != (c.Pattern == "/crm/:id" || c.Pattern == "/crm/*") {
+			t.Errorf("wrong binding for candidate %s", c.Pattern)
+		}
+	}
+	if !reflect.DeepEqual(patterns, []string{"/crm/:id", "/crm/*", "/*"}) {
+		t.Errorf("unexpected candidates %v", patterns)
+	}
+	if len(exp.Matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(exp.Matches))
+	}
+	if len(exp.Steps) != 2 || exp.Steps[0].Priority != 10 || exp.Steps[1].Priority != 20 {
+		t.Fatalf("unexpected steps %+v", exp.Steps)
+	}
+	if !reflect.DeepEqual(exp.Steps[1].Rights, []string{"crm.read", "crm.search"}) {
+		t.Errorf("unexpected rights after denials: %v", exp.Steps[1].Rights)
+	}
+	if exp.Result.Type != RESP_TYPE_RIGHTS {
+		t.Errorf("expected rights to win, got %s", exp.Result.Type)
+	}
+
+	// the explanation must agree with Apply
+	rr, err := pm.Apply(context.Background(), "/crm/:id", &RightsRequest{RealmID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rr, exp.Result) {
+		t.Errorf("Apply returned %+v, Explain returned %+v", rr, exp.Result)
+	}
+}
@@ -0,0 +1,190 @@
+package acacia
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type LintSeverity string
+
+const (
+	LINT_ERROR   LintSeverity = "error"
+	LINT_WARNING LintSeverity = "warning"
+)
+
+// A problem found in a policy. Errors will stop a policy from loading or working; warnings probably aren't what you meant.
+type LintIssue struct {
+	Policy   string       `json:"policy"`
+	Severity LintSeverity `json:"severity"`
+	Message  string       `json:"msg"`
+}
+
+func (li LintIssue) String() string {
+	return li.Policy + ": " + string(li.Severity) + ": " + li.Message
+}
+
+/*
+Checks a set of policies (keyed by name, usually the file path) for problems: invalid match patterns, missing or
+conflicting effects, overlapping routes, and policies that can never affect a result because a higher-priority policy
+always wins. If knownRights is not empty, any right that isn't in it is reported as unknown.
+*/
+func Lint(policies map[string]Policy, knownRights []string) []LintIssue {
+	issues := make([]LintIssue, 0)
+	seen := make(map[LintIssue]bool)
+	add := func(name string, sev LintSeverity, msg string) {
+		li := LintIssue{Policy: name, Severity: sev, Message: msg}
+		if !seen[li] {
+			seen[li] = true
+			issues = append(issues, li)
+		}
+	}
+
+	known := make(map[string]bool)
+	for _, r := range knownRights {
+		known[strings.ToLower(strings.TrimSpace(r))] = true
+	}
+
+	for name, p := range policies {
+		lintPolicy(name, p, known, add)
+	}
+	lintPriorities(policies, add)
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Policy != issues[j].Policy {
+			return issues[i].Policy < issues[j].Policy
+		}
+		return issues[i].Message < issues[j].Message
+	})
+	return issues
+}
+
+// Checks a single policy on its own
+func lintPolicy(name string, p Policy, known map[string]bool, add func(string, LintSeverity, string)) {
+	if p.Manifest.ID == "" {
+		add(name, LINT_WARNING, "policy has no id")
+	}
+	if len(p.Routes) == 0 {
+		add(name, LINT_ERROR, "policy has no paths")
+	}
+	if strings.TrimSpace(p.Match) == "" {
+		add(name, LINT_ERROR, "policy has no match pattern")
+	} else if err := validatePattern(name, p.Match); err != nil {
+		add(name, LINT_ERROR, "invalid match pattern: "+err.Error())
+	}
+
+	r := p.Rights
+	if len(r.Allowed) == 0 && len(r.Denied) == 0 && r.Redirect == "" && r.ReturnCode == 0 {
+		add(name, LINT_WARNING, "policy has no effects")
+	}
+	if r.ReturnCode != 0 && (r.ReturnCode < 100 || r.ReturnCode > 599) {
+		add(name, LINT_ERROR, "return code "+strconv.Itoa(r.ReturnCode)+" is not a valid HTTP status")
+	}
+	if r.ReturnMsg != "" && r.ReturnCode == 0 {
+		add(name, LINT_WARNING, "return message has no return code, so it is never sent")
+	}
+	// see PolicyManager.Apply() for how effects at the same priority are ranked
+	if r.ReturnCode > 0 && len(r.Allowed) > 0 {
+		add(name, LINT_WARNING, "return code is never sent, because the policy's allowed rights outrank it")
+	} else if r.ReturnCode > 0 && r.Redirect != "" {
+		add(name, LINT_WARNING, "redirect is never sent, because the policy's return code outranks it")
+	}
+	if r.Redirect != "" && len(r.Allowed) > 0 {
+		add(name, LINT_WARNING, "allowed rights are never granted, because the policy's redirect outranks them")
+	}
+
+	allowed := make(map[string]bool)
+	for _, right := range r.Allowed {
+		allowed[strings.ToLower(right)] = true
+	}
+	for _, right := range r.Denied {
+		if allowed[strings.ToLower(right)] {
+			add(name, LINT_WARNING, "right '"+right+"' is both allowed and denied; the allow wins within a policy")
+		}
+	}
+	if len(known) > 0 {
+		for _, right := range append(append([]string{}, r.Allowed...), r.Denied...) {
+			if !known[strings.ToLower(right)] {
+				add(name, LINT_ERROR, "unknown right '"+right+"'")
+			}
+		}
+	}
+
+	// a policy bound to a route and a wildcard covering it matches twice on that route
+	bound := make(map[string]bool)
+	for _, route := range p.Routes {
+		if bound[route] {
+			add(name, LINT_WARNING, "path "+route+" is listed more than once")
+		}
+		bound[route] = true
+	}
+	for _, route := range p.Routes {
+		for _, c := range candidatePatterns(route)[1:] {
+			if bound[c] {
+				add(name, LINT_WARNING, "paths "+route+" and "+c+" overlap")
+			}
+		}
+	}
+}
+
+/*
+Checks policies against each other. For every route, a policy that always returns a response or redirect shadows any
+lower-priority policy with the same match pattern, and two policies returning responses or redirects at the same priority
+are decided by whichever happens to match last.
+*/
+func lintPriorities(policies map[string]Policy, add func(string, LintSeverity, string)) {
+	matches := make(map[string]string)
+	routes := make(map[string][]string)
+	for name, p := range policies {
+		matches[name] = normalizeMatch(p.Match)
+		for _, route := range p.Routes {
+			routes[route] = append(routes[route], name)
+		}
+	}
+
+	for route := range routes {
+		applicable := make([]string, 0)
+		for _, c := range candidatePatterns(route) {
+			applicable = append(applicable, routes[c]...)
+		}
+		for _, s := range applicable {
+			shadower := policies[s]
+			if !alwaysBlocks(shadower) {
+				continue
+			}
+			for _, n := range applicable {
+				other := policies[n]
+				if n == s || matches[n] != matches[s] {
+					continue
+				}
+				if other.Manifest.Priority < shadower.Manifest.Priority {
+					add(n, LINT_WARNING, "unreachable on "+route+": "+s+" (priority "+strconv.Itoa(shadower.Manifest.Priority)+
+						") matches the same requests and always returns a response or redirect")
+				} else if other.Manifest.Priority == shadower.Manifest.Priority && alwaysBlocks(other) {
+					add(n, LINT_WARNING, "conflicts with "+s+" on "+route+": both return a response or redirect at priority "+
+						strconv.Itoa(shadower.Manifest.Priority))
+				}
+			}
+		}
+	}
+}
+
+// Returns true if a policy always returns a response or redirect when it matches, rather than granting rights
+func alwaysBlocks(p Policy) bool {
+	return p.Rights.Redirect != "" || (p.Rights.ReturnCode > 0 && len(p.Rights.Allowed) == 0)
+}
+
+// Reformats a JSON match pattern so that equivalent patterns compare as equal
+func normalizeMatch(match string) string {
+	var v any
+	err := json.Unmarshal([]byte(match), &v)
+	if err != nil {
+		return match
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return match
+	}
+	return string(js)
+}
@@ -0,0 +1,54 @@
+package acacia
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	match := `{"realmId":["test"]}`
+	policies := map[string]Policy{
+		"login": {
+			Manifest: PolicyManifest{ID: "login", Priority: 100},
+			Routes:   []string{"/crm/*"},
+			Rights:   PolicyRights{Redirect: "/login"},
+			Match:    match,
+		},
+		"read": {
+			Manifest: PolicyManifest{ID: "read", Priority: 10},
+			Routes:   []string{"/crm/:id", "/crm/*"},
+			Rights:   PolicyRights{Allowed: []string{"crm.read", "crm.raed"}},
+			Match:    match,
+		},
+		"broken": {
+			Manifest: PolicyManifest{ID: "broken", Priority: 10},
+			Routes:   []string{"/other"},
+			Rights:   PolicyRights{ReturnCode: 1000},
+			Match:    `{"realmId":"test"}`,
+		},
+	}
+	issues := Lint(policies, []string{"crm.read"})
+
+	expected := map[string]string{
+		"read":   "unknown right 'crm.raed'|paths /crm/:id and /crm/* overlap|unreachable on /crm/:id: login",
+		"broken": "invalid match pattern|return code 1000",
+	}
+	for name, msgs := range expected {
+		for _, msg := range strings.Split(msgs, "|") {
+			found := false
+			for _, li := range issues {
+				if li.Policy == name && strings.HasPrefix(li.Message, msg) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected issue for %s: %s", name, msg)
+			}
+		}
+	}
+	for _, li := range issues {
+		if li.Policy == "login" {
+			t.Errorf("unexpected issue %s", li)
+		}
+	}
+}
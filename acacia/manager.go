@@ -10,30 +10,178 @@ import (
 	"path/filepath"
 	"quamina.net/go/quamina"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+/*
+The PolicyManager holds the loaded set of Acacia policies and applies them to requests.
+
+Policies are held in an immutable snapshot (a policySet). Apply() works entirely against the snapshot that was current
+when it started, while changes (adding, removing, or flushing policies) build a new snapshot and swap it in atomically.
+Every snapshot has a generation number, which increases with every change and is recorded on each RightResponse.
+*/
 type PolicyManager struct {
-	Metrics  *AcaciaMetrics
-	patterns map[string]*quamina.Quamina
-	policies map[string]Policy
+	Metrics *AcaciaMetrics
+	writeMu sync.Mutex // serializes changes; readers never block
+	current atomic.Pointer[policySet]
+	watchMu sync.Mutex // guards watcher, so concurrent calls can't start two
+	watcher *policyFileWatcher
+	sinkMu  sync.RWMutex
+	sink    AuditSink
+}
+
+// An immutable set of policies and their compiled route matchers. Never modify a policySet once it has been published.
+type policySet struct {
+	generation uint64
+	patterns   map[string]*quamina.Quamina
+	policies   map[string]Policy
 }
 
 func NewPolicyManager() *PolicyManager {
 	pm := &PolicyManager{
-		Metrics:  &AcaciaMetrics{},
-		patterns: make(map[string]*quamina.Quamina),
-		policies: make(map[string]Policy),
+		Metrics: &AcaciaMetrics{},
 	}
+	pm.current.Store(&policySet{
+		generation: 0,
+		patterns:   make(map[string]*quamina.Quamina),
+		policies:   make(map[string]Policy),
+	})
 	return pm
 }
 
+// Returns the current generation of the policy set
+func (pm *PolicyManager) Generation() uint64 {
+	return pm.current.Load().generation
+}
+
+/*
+Applies a change to a copy of the current policy set and publishes it. The change function gets a copy of the policy map
+to modify, and returns the routes whose matchers need to be rebuilt. If the change (or a rebuild) fails, nothing is
+published.
+*/
+func (pm *PolicyManager) update(change func(policies map[string]Policy) ([]string, error)) error {
+	pm.writeMu.Lock()
+	defer pm.writeMu.Unlock()
+	old := pm.current.Load()
+
+	policies := make(map[string]Policy, len(old.policies))
+	for k, v := range old.policies {
+		policies[k] = v
+	}
+	routes, err := change(policies)
+	if err != nil {
+		return err
+	}
+
+	patterns := make(map[string]*quamina.Quamina, len(old.patterns))
+	for k, v := range old.patterns {
+		patterns[k] = v
+	}
+	for _, route := range routes {
+		q, err := buildMatcher(route, policies)
+		if err != nil {
+			return err
+		}
+		if q == nil {
+			delete(patterns, route)
+		} else {
+			patterns[route] = q
+		}
+	}
+
+	pm.current.Store(&policySet{
+		generation: old.generation + 1,
+		patterns:   patterns,
+		policies:   policies,
+	})
+	return nil
+}
+
+// Builds a new matcher for a route from every policy bound to it. Returns nil if no policies are bound to the route.
+func buildMatcher(route string, policies map[string]Policy) (*quamina.Quamina, error) {
+	var q *quamina.Quamina
+	for name, policy := range policies {
+		bound := false
+		for _, r := range policy.Routes {
+			if r == route {
+				bound = true
+				break
+			}
+		}
+		if !bound {
+			continue
+		}
+		if q == nil {
+			var err error
+			q, err = newMatcher()
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := q.AddPattern(name, policy.Match)
+		if err != nil {
+			return nil, errors.New("invalid match pattern in policy " + name + ": " + err.Error())
+		}
+	}
+	return q, nil
+}
+
+// Removes all policies bound to a route. Policies that are also bound to other routes remain in force for those routes.
 func (pm *PolicyManager) FlushAllFor(route string) {
-	// TODO
+	err := pm.update(func(policies map[string]Policy) ([]string, error) {
+		for name, policy := range policies {
+			routes := make([]string, 0, len(policy.Routes))
+			for _, r := range policy.Routes {
+				if r != route {
+					routes = append(routes, r)
+				}
+			}
+			if len(routes) == 0 {
+				delete(policies, name)
+			} else if len(routes) != len(policy.Routes) {
+				policy.Routes = routes
+				policies[name] = policy
+			}
+		}
+		return []string{route}, nil
+	})
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "ACAC", "error", "error flushing policies for route "+route+": "+err.Error())
+		return
+	}
+	logging.LogToDeck(context.Background(), "info", "ACAC", "info", "flushed policies for route "+route)
 }
 
+// Removes all policies from the manager
 func (pm *PolicyManager) FlushAll() {
-	// TODO
+	pm.writeMu.Lock()
+	defer pm.writeMu.Unlock()
+	old := pm.current.Load()
+	pm.current.Store(&policySet{
+		generation: old.generation + 1,
+		patterns:   make(map[string]*quamina.Quamina),
+		policies:   make(map[string]Policy),
+	})
+	logging.LogToDeck(context.Background(), "info", "ACAC", "info", "flushed all policies")
+}
+
+// Returns a copy of all loaded policies, keyed by policy name
+func (pm *PolicyManager) Policies() map[string]Policy {
+	set := pm.current.Load()
+	ps := make(map[string]Policy, len(set.policies))
+	for k, v := range set.policies {
+		ps[k] = v
+	}
+	return ps
+}
+
+// Returns true if a policy with the given name is loaded
+func (pm *PolicyManager) HasPolicy(name string) bool {
+	_, ok := pm.current.Load().policies[name]
+	return ok
 }
 
 func (pm *PolicyManager) LoadAllFrom(dirName string) error {
@@ -51,12 +199,7 @@ func (pm *PolicyManager) LoadAllFrom(dirName string) error {
 		}
 		if strings.HasSuffix(info.Name(), ".acacia") {
 			// compile Acacia file
-			input, err := os.ReadFile(path)
-			if err != nil {
-				return err
-			}
-			p, _ := NewParser(string(input))
-			policy, err := p.Parse()
+			policy, err := LoadPolicyFile(path)
 			if err != nil {
 				return err
 			}
@@ -74,24 +217,156 @@ func (pm *PolicyManager) LoadAllFrom(dirName string) error {
 	return nil
 }
 
-func (pm *PolicyManager) AddPolicy(name string, policy Policy) error {
-	for _, route := range policy.Routes {
-		if _, ok := pm.patterns[route]; !ok {
-			q, err := quamina.New(quamina.WithMediaType("application/json"), quamina.WithPatternDeletion(true))
+/*
+Replaces every loaded policy with the policies in a directory, as a single change. Policies whose files no longer exist
+are dropped. If any file fails to parse or compile, nothing changes and the error is returned.
+*/
+func (pm *PolicyManager) ReloadAllFrom(dirName string) error {
+	loaded := make(map[string]Policy)
+	err := filepath.Walk(dirName, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".acacia") {
+			policy, err := LoadPolicyFile(path)
 			if err != nil {
-				return err
+				return errors.New(path + ": " + err.Error())
+			}
+			err = validatePattern(path, policy.Match)
+			if err != nil {
+				return errors.New("invalid match pattern in policy " + path + ": " + err.Error())
 			}
-			pm.patterns[route] = q
+			loaded[path] = policy
 		}
-		logging.LogToDeck(context.Background(), "info", "ACAC", "info", "adding policy to route "+route)
-		pm.policies[name] = policy
-		pm.patterns[route].AddPattern(name, policy.Match)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	err = pm.update(func(policies map[string]Policy) ([]string, error) {
+		routes := make([]string, 0)
+		for name, policy := range policies {
+			routes = append(routes, policy.Routes...)
+			delete(policies, name)
+		}
+		for name, policy := range loaded {
+			routes = append(routes, policy.Routes...)
+			policies[name] = policy
+		}
+		return common.Dedupe[string](routes), nil
+	})
+	if err != nil {
+		return err
+	}
+	logging.LogToDeck(context.Background(), "info", "ACAC", "info", "reloaded "+strconv.Itoa(len(loaded))+" policies from "+dirName)
 	return nil
 }
 
+// Reads and parses a single .acacia policy file
+func LoadPolicyFile(fileName string) (Policy, error) {
+	input, err := os.ReadFile(fileName)
+	if err != nil {
+		return Policy{}, err
+	}
+	p, err := NewParser(string(input))
+	if err != nil {
+		return Policy{}, err
+	}
+	return p.Parse()
+}
+
+/*
+Adds a policy under a unique name (policies loaded from disk are named by their file path). If a policy with the same
+name already exists, it is replaced. The new policy set is only published if every affected route compiles, so a policy
+that fails to compile leaves any existing version of the policy in force.
+*/
+func (pm *PolicyManager) AddPolicy(name string, policy Policy) error {
+	err := validatePattern(name, policy.Match)
+	if err != nil {
+		return errors.New("invalid match pattern in policy " + name + ": " + err.Error())
+	}
+	return pm.update(func(policies map[string]Policy) ([]string, error) {
+		routes := append([]string{}, policy.Routes...)
+		if old, ok := policies[name]; ok {
+			routes = append(routes, old.Routes...)
+		}
+		for _, route := range policy.Routes {
+			logging.LogToDeck(context.Background(), "info", "ACAC", "info", "adding policy to route "+route)
+		}
+		policies[name] = policy
+		return common.Dedupe[string](routes), nil
+	})
+}
+
+// Removes a policy by name, deleting its match patterns from every route it is bound to.
+func (pm *PolicyManager) RemovePolicy(name string) {
+	removed := false
+	err := pm.update(func(policies map[string]Policy) ([]string, error) {
+		old, ok := policies[name]
+		if !ok {
+			return nil, nil
+		}
+		removed = true
+		delete(policies, name)
+		return old.Routes, nil
+	})
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "ACAC", "error", "error removing policy "+name+": "+err.Error())
+		return
+	}
+	if removed {
+		logging.LogToDeck(context.Background(), "info", "ACAC", "info", "removed policy "+name)
+	}
+}
+
+func newMatcher() (*quamina.Quamina, error) {
+	return quamina.New(quamina.WithMediaType("application/json"), quamina.WithPatternDeletion(true))
+}
+
+// Checks that a match pattern compiles, without touching any live matchers
+func validatePattern(name string, pattern string) error {
+	q, err := newMatcher()
+	if err != nil {
+		return err
+	}
+	return q.AddPattern(name, pattern)
+}
+
+// Applies the loaded policies for a route to a rights request
 func (pm *PolicyManager) Apply(ctx context.Context, route string, request *RightsRequest) (RightResponse, error) {
+	// Everything works against a single snapshot, so concurrent changes can't give us a mix of policy versions
+	set := pm.current.Load()
+	rr, matched, err := set.evaluate(ctx, route, request, nil)
+	if err != nil {
+		return rr, err
+	}
+	pm.audit(ctx, set, route, request, rr, matched)
+	return rr, nil
+}
+
+// Returns every route pattern that may hold policies for a route: the route itself, then wildcards from most to least specific
+func candidatePatterns(route string) []string {
+	var allPatterns []string = []string{route}
+	routeElems := strings.Split(route, "/")
+	// /foo/bar/123 == len(3)
+	for x := len(routeElems); x > 0; x-- {
+		subroute := (strings.Join(routeElems[:x-1], "/") + "/*")
+		// there's a more elegant way to do this, surely.
+		if subroute == "//*" {
+			subroute = "/*"
+		}
+		allPatterns = append(allPatterns, subroute)
+	}
+	return common.Dedupe[string](allPatterns)
+}
+
+/*
+Evaluates a rights request against the policy set, returning the result and the names of the policies that matched. If
+exp is not nil, each step of the evaluation is recorded in it.
+*/
+func (set *policySet) evaluate(ctx context.Context, route string, request *RightsRequest, exp *Explanation) (RightResponse, []string, error) {
 	rr := RightResponse{
+		Generation: set.generation,
 		Response: RightCodeResponse{
 			ReturnMsg:  "",
 			ReturnCode: 0,
@@ -100,47 +375,47 @@ func (pm *PolicyManager) Apply(ctx context.Context, route string, request *Right
 		Rights:   make([]string, 0),
 		Metadata: make(map[string]string),
 	}
+	if exp != nil {
+		exp.Generation = set.generation
+		exp.Route = route
+	}
 
-	// TODO -- check to see if any wildcard directories exist
 	var allQuams []*quamina.Quamina = make([]*quamina.Quamina, 0)
 	var allMatches []quamina.X = make([]quamina.X, 0)
-	var allPatterns []string = []string{route}
-	{
-		routeElems := strings.Split(route, "/")
-		// /foo/bar/123 == len(3)
-		for x := len(routeElems); x > 0; x-- {
-			subroute := (strings.Join(routeElems[:x-1], "/") + "/*")
-			// there's a more elegant way to do this, surely.
-			if subroute == "//*" {
-				subroute = "/*"
-			}
-			allPatterns = append(allPatterns, subroute)
-		}
-	}
+	allPatterns := candidatePatterns(route)
 	var foundMatch bool
+	matched := make([]string, 0)
 
 	for x := 0; x < len(allPatterns); x++ {
-		routeQ, ok := pm.patterns[allPatterns[x]]
+		routeQ, ok := set.patterns[allPatterns[x]]
 		if ok {
-			allQuams = append(allQuams, routeQ)
+			// Copy() gives us our own matching state over the shared (and immutable) matcher
+			allQuams = append(allQuams, routeQ.Copy())
 			foundMatch = true
 		}
+		if exp != nil {
+			exp.Candidates = append(exp.Candidates, ExplainCandidate{Pattern: allPatterns[x], Bound: ok})
+		}
 	}
 	// route not registered
 	if !foundMatch {
 		logging.LogToDeck(ctx, "error", "ACAC", "error", "attempted to call Acacia on unbound route "+route)
-		return rr, nil
+		if exp != nil {
+			exp.Reason = "no policies are bound to this route"
+			exp.Result = rr
+		}
+		return rr, matched, nil
 	}
 
 	js, err := json.Marshal(*request)
 	if err != nil {
-		return rr, err
+		return rr, matched, err
 	}
 
 	for _, q := range allQuams {
 		resps, err := q.MatchesForEvent(js)
 		if err != nil {
-			return rr, err
+			return rr, matched, err
 		}
 		for _, r := range resps {
 			allMatches = append(allMatches, r)
@@ -157,12 +432,24 @@ func (pm *PolicyManager) Apply(ctx context.Context, route string, request *Right
 	topApprovalPri := -999999
 
 	for _, resp_id := range allMatches {
-		resp, ok := pm.policies[resp_id.(string)]
+		resp, ok := set.policies[resp_id.(string)]
 		if !ok {
-			return rr, errors.New("could not access policy ID " + resp_id.(string))
+			return rr, matched, errors.New("could not access policy ID " + resp_id.(string))
 		}
 		pri := resp.Manifest.Priority
 		pris = append(pris, pri)
+		matched = append(matched, resp_id.(string))
+		if exp != nil {
+			exp.Matches = append(exp.Matches, ExplainMatch{
+				Name:       resp_id.(string),
+				PolicyID:   resp.Manifest.ID,
+				Priority:   pri,
+				Allowed:    resp.Rights.Allowed,
+				Denied:     resp.Rights.Denied,
+				Redirect:   resp.Rights.Redirect,
+				ReturnCode: resp.Rights.ReturnCode,
+			})
+		}
 
 		// grab any return code (note that if there's a tie in priority, last-in wins)
 		if resp.Rights.ReturnCode > 0 {
@@ -184,14 +471,6 @@ func (pm *PolicyManager) Apply(ctx context.Context, route string, request *Right
 		}
 
 		// get approvals/denials
-		_, ok = approvals[pri]
-		if !ok {
-			approvals[pri] = make([]string, 0)
-		}
-		_, ok = denials[pri]
-		if !ok {
-			denials[pri] = make([]string, 0)
-		}
 		if len(resp.Rights.Allowed) > 0 && pri > topApprovalPri {
 			topApprovalPri = pri
 		}
@@ -203,44 +482,66 @@ func (pm *PolicyManager) Apply(ctx context.Context, route string, request *Right
 	if topRespPri >= topRedirPri && topRespPri > topApprovalPri && len(responses) > 0 {
 		rr.Response = responses[topRespPri]
 		rr.Type = RESP_TYPE_RESPONSE
-		return rr, nil
+		if exp != nil {
+			exp.Reason = "return code at priority " + strconv.Itoa(topRespPri) + " outranks any redirect or allowed rights"
+			exp.Result = rr
+		}
+		return rr, matched, nil
 	}
 
 	// if redirect pri >= approval pri
 	if topRedirPri >= topApprovalPri && len(redirects) > 0 {
 		rr.Redirect = redirects[topRedirPri]
 		rr.Type = RESP_TYPE_REDIRECT
-		return rr, nil
+		if exp != nil {
+			exp.Reason = "redirect at priority " + strconv.Itoa(topRedirPri) + " outranks any allowed rights"
+			exp.Result = rr
+		}
+		return rr, matched, nil
 	}
 
 	// Default: determine approval rights. Note that we normalize rights to lower case
+	// policies at the same priority are applied together, and only once
+	pris = common.Dedupe[int](pris)
 	sort.Ints(pris)
 	approved := make([]string, 0)
-	// apply by priority
+	// apply by priority, lowest first, so higher-priority policies can deny rights granted by lower ones
 	for _, i := range pris {
-		if denials[i] != nil && len(denials[i]) > 0 {
-			apps := make([]string, 0)
-
-			// remove any denials
-			for _, den := range denials[i] {
-				// remove from approved
-				for _, app := range approved {
-					if strings.ToLower(app) != strings.ToLower(den) {
-						apps = append(apps, strings.ToLower(app))
-					}
+		denied := make(map[string]bool)
+		for _, den := range denials[i] {
+			denied[strings.ToLower(den)] = true
+		}
+		if len(denied) > 0 {
+			apps := make([]string, 0, len(approved))
+			for _, app := range approved {
+				if !denied[app] {
+					apps = append(apps, app)
 				}
 			}
 			approved = apps
 		}
-		if approvals[i] != nil && len(approvals[i]) > 0 {
-			for _, app := range approvals[i] {
-				approved = append(approved, strings.ToLower(app))
-			}
+		for _, app := range approvals[i] {
+			approved = append(approved, strings.ToLower(app))
+		}
+		approved = common.Dedupe[string](approved)
+		if exp != nil {
+			exp.Steps = append(exp.Steps, ExplainStep{
+				Priority: i,
+				Allowed:  common.Dedupe[string](approvals[i]),
+				Denied:   common.Dedupe[string](denials[i]),
+				Rights:   append([]string{}, approved...),
+			})
 		}
 	}
 
-	approved = common.Dedupe[string](approved)
 	rr.Type = RESP_TYPE_RIGHTS
 	rr.Rights = approved
-	return rr, nil
+	if exp != nil {
+		exp.Reason = "no return code or redirect outranks the allowed rights"
+		if len(matched) == 0 {
+			exp.Reason = "no policies matched the request"
+		}
+		exp.Result = rr
+	}
+	return rr, matched, nil
 }
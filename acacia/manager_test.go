@@ -0,0 +1,255 @@
+package acacia
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func testPolicy(t *testing.T, id string, right string) Policy {
+	p, err := NewParser(testPolicySource(id, right))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+// Run with -race: readers apply policies while writers replace, remove, and flush them
+func TestPolicyManagerConcurrency(t *testing.T) {
+	pm := NewPolicyManager()
+	versions := []Policy{testPolicy(t, "crm", "crm.v0"), testPolicy(t, "crm", "crm.v1")}
+	extra := testPolicy(t, "extra", "crm.extra")
+	other := testPolicy(t, "other", "other.read")
+	other.Routes = []string{"/other/:id"}
+
+	if err := pm.AddPolicy("base", testPolicy(t, "base", "crm.base")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.AddPolicy("versioned", versions[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	const iterations = 500
+	var wg sync.WaitGroup
+	done := make(chan bool)
+	errs := make(chan string, 64)
+
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lastGen uint64
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				res, err := pm.Apply(context.Background(), "/crm/:id", &RightsRequest{RealmID: "test"})
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				if res.Generation < lastGen {
+					errs <- "generation went backwards"
+					return
+				}
+				lastGen = res.Generation
+				rights := make(map[string]bool)
+				for _, v := range res.Rights {
+					rights[v] = true
+				}
+				if !rights["crm.base"] {
+					errs <- "base policy missing"
+					return
+				}
+				if rights["crm.v0"] == rights["crm.v1"] {
+					errs <- "saw both or neither version of a replaced policy"
+					return
+				}
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	writers.Add(3)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < iterations; i++ {
+			if err := pm.AddPolicy("versioned", versions[i%2]); err != nil {
+				errs <- err.Error()
+				return
+			}
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < iterations; i++ {
+			if err := pm.AddPolicy("extra", extra); err != nil {
+				errs <- err.Error()
+				return
+			}
+			pm.RemovePolicy("extra")
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < iterations; i++ {
+			if err := pm.AddPolicy("other", other); err != nil {
+				errs <- err.Error()
+				return
+			}
+			pm.FlushAllFor("/other/:id")
+		}
+	}()
+	writers.Wait()
+	close(done)
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Fatal(e)
+	}
+
+	// 2 initial adds, plus 1 per replacement and 2 per add/remove or add/flush cycle
+	if gen := pm.Generation(); gen != 2+5*iterations {
+		t.Fatalf("expected generation %d, got %d", 2+5*iterations, gen)
+	}
+	if pm.HasPolicy("extra") || pm.HasPolicy("other") {
+		t.Fatal("removed policies still loaded")
+	}
+	pm.FlushAll()
+	if len(pm.Policies()) != 0 || hasRight(pm, "crm.base") {
+		t.Fatal("policies remain after FlushAll")
+	}
+}
+
+func TestReloadAllFrom(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, right string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(testPolicySource(name, right)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.acacia", "crm.a")
+	write("b.acacia", "crm.b")
+	pm := NewPolicyManager()
+	if err := pm.LoadAllFrom(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// a broken file leaves everything in place
+	os.Remove(filepath.Join(dir, "b.acacia"))
+	os.WriteFile(filepath.Join(dir, "c.acacia"), []byte("<policy><manifest>"), 0644)
+	if err := pm.ReloadAllFrom(dir); err == nil {
+		t.Fatal("expected an error reloading a broken policy")
+	}
+	if !hasRight(pm, "crm.a") || !hasRight(pm, "crm.b") {
+		t.Fatal("failed reload changed the loaded policies")
+	}
+
+	write("c.acacia", "crm.c")
+	if err := pm.ReloadAllFrom(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !hasRight(pm, "crm.a") || hasRight(pm, "crm.b") || !hasRight(pm, "crm.c") {
+		t.Fatalf("unexpected policies after reload: %v", pm.Policies())
+	}
+}
+
+func applyRights(t *testing.T, pm *PolicyManager) []string {
+	t.Helper()
+	res, err := pm.Apply(context.Background(), "/crm/:id", &RightsRequest{RealmID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Type != RESP_TYPE_RIGHTS {
+		t.Fatalf("expected rights, got %s", res.Type)
+	}
+	sort.Strings(res.Rights)
+	return res.Rights
+}
+
+func TestApplyPriorities(t *testing.T) {
+	add := func(pm *PolicyManager, name string, pri int, allowed []string, denied []string) {
+		err := pm.AddPolicy(name, Policy{
+			Manifest: PolicyManifest{ID: name, Priority: pri},
+			Routes:   []string{"/crm/:id"},
+			Rights:   PolicyRights{Allowed: allowed, Denied: denied},
+			Match:    `{"realmId":["test"]}`,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every denial at a priority is applied, not just the first
+	pm := NewPolicyManager()
+	add(pm, "grant", 10, []string{"crm.read", "crm.write", "crm.delete"}, nil)
+	add(pm, "restrict", 20, nil, []string{"crm.write", "crm.delete"})
+	if rights := applyRights(t, pm); !reflect.DeepEqual(rights, []string{"crm.read"}) {
+		t.Fatalf("unexpected rights with two denials: %v", rights)
+	}
+
+	// a denial outranks lower allows, and is outranked by higher ones
+	pm = NewPolicyManager()
+	add(pm, "low", 10, []string{"crm.read", "crm.write"}, nil)
+	add(pm, "mid", 20, nil, []string{"crm.read", "crm.write"})
+	add(pm, "high", 30, []string{"crm.write"}, nil)
+	if rights := applyRights(t, pm); !reflect.DeepEqual(rights, []string{"crm.write"}) {
+		t.Fatalf("unexpected rights across priorities: %v", rights)
+	}
+
+	// policies sharing a priority are applied together, once
+	pm = NewPolicyManager()
+	add(pm, "grant", 10, []string{"crm.read", "crm.write"}, nil)
+	add(pm, "deny-a", 20, nil, []string{"crm.read"})
+	add(pm, "deny-b", 20, []string{"crm.search"}, []string{"crm.write"})
+	if rights := applyRights(t, pm); !reflect.DeepEqual(rights, []string{"crm.search"}) {
+		t.Fatalf("unexpected rights with a shared priority: %v", rights)
+	}
+}
+
+func TestParsedEffects(t *testing.T) {
+	p, err := NewParser(`<policy>
+	<manifest>
+		<id>effects</id>
+		<priority>10</priority>
+	</manifest>
+	<paths>
+		<path>/crm/:id</path>
+	</paths>
+	<effects>
+		<allow>"crm.read" "crm.write"</allow>
+		<deny>"crm.delete"</deny>
+	</effects>
+	<matches>
+		<match type="json">{"realmId":["test"]}</match>
+	</matches>
+</policy>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(policy.Rights.Allowed, []string{"crm.read", "crm.write"}) || !reflect.DeepEqual(policy.Rights.Denied, []string{"crm.delete"}) {
+		t.Fatalf("unexpected effects %+v", policy.Rights)
+	}
+	pm := NewPolicyManager()
+	if err := pm.AddPolicy("effects", policy); err != nil {
+		t.Fatal(err)
+	}
+	if rights := applyRights(t, pm); !reflect.DeepEqual(rights, []string{"crm.read", "crm.write"}) {
+		t.Fatalf("unexpected rights from a parsed policy: %v", rights)
+	}
+}
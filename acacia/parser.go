@@ -213,13 +213,13 @@ func readEffects(p *Policy, i *int, toks *[]token.Token) error {
 		if tok.Type == "startopentag" && tok.Literal == "<allow" {
 			rights := readStringArraysFromElement(i, toks)
 
-			p.Rights.Allowed = make([]string, len(rights))
+			p.Rights.Allowed = make([]string, 0, len(rights))
 			for _, v := range rights {
 				p.Rights.Allowed = append(p.Rights.Allowed, v[1:len(v)-1])
 			}
 		} else if tok.Type == "startopentag" && tok.Literal == "<deny" {
 			rights := readStringArraysFromElement(i, toks)
-			p.Rights.Denied = make([]string, len(rights))
+			p.Rights.Denied = make([]string, 0, len(rights))
 			for _, v := range rights {
 				p.Rights.Denied = append(p.Rights.Denied, v[1:len(v)-1])
 			}
@@ -252,11 +252,72 @@ func readEffects(p *Policy, i *int, toks *[]token.Token) error {
 	return nil
 }
 
+/*
+Reads the log section, which holds <permit>, <deny>, and <any> groups of <msg> elements:
+
+	<log>
+		<deny>
+			<msg src="AUDIT" pri="warn">user {{user.userId}} denied {{http.tgtPath}}</msg>
+		</deny>
+	</log>
+*/
 func readLogs(p *Policy, i *int, toks *[]token.Token) error {
-	*i++
+	var group *[]PolicyLog
+	tok := (*toks)[*i]
+	for tok.Type != "eof" && tok.Type != "error" && tok.Literal != "</log>" {
+		if tok.Type == "startopentag" {
+			switch tok.Literal {
+			case "<permit":
+				group = &p.Logging.OnPermit
+			case "<deny":
+				group = &p.Logging.OnDeny
+			case "<any", "<all":
+				group = &p.Logging.OnAny
+			case "<msg":
+				if group == nil {
+					return errors.New("log message must be inside a permit, deny, or any group")
+				}
+				l := readLogMessage(i, toks)
+				*group = append(*group, l)
+			}
+		} else if tok.Type == "closetag" && tok.Literal != "</msg>" {
+			group = nil
+		}
+		*i++
+		tok = (*toks)[*i]
+	}
+	if tok.Type == "eof" {
+		return errors.New("unexpected eof, log section not closed")
+	} else if tok.Type == "error" {
+		return errors.New("unexpected error: " + tok.Literal)
+	}
 	return nil
 }
 
+// reads the src and pri attributes and message text of a <msg> element
+func readLogMessage(i *int, toks *[]token.Token) PolicyLog {
+	l := PolicyLog{}
+	tok := (*toks)[*i]
+	for tok.Type != "endopentag" && tok.Type != "eof" && tok.Type != "error" {
+		if tok.Type == "id" && *i+2 < len(*toks) && (*toks)[*i+1].Type == "assign" {
+			val := (*toks)[*i+2].Literal
+			if (*toks)[*i+2].Type == "string" && len(val) > 1 {
+				val = val[1 : len(val)-1]
+			}
+			if tok.Literal == "src" {
+				l.Source = val
+			} else if tok.Literal == "pri" {
+				l.Priority = val
+			}
+			*i += 2
+		}
+		*i++
+		tok = (*toks)[*i]
+	}
+	l.Message = strings.TrimSpace(readTextFromElement(i, toks))
+	return l
+}
+
 func readMatches(p *Policy, i *int, toks *[]token.Token) error {
 	tok := (*toks)[*i]
 	for tok.Type != "closetag" && tok.Type != "eof" && tok.Type != "error" && tok.Literal != "</paths>" {
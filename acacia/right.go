@@ -19,4 +19,6 @@ type RightResponse struct {
 	Redirect string            `json:"redirectTo"`
 	Rights   []string          `json:"rights"`
 	Metadata map[string]string `json:"meta"`
+	// The generation of the policy set this response was evaluated against
+	Generation uint64 `json:"generation"`
 }
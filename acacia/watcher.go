@@ -1,5 +1,180 @@
 package acacia
 
+import (
+	"context"
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"github.com/highgrav/taproot/common"
+	"github.com/highgrav/taproot/logging"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How long to wait after a policy file disappears before removing its policy. Many editors save by renaming or deleting
+// the old file and then writing a new one, and we don't want to drop a policy (and possibly open up a route) in between.
+const POLICY_REMOVAL_GRACE_PERIOD time.Duration = 250 * time.Millisecond
+
 type policyFileWatcher struct {
 	policyManager *PolicyManager
+	dirName       string
+	watcher       *fsnotify.Watcher
+	removals      chan string
+	done          chan bool
+}
+
+/*
+Watches a policy directory (and its subdirectories) for changes to .acacia files. Changed files are re-parsed and swapped
+in; deleted files have their policies removed. If a changed file fails to parse, the error is logged and the previous
+version of the policy stays in force.
+*/
+func (pm *PolicyManager) WatchForChanges(dirName string) error {
+	pm.watchMu.Lock()
+	defer pm.watchMu.Unlock()
+	if pm.watcher != nil {
+		return errors.New("already watching policy directory " + pm.watcher.dirName)
+	}
+	dirList := []string{dirName}
+	subdirs, err := common.GetDirs(dirName)
+	if err != nil {
+		return err
+	}
+	dirList = append(dirList, subdirs...)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, v := range dirList {
+		err = watcher.Add(v)
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	pfw := &policyFileWatcher{
+		policyManager: pm,
+		dirName:       dirName,
+		watcher:       watcher,
+		removals:      make(chan string),
+		done:          make(chan bool),
+	}
+	pm.watcher = pfw
+	go pfw.watch()
+	logging.LogToDeck(context.Background(), "info", "ACAC", "info", "watching policy directory "+dirName)
+	return nil
+}
+
+// Stops watching the policy directory, if it is being watched
+func (pm *PolicyManager) StopWatching() {
+	pm.watchMu.Lock()
+	defer pm.watchMu.Unlock()
+	if pm.watcher == nil {
+		return
+	}
+	close(pm.watcher.done)
+	pm.watcher = nil
+}
+
+func (pfw *policyFileWatcher) watch() {
+	defer pfw.watcher.Close()
+	for {
+		select {
+		case <-pfw.done:
+			return
+		case path := <-pfw.removals:
+			pfw.removeIfGone(path)
+		case event, ok := <-pfw.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				fileInfo, err := os.Stat(event.Name)
+				if err == nil && fileInfo.IsDir() {
+					pfw.addDir(event.Name)
+					continue
+				}
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				if strings.HasSuffix(event.Name, ".acacia") {
+					pfw.reload(event.Name)
+				}
+			}
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				// We can't fstat a removed file, so we let the removal settle and check again
+				pfw.watcher.Remove(event.Name)
+				path := event.Name
+				time.AfterFunc(POLICY_REMOVAL_GRACE_PERIOD, func() {
+					select {
+					case pfw.removals <- path:
+					case <-pfw.done:
+					}
+				})
+			}
+		case err, ok := <-pfw.watcher.Errors:
+			if !ok {
+				return
+			}
+			logging.LogToDeck(context.Background(), "error", "ACAC", "error", "error in policy file watcher: "+err.Error())
+		}
+	}
+}
+
+// Re-parses a policy file and swaps it in, keeping the previous version if it fails to parse or compile
+func (pfw *policyFileWatcher) reload(path string) {
+	policy, err := LoadPolicyFile(path)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "ACAC", "error", "error parsing policy file "+path+", keeping previous version: "+err.Error())
+		return
+	}
+	err = pfw.policyManager.AddPolicy(path, policy)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "ACAC", "error", "error loading policy file "+path+", keeping previous version: "+err.Error())
+		return
+	}
+	logging.LogToDeck(context.Background(), "info", "ACAC", "info", "reloaded policy file "+path)
+}
+
+// Starts watching a newly-created directory, and loads any policy files that were created along with it
+func (pfw *policyFileWatcher) addDir(dirName string) {
+	logging.LogToDeck(context.Background(), "info", "ACAC", "info", "watching new policy directory "+dirName)
+	err := pfw.watcher.Add(dirName)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "ACAC", "error", "error watching policy directory "+dirName+": "+err.Error())
+	}
+	filepath.Walk(dirName, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && path != dirName {
+			pfw.watcher.Add(path)
+		} else if strings.HasSuffix(info.Name(), ".acacia") {
+			pfw.reload(path)
+		}
+		return nil
+	})
+}
+
+// Removes the policy for a file (or every policy under a directory) once the file is confirmed to be gone
+func (pfw *policyFileWatcher) removeIfGone(path string) {
+	if _, err := os.Stat(path); err == nil {
+		if strings.HasSuffix(path, ".acacia") {
+			// replaced rather than removed, so make sure we have the latest version
+			pfw.reload(path)
+		}
+		return
+	}
+	pm := pfw.policyManager
+	if pm.HasPolicy(path) {
+		pm.RemovePolicy(path)
+		return
+	}
+	dirPrefix := path + string(os.PathSeparator)
+	for name := range pm.Policies() {
+		if strings.HasPrefix(name, dirPrefix) {
+			pm.RemovePolicy(name)
+		}
+	}
 }
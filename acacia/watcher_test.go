@@ -0,0 +1,144 @@
+package acacia
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testPolicySource(id string, right string) string {
+	return `<policy>
+	<manifest>
+		<id>` + id + `</id>
+		<priority>10</priority>
+	</manifest>
+	<paths>
+		<path>/crm/:id</path>
+	</paths>
+	<effects>
+		<allow>"` + right + `"</allow>
+	</effects>
+	<matches>
+		<match type="json">{"realmId":["test"]}</match>
+	</matches>
+</policy>`
+}
+
+func hasRight(pm *PolicyManager, right string) bool {
+	rr := &RightsRequest{RealmID: "test"}
+	res, err := pm.Apply(context.Background(), "/crm/:id", rr)
+	if err != nil {
+		return false
+	}
+	for _, r := range res.Rights {
+		if r == right {
+			return true
+		}
+	}
+	return false
+}
+
+// polls until a condition is met, since file events arrive asynchronously
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for " + msg)
+}
+
+func TestPolicyWatcher(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "crm.acacia")
+	err := os.WriteFile(fileName, []byte(testPolicySource("crm", "crm.read")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pm := NewPolicyManager()
+	err = pm.LoadAllFrom(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pm.WatchForChanges(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.StopWatching()
+
+	if !hasRight(pm, "crm.read") {
+		t.Fatal("expected crm.read from initial policy")
+	}
+
+	// an edit is swapped in
+	err = os.WriteFile(fileName, []byte(testPolicySource("crm", "crm.write")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "edited policy", func() bool {
+		return hasRight(pm, "crm.write") && !hasRight(pm, "crm.read")
+	})
+
+	// a broken edit keeps the previous version
+	err = os.WriteFile(fileName, []byte(`<policy><manifest><priority>abc</priority></manifest></policy>`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if !hasRight(pm, "crm.write") {
+		t.Fatal("expected previous policy to remain after parse error")
+	}
+
+	// a new file in a new subdirectory is picked up
+	subDir := filepath.Join(dir, "sub")
+	err = os.Mkdir(subDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	err = os.WriteFile(filepath.Join(subDir, "more.acacia"), []byte(testPolicySource("more", "crm.list")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "new policy", func() bool {
+		return hasRight(pm, "crm.list")
+	})
+
+	// a deleted file has its patterns removed
+	err = os.Remove(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "policy removal", func() bool {
+		return !hasRight(pm, "crm.write") && !pm.HasPolicy(fileName)
+	})
+	if !hasRight(pm, "crm.list") {
+		t.Fatal("expected unrelated policy to remain after removal")
+	}
+}
+
+func TestWatchConcurrently(t *testing.T) {
+	pm := NewPolicyManager()
+	dir := t.TempDir()
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			errs <- pm.WatchForChanges(dir)
+		}()
+	}
+	started := 0
+	for i := 0; i < 8; i++ {
+		if <-errs == nil {
+			started++
+		}
+	}
+	pm.StopWatching()
+	if started != 1 {
+		t.Fatalf("expected one watcher to start, got %d", started)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/highgrav/taproot/authtoken"
 	"github.com/highgrav/taproot/cron"
 	"github.com/highgrav/taproot/jsrun"
+	"github.com/highgrav/taproot/messages"
 	"github.com/highgrav/taproot/pagecache"
 	"github.com/highgrav/taproot/session"
 	"github.com/highgrav/taproot/sse"
@@ -49,6 +50,7 @@ type AppServer struct {
 	WSHubs       map[string]*websock.WSHub
 	WorkHub      *workers.WorkQueue
 	CronHub      *cron.CronHub
+	MessageBus   *messages.MessageBus // Relays hub writes between server instances, if a broker is configured
 	SignatureMgr *authtoken.AuthSignerManager
 	PageCache    *pagecache.PageCache
 
@@ -63,10 +65,12 @@ type AppServer struct {
 
 	js                *jsrun.JSManager
 	jsinjections      []jsrun.InjectorFunc
+	jsfns             map[string]jsrun.ExternalJSFunction
 	state             serverStateManager
 	users             authn.IUserStore
 	globalRateLimiter *rate.Limiter
 	ipRateLimiter     map[string]*rate.Limiter
+	ipRateLimits      ipRateLimitSettings
 	httpIpFilter      *ipfilter.IPFilter
 	fflags            retriever.Retriever
 	routes            []RouteBinding
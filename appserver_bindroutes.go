@@ -35,6 +35,7 @@ func (srv *AppServer) bindRoutes() http.Handler {
 	// Standard middleware
 	defaultMiddleware := []alice.Constructor{
 		srv.HandlePanic,
+		srv.handleServerState,
 		srv.handleIPFiltering,
 		srv.HandleGlobalRateLimit,
 		srv.HandleIPRateLimit,
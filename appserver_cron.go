@@ -1,6 +1,62 @@
 package taproot
 
-import "github.com/highgrav/taproot/cron"
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/highgrav/taproot/cron"
+	"github.com/highgrav/taproot/jsrun"
+	"github.com/highgrav/taproot/logging"
+	"time"
+)
+
+// Lease table used by the sql cron lock, unless configured otherwise
+const CRON_DEFAULT_SQL_TABLE string = "taproot_cron_leases"
+
+// Creates the cron hub with the state store and lock chosen in its config
+func newCronHub(cfg CronConfig) (*cron.CronHub, error) {
+	var store cron.CronStateStore = cron.NewMemoryCronStateStore()
+	if cfg.StateFile != "" {
+		fs, err := cron.NewFileCronStateStore(cfg.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		store = fs
+	}
+	var locker cron.CronLocker
+	switch cfg.Lock {
+	case "":
+	case "file":
+		fl, err := cron.NewFileCronLocker(cfg.LockDir)
+		if err != nil {
+			return nil, err
+		}
+		locker = fl
+	case "sql":
+		db, err := sql.Open(cfg.SQLDriver, cfg.SQLDSN)
+		if err != nil {
+			return nil, err
+		}
+		table := cfg.SQLTable
+		if table == "" {
+			table = CRON_DEFAULT_SQL_TABLE
+		}
+		sl, err := cron.NewSQLCronLocker(db, cfg.SQLDriver, table)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		locker = sl
+	default:
+		return nil, errors.New("unknown cron lock '" + cfg.Lock + "'")
+	}
+	ch := cron.NewWithStore(store)
+	if locker != nil {
+		ch.SetLocker(locker)
+	}
+	return ch, nil
+}
 
 func (srv *AppServer) AddCronJob(name, schedule string, job cron.CronJob) error {
 	if srv.CronHub == nil {
@@ -9,9 +65,112 @@ func (srv *AppServer) AddCronJob(name, schedule string, job cron.CronJob) error
 	return srv.CronHub.AddJob(name, schedule, job)
 }
 
+// Adds a cron job with policies for runs missed while the server was down, and for overlapping runs
+func (srv *AppServer) AddCronJobWithOptions(name, schedule string, job cron.CronJob, opts cron.JobOptions) error {
+	if srv.CronHub == nil {
+		srv.CronHub = cron.New()
+	}
+	return srv.CronHub.AddJobWithOptions(name, schedule, job, opts)
+}
+
 func (srv *AppServer) RemoveCronJob(name string) {
 	if srv.CronHub == nil {
 		return
 	}
 	srv.CronHub.RemoveJob(name)
 }
+
+/*
+Adds a cron job that runs a server-side script. The script runs with the same objects as scripts that handle
+requests (db, fns, util, work, console), plus a 'cron' object holding the job's name. If the script throws, the run
+fails with the thrown value as its error. The run's context, which is cancelled when the job times out, also
+interrupts the script.
+*/
+func (srv *AppServer) AddScriptCronJob(name, schedule, scriptKey string, opts cron.JobOptions) error {
+	if srv.js == nil {
+		return errors.New("JS manager is not initialized")
+	}
+	_, err := srv.js.GetScript(scriptKey)
+	if err != nil {
+		return err
+	}
+	return srv.AddCronJobWithOptions(name, schedule, func(ctx context.Context) error {
+		rt := srv.js.Pool.Get()
+		defer srv.js.Pool.Put(rt)
+		vm := rt.VM
+
+		srv.injectScriptFunctors(ctx, "", vm)
+		jsrun.InjectJSSysFunctor(vm)
+		obj := vm.NewObject()
+		obj.Set("name", name)
+		vm.Set("cron", obj)
+
+		logging.LogToDeck(ctx, "info", "JS", "run", "running "+scriptKey+" for cron job "+name)
+		_, err := srv.js.Run(ctx, rt, scriptKey)
+		return scriptRunError(err)
+	}, opts)
+}
+
+// Adds a cron job that starts work of a given type, with the same data each time
+func (srv *AppServer) AddWorkCronJob(name, schedule, workType string, data any, opts cron.JobOptions) error {
+	if srv.WorkHub == nil {
+		return errors.New("work hub is not initialized")
+	}
+	return srv.AddCronJobWithOptions(name, schedule, func(ctx context.Context) error {
+		_, err := srv.StartWork(workType, data)
+		return err
+	}, opts)
+}
+
+// Adds the cron jobs declared in the cron config section
+func (srv *AppServer) addConfiguredCronJobs(jobs map[string]CronJobConfig) error {
+	for name, jc := range jobs {
+		opts := cron.JobOptions{
+			CatchUp: cron.CatchUpPolicy(jc.CatchUp),
+			Overlap: cron.OverlapPolicy(jc.Overlap),
+			Timeout: jc.Timeout,
+			Local:   jc.Local,
+			Jitter:  jc.Jitter,
+		}
+		switch opts.CatchUp {
+		case "", cron.CATCHUP_SKIP, cron.CATCHUP_ONCE, cron.CATCHUP_ALL:
+		default:
+			return errors.New("cron job '" + name + "': unknown catch_up policy '" + jc.CatchUp + "'")
+		}
+		switch opts.Overlap {
+		case "", cron.OVERLAP_ALLOW, cron.OVERLAP_FORBID, cron.OVERLAP_REPLACE:
+		default:
+			return errors.New("cron job '" + name + "': unknown overlap policy '" + jc.Overlap + "'")
+		}
+		if jc.TimeZone != "" {
+			loc, err := time.LoadLocation(jc.TimeZone)
+			if err != nil {
+				return errors.New("cron job '" + name + "': " + err.Error())
+			}
+			opts.Location = loc
+		}
+
+		var err error
+		switch {
+		case jc.Script != "" && jc.WorkType != "":
+			return errors.New("cron job '" + name + "' can have a script or a work_type, but not both")
+		case jc.Script != "":
+			err = srv.AddScriptCronJob(name, jc.Schedule, jc.Script, opts)
+		case jc.WorkType != "":
+			var data any
+			if jc.WorkData != "" {
+				err = json.Unmarshal([]byte(jc.WorkData), &data)
+				if err != nil {
+					return errors.New("cron job '" + name + "': invalid work_data: " + err.Error())
+				}
+			}
+			err = srv.AddWorkCronJob(name, jc.Schedule, jc.WorkType, data, opts)
+		default:
+			return errors.New("cron job '" + name + "' needs a script or a work_type")
+		}
+		if err != nil {
+			return errors.New("cron job '" + name + "': " + err.Error())
+		}
+	}
+	return nil
+}
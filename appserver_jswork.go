@@ -0,0 +1,104 @@
+package taproot
+
+import (
+	"context"
+	"errors"
+	"github.com/dop251/goja"
+	"github.com/highgrav/taproot/jsrun"
+	"github.com/highgrav/taproot/logging"
+	"github.com/highgrav/taproot/workers"
+	"strings"
+	"time"
+)
+
+/*
+Registers a server-side script as a handler for a type of work. The script runs with the same objects as scripts that
+handle requests (db, fns, util, work, console), on behalf of the user who started the work, plus a 'job' object:
+
+	job.id, job.type, job.data, job.attempts, job.userId
+	job.message(TEXT)          adds a message to the work's status
+	job.progress(P, TEXT)      reports progress (0 to 1), with an optional message
+
+The script's completion value (the value of its last expression statement) becomes the work's result; if it throws,
+the work fails with the thrown value as its error, and is retried according to the work type's retry policy. Calling
+system.exit() ends the script successfully, with no result.
+*/
+func (srv *AppServer) AddScriptWorkHandler(workType string, scriptKey string) error {
+	if srv.WorkHub == nil {
+		return errors.New("work hub is not initialized")
+	}
+	if srv.js == nil {
+		return errors.New("JS manager is not initialized")
+	}
+	_, err := srv.js.GetScript(scriptKey)
+	if err != nil {
+		return err
+	}
+	srv.WorkHub.AddWorkFunc(workType, srv.scriptWorkHandler(scriptKey))
+	return nil
+}
+
+func (srv *AppServer) scriptWorkHandler(scriptKey string) workers.WorkHandler {
+	return func(msg *workers.WorkRequest) workers.WorkStatusReport {
+		ctx := context.Background()
+		report := workers.WorkStatusReport{
+			Type:      msg.Type,
+			ID:        msg.ID,
+			Msg:       *msg,
+			StartedOn: time.Now(),
+			Messages:  make([]string, 0),
+		}
+
+		rt := srv.js.Pool.Get()
+		defer srv.js.Pool.Put(rt)
+		vm := rt.VM
+
+		srv.injectScriptFunctors(ctx, msg.UserID, vm)
+		jsrun.InjectJSSysFunctor(vm)
+
+		job := vm.NewObject()
+		job.Set("id", msg.ID)
+		job.Set("type", msg.Type)
+		job.Set("data", msg.Data)
+		job.Set("attempts", msg.Attempts)
+		job.Set("userId", msg.UserID)
+		job.Set("message", func(text string) {
+			report.Messages = append(report.Messages, text)
+		})
+		job.Set("progress", func(progress float64, text string) {
+			err := srv.WorkHub.ReportProgress(msg.ID, progress, text)
+			if err != nil {
+				logging.LogToDeck(ctx, "error", "JS", "error", "could not report progress for work '"+msg.ID+"': "+err.Error())
+			}
+		})
+		vm.Set("job", job)
+
+		logging.LogToDeck(ctx, "info", "JS", "run", "running "+scriptKey+" for work '"+msg.ID+"'")
+		val, err := srv.js.Run(ctx, rt, scriptKey)
+		report.EndedOn = time.Now()
+
+		report.Error = scriptRunError(err)
+		if report.Error == nil {
+			if err == nil && val != nil && !goja.IsUndefined(val) && !goja.IsNull(val) {
+				report.Result = val.Export()
+			}
+			report.Status = "done"
+			logging.LogToDeck(ctx, "info", "JS", "done", "completed "+scriptKey+" for work '"+msg.ID+"'")
+			return report
+		}
+		report.Status = "failed"
+		logging.LogToDeck(ctx, "error", "JS", "fail", "error running "+scriptKey+" for work '"+msg.ID+"': "+err.Error())
+		return report
+	}
+}
+
+// Returns the error from running a script as it should be reported, or nil if the script completed or exited
+func scriptRunError(err error) error {
+	if err == nil || strings.HasPrefix(err.Error(), jsrun.JS_EXPECTED_INTERRUPT) {
+		return nil
+	}
+	if jserr, ok := err.(*goja.Exception); ok {
+		return errors.New(jserr.Value().String())
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package taproot
+
+import (
+	"errors"
+	"github.com/highgrav/taproot/messages"
+)
+
+const MESSAGES_DEFAULT_SQL_TABLE string = "taproot_messages"
+
+// Creates the message bus described by the config, or nil if no broker is configured
+func newMessageBus(cfg MessagesConfig) (*messages.MessageBus, error) {
+	switch cfg.Broker {
+	case "":
+		return nil, nil
+	case "sql":
+		table := cfg.SQLTable
+		if table == "" {
+			table = MESSAGES_DEFAULT_SQL_TABLE
+		}
+		// the broker owns the database, so closing the bus closes it
+		sb, err := messages.OpenSQLBroker(cfg.SQLDriver, cfg.SQLDSN, table)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.PollInterval > 0 {
+			sb.PollInterval = cfg.PollInterval
+		}
+		if cfg.Retention > 0 {
+			sb.Retention = cfg.Retention
+		}
+		bus, err := messages.NewMessageBus(sb)
+		if err != nil {
+			sb.Close()
+			return nil, err
+		}
+		return bus, nil
+	}
+	return nil, errors.New("unknown message broker '" + cfg.Broker + "'")
+}
+
+/*
+Relays writes to the server's SSE and WebSocket hubs to the same hubs on other server instances through a broker, and
+delivers theirs to the clients connected here, so a write reaches a user whichever server they're connected to. Hubs
+added later are relayed as well. Any bus already in use is closed.
+*/
+func (srv *AppServer) SetMessageBroker(broker messages.IMessageBroker) error {
+	bus, err := messages.NewMessageBus(broker)
+	if err != nil {
+		return err
+	}
+	old := srv.MessageBus
+	srv.setMessageBus(bus)
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (srv *AppServer) setMessageBus(bus *messages.MessageBus) {
+	srv.MessageBus = bus
+	for _, hub := range srv.SSEHubs {
+		hub.SetMessageBus(bus)
+	}
+	for _, hub := range srv.WSHubs {
+		hub.SetMessageBus(bus)
+	}
+}
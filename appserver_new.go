@@ -9,12 +9,10 @@ import (
 	"github.com/highgrav/taproot/acacia"
 	"github.com/highgrav/taproot/authn"
 	"github.com/highgrav/taproot/authtoken"
-	"github.com/highgrav/taproot/cron"
 	"github.com/highgrav/taproot/jsrun"
 	"github.com/highgrav/taproot/logging"
 	"github.com/highgrav/taproot/pagecache"
 	"github.com/highgrav/taproot/session"
-	"github.com/highgrav/taproot/workers"
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 	"github.com/microcosm-cc/bluemonday"
@@ -58,6 +56,7 @@ func NewWithConfig(userStore authn.IUserStore, sessionStore session.IStore, ffla
 	s.DBs = make(map[string]*sql.DB)
 	s.Middleware = make([]alice.Constructor, 0)
 	s.jsinjections = make([]jsrun.InjectorFunc, 0)
+	s.jsfns = make(map[string]jsrun.ExternalJSFunction)
 
 	s.sanitizer = InputSanitizer{
 		StripHTML: bluemonday.StrictPolicy(),
@@ -77,20 +76,52 @@ func NewWithConfig(userStore authn.IUserStore, sessionStore session.IStore, ffla
 	s.SignatureMgr = authtoken.NewAuthSignerManager(keyDur, graceDur, authTokenRotator)
 
 	logging.LogToDeck(context.Background(), "info", "TAPROOT", "startup", "Setting up async work hub")
-	wh, err := workers.New(cfg.WorkHub.Name, cfg.WorkHub.StorageDir, cfg.WorkHub.SegmentSize)
+	wh, err := newWorkHub(cfg.WorkHub)
 	if err != nil {
 		logging.LogToDeck(context.Background(), "fatal", "TAPROOT", "startup", err.Error())
 		panic(err)
 	}
 	s.WorkHub = wh
+	if cfg.WorkHub.DefaultConcurrency > 0 {
+		s.WorkHub.SetDefaultConcurrency(cfg.WorkHub.DefaultConcurrency)
+	}
+	for workType, n := range cfg.WorkHub.Concurrency {
+		s.WorkHub.SetConcurrency(workType, n)
+	}
+	if cfg.WorkHub.StatusRetention != 0 {
+		s.WorkHub.SetStatusRetention(cfg.WorkHub.StatusRetention)
+	}
+	logging.LogToDeck(context.Background(), "info", "TAPROOT", "startup", "Setting up message bus")
+	bus, err := newMessageBus(cfg.Messages)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "fatal", "TAPROOT", "startup", err.Error())
+		panic(err)
+	}
+	if bus != nil {
+		s.setMessageBus(bus)
+	}
+
+	if cfg.WorkHub.StatusSSEHub != "" {
+		s.AddSSEHub(cfg.WorkHub.StatusSSEHub)
+		s.PushWorkStatusTo(cfg.WorkHub.StatusSSEHub)
+	}
 
 	logging.LogToDeck(context.Background(), "info", "TAPROOT", "startup", "Setting up cron hub")
-	s.CronHub = cron.New()
+	ch, err := newCronHub(cfg.Cron)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "fatal", "TAPROOT", "startup", err.Error())
+		panic(err)
+	}
+	s.CronHub = ch
 
 	// Set up IP filter
 	logging.LogToDeck(context.Background(), "info", "TAPROOT", "startup", "Setting up IP filtering")
 	s.httpIpFilter = newIpFilter(cfg.HttpServer.IPFilter)
 
+	// Set up rate limits (these can be changed later through the admin server)
+	s.SetGlobalRateLimit(cfg.HttpServer.GlobalRateLimits.RequestsPerSecond, cfg.HttpServer.GlobalRateLimits.BurstableRequests)
+	s.SetIPRateLimit(cfg.HttpServer.IpRateLimits.RequestsPerSecond, cfg.HttpServer.IpRateLimits.BurstableRequests)
+
 	// Set up our feature flags
 	s.fflags = fflagretriever
 	ffclient.Init(ffclient.Config{
@@ -130,9 +161,19 @@ func NewWithConfig(userStore authn.IUserStore, sessionStore session.IStore, ffla
 		logging.LogToDeck(context.Background(), "fatal", "TAPROOT", "startup", err.Error())
 		panic(err)
 	}
+	if cfg.ListenForPolicyChanges {
+		err = s.Acacia.WatchForChanges(cfg.SecurityPolicyDir)
+		if err != nil {
+			logging.LogToDeck(context.Background(), "error", "TAPROOT", "startup", "could not watch security policy directory: "+err.Error())
+		}
+	}
 
 	// set up our JS manager
-	js, err := jsrun.New(cfg.ScriptFilePath, s.removePageCacheEntry, s.removePageCacheEntry)
+	js, err := jsrun.NewWithLimits(cfg.ScriptFilePath, s.removePageCacheEntry, s.removePageCacheEntry, jsrun.JSRuntimeLimits{
+		PoolSize:         cfg.ScriptPoolSize,
+		MaxCallStackSize: cfg.ScriptMaxCallStack,
+		ScriptTimeout:    cfg.ScriptTimeout,
+	})
 	if err != nil {
 		logging.LogToDeck(context.Background(), "fatal", "TAPROOT", "startup", err.Error())
 		os.Exit(-1)
@@ -150,6 +191,13 @@ func NewWithConfig(userStore authn.IUserStore, sessionStore session.IStore, ffla
 		go s.monitorJSMLDirectories(s.Config.JSMLFilePath, filepath.Join(s.Config.ScriptFilePath, s.Config.JSMLCompiledFilePath))
 	}
 
+	// cron jobs from config can run scripts, so they're added once the JS manager is ready
+	err = s.addConfiguredCronJobs(cfg.Cron.Jobs)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "fatal", "TAPROOT", "startup", err.Error())
+		panic(err)
+	}
+
 	logging.LogToDeck(context.Background(), "info", "TAPROOT", "startup", "creating http servers")
 	s.Router = httprouter.New()
 	s.Router.SaveMatchedRoutePath = true // necessary to get the matched path back for Acacia
@@ -16,6 +16,11 @@ func (srv *AppServer) AddJSInjector(injectorFunc jsrun.InjectorFunc) {
 	srv.jsinjections = append(srv.jsinjections, injectorFunc)
 }
 
+// Adds a Go function that server-side JS can call as fns.exec(NAME, ...).
+func (srv *AppServer) AddJSFunction(name string, fn jsrun.ExternalJSFunction) {
+	srv.jsfns[name] = fn
+}
+
 // Adds global middleware to all routes.
 func (srv *AppServer) AddMiddleware(middlewareFunc alice.Constructor) {
 	srv.Middleware = append(srv.Middleware, middlewareFunc)
@@ -55,6 +60,7 @@ ListenAndServe always returns a non-nil error. After Shutdown or Close, the retu
 */
 func (srv *AppServer) ListenAndServe() error {
 	srv.Server.Server.Handler = srv.bindRoutes()
+	srv.startWorkHub()
 	srv.state.setState(SERVER_STATE_RUNNING)
 	return srv.Server.ListenAndServe()
 }
@@ -66,6 +72,7 @@ to do so.
 */
 func (srv *AppServer) ListenAndServeTLS(certFile, keyFile string) error {
 	srv.Server.Server.Handler = srv.bindRoutes()
+	srv.startWorkHub()
 	srv.state.setState(SERVER_STATE_INITIALIZING)
 
 	if srv.Config.HttpServer.TLS.UseSelfSignedCert && !srv.Config.HttpServer.TLS.UseACME {
@@ -119,6 +126,7 @@ Serve always returns a non-nil error and closes l. After Shutdown or Close, the
 func (srv *AppServer) Serve(l net.Listener) error {
 
 	srv.Server.Server.Handler = srv.bindRoutes()
+	srv.startWorkHub()
 	srv.state.setState(SERVER_STATE_RUNNING)
 	return srv.Server.Serve(l)
 }
@@ -134,6 +142,7 @@ ServeTLS always returns a non-nil error. After Shutdown or Close, the returned e
 */
 func (srv *AppServer) ServeTLS(l net.Listener, certFile, keyFile string) error {
 	srv.Server.Server.Handler = srv.bindRoutes()
+	srv.startWorkHub()
 	srv.state.setState(SERVER_STATE_INITIALIZING)
 
 	if srv.Config.HttpServer.TLS.UseSelfSignedCert && !srv.Config.HttpServer.TLS.UseACME {
@@ -183,8 +192,16 @@ When Shutdown is called, Serve, ListenAndServe, and ListenAndServeTLS immediatel
 Shutdown does not attempt to close nor wait for hijacked connections such as WebSockets. The caller of Shutdown should separately notify such long-lived connections of shutdown and wait for them to close, if desired. See RegisterOnShutdown for a way to register shutdown notification functions.
 
 Once Shutdown has been called on a server, it may not be reused; future calls to methods such as Serve will return ErrServerClosed.
+
+Shutdown also stops the cron hub, cancelling the contexts of any running cron jobs.
 */
 func (srv *AppServer) Shutdown(ctx context.Context) error {
+	if srv.CronHub != nil {
+		srv.CronHub.Stop()
+	}
+	if srv.MessageBus != nil {
+		srv.MessageBus.Close()
+	}
 	return srv.Server.Shutdown(ctx)
 }
 
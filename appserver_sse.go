@@ -1,12 +1,29 @@
 package taproot
 
-import "github.com/highgrav/taproot/sse"
+import (
+	"context"
+	"github.com/highgrav/taproot/acacia"
+	"github.com/highgrav/taproot/authn"
+	"github.com/highgrav/taproot/constants"
+	"github.com/highgrav/taproot/logging"
+	"github.com/highgrav/taproot/sse"
+	"net/http"
+	"strings"
+)
+
+const (
+	SSE_TOPIC_QUERY_PARAM  string = "topic"     // Query parameter clients name topics to subscribe to in, once per topic
+	SSE_TOPIC_ROUTE_PREFIX string = "/sse/"     // Acacia route prefix for topic subscriptions
+	SSE_SUBSCRIBE_RIGHT    string = "subscribe" // The right a policy must allow for a user to subscribe to a topic
+)
 
 /*
 Adds a new Server-Sent Events Hub that the application can write to. Note that, unlike WebSocket Hubs, SSE Hubs are write-only.
 The "name" is usually keyed to the user's ID; if you need to discriminate more carefully, then use the user ID plus a meaningful ID.
 For example, if you are writing a chat app and you only want the user to get updates for the open chat, you could use "user_id::chat_id"
 as the key.
+If the hub has settings in the "sse.hubs" config, it's set up with them (such as a replay log). If a message broker is
+configured, writes to the hub are relayed to the same hub on other server instances.
 */
 func (srv *AppServer) AddSSEHub(name string) {
 	if srv.SSEHubs == nil {
@@ -15,6 +32,96 @@ func (srv *AppServer) AddSSEHub(name string) {
 	if _, ok := srv.SSEHubs[name]; ok {
 		return
 	}
-	b := sse.New(name)
+	cfg, ok := hubConfig(srv.Config.SSE.Hubs, name)
+	b := sse.NewWithOptions(name, sse.SSEHubOptions{
+		BufferSize: cfg.BufferSize,
+		Overflow:   sse.OverflowPolicy(checkOverflowPolicy("SSE", name, cfg.Overflow)),
+		Heartbeat:  cfg.Heartbeat,
+	})
+	if ok {
+		log, err := newSSEReplayLog(cfg)
+		if err != nil {
+			logging.LogToDeck(context.Background(), "error", "SSE", "error", "could not open replay log of hub "+name+", events won't be replayed: "+err.Error())
+		} else if log != nil {
+			b.SetReplayLog(log)
+		}
+	}
+	if srv.MessageBus != nil {
+		b.SetMessageBus(srv.MessageBus)
+	}
 	srv.SSEHubs[name] = b
 }
+
+// Looks up the settings for a hub by name; viper lowercases map keys, so the lowercased name is tried as well
+func hubConfig[T any](hubs map[string]T, name string) (T, bool) {
+	cfg, ok := hubs[name]
+	if !ok {
+		cfg, ok = hubs[strings.ToLower(name)]
+	}
+	return cfg, ok
+}
+
+// Returns a configured overflow policy, or an empty one (so the default is used) if it isn't known
+func checkOverflowPolicy(kind string, hubName string, policy string) string {
+	switch policy {
+	case "", string(sse.OVERFLOW_DROP_OLDEST), string(sse.OVERFLOW_DROP_NEWEST), string(sse.OVERFLOW_DISCONNECT):
+		return policy
+	}
+	logging.LogToDeck(context.Background(), "error", kind, "error", "unknown overflow policy '"+policy+"' for hub "+hubName+", using "+string(sse.OVERFLOW_DROP_OLDEST))
+	return ""
+}
+
+// Creates the replay log described by a hub's config, or nil if it doesn't describe one
+func newSSEReplayLog(cfg SSEHubConfig) (sse.ReplayLog, error) {
+	if cfg.ReplayEvents < 1 && cfg.ReplayMaxAge <= 0 && cfg.ReplayFile == "" {
+		return nil, nil
+	}
+	retention := sse.ReplayRetention{
+		MaxEvents: cfg.ReplayEvents,
+		MaxAge:    cfg.ReplayMaxAge,
+	}
+	if cfg.ReplayFile != "" {
+		return sse.NewFileReplayLog(cfg.ReplayFile, retention)
+	}
+	return sse.NewMemoryReplayLog(retention), nil
+}
+
+/*
+Checks with Acacia whether the user making a request may subscribe to a topic on an SSE hub. Subscriptions are checked
+against the policies for the route "/sse/HUB/TOPIC", with the topic's segments as path segments, so a policy for
+"/sse/updates/orders/*" covers "orders:123" and "orders:*" on the "updates" hub. A subscription is allowed only if the
+policies grant the "subscribe" right; with no matching policy, or no policy manager, it's refused.
+*/
+func (srv *AppServer) CanSubscribe(r *http.Request, hubName string, topic string) (bool, error) {
+	if srv.Acacia == nil {
+		return false, nil
+	}
+	realm, _ := r.Context().Value(constants.HTTP_CONTEXT_REALM_KEY).(string)
+	if realm == "" {
+		realm = srv.Config.DefaultRealm
+	}
+	dom, _ := r.Context().Value(constants.HTTP_CONTEXT_DOMAIN_KEY).(string)
+	if dom == "" {
+		dom = srv.Config.DefaultDomain
+	}
+	usr, _ := authn.GetUserFromRequest(r)
+	rr := acacia.NewRightsRequest(realm, dom, usr, r)
+	rr.Query.Context = map[string]any{
+		"hub":   hubName,
+		"topic": topic,
+	}
+	route := SSE_TOPIC_ROUTE_PREFIX + hubName + "/" + strings.ReplaceAll(topic, sse.SSE_TOPIC_SEPARATOR, "/")
+	rights, err := srv.Acacia.Apply(r.Context(), route, rr)
+	if err != nil {
+		return false, err
+	}
+	if rights.Type == acacia.RESP_TYPE_RESPONSE || rights.Type == acacia.RESP_TYPE_REDIRECT {
+		return false, nil
+	}
+	for _, right := range rights.Rights {
+		if right == SSE_SUBSCRIBE_RIGHT {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -2,11 +2,60 @@ package taproot
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"github.com/highgrav/taproot/logging"
+	"github.com/highgrav/taproot/sse"
 	"github.com/highgrav/taproot/workers"
+	"time"
 )
 
+// Table used by the sql work hub backend, unless configured otherwise
+const WORK_DEFAULT_SQL_TABLE string = "taproot_work"
+
+// Creates the work hub with the backend chosen in its config. It takes no work until the server starts serving.
+func newWorkHub(cfg WorkHubConfig) (*workers.WorkQueue, error) {
+	switch cfg.Backend {
+	case "", "dque":
+		return workers.NewDeferred(cfg.Name, cfg.StorageDir, cfg.SegmentSize)
+	case "sql":
+		db, err := sql.Open(cfg.SQLDriver, cfg.SQLDSN)
+		if err != nil {
+			return nil, err
+		}
+		table := cfg.SQLTable
+		if table == "" {
+			table = WORK_DEFAULT_SQL_TABLE
+		}
+		backend, err := workers.NewSQLBackend(db, cfg.SQLDriver, table)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if cfg.PollInterval > 0 {
+			backend.PollInterval = cfg.PollInterval
+		}
+		store, err := workers.NewSQLJobStatusStore(db, cfg.SQLDriver, table+"_status")
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return workers.NewWithBackendDeferred(backend, store), nil
+	}
+	return nil, errors.New("unknown work hub backend '" + cfg.Backend + "'")
+}
+
+// Number of status updates that can wait to be pushed to SSE clients before the oldest are dropped
+const WORK_STATUS_PUSH_BUFFER int = 256
+
+// Starts taking work off the queue, once the application has had the chance to register its handlers
+func (srv *AppServer) startWorkHub() {
+	if srv.WorkHub != nil {
+		srv.WorkHub.Start()
+	}
+}
+
 func (srv *AppServer) AddWorkHandler(workType string, fn workers.WorkHandler) error {
 	if srv.WorkHub == nil {
 		return errors.New("work hub is not initialized")
@@ -35,15 +84,140 @@ func (srv *AppServer) AddWorkHandlers(workType string, workFn workers.WorkHandle
 	return nil
 }
 
+// Sets how failed work of a given type is retried before it is moved to the dead-letter queue
+func (srv *AppServer) SetWorkRetryPolicy(workType string, policy workers.RetryPolicy) error {
+	if srv.WorkHub == nil {
+		return errors.New("work hub is not initialized")
+	}
+	srv.WorkHub.SetRetryPolicy(workType, policy)
+	return nil
+}
+
+// Sets how many requests of a given type may run at once
+func (srv *AppServer) SetWorkConcurrency(workType string, n int) error {
+	if srv.WorkHub == nil {
+		return errors.New("work hub is not initialized")
+	}
+	srv.WorkHub.SetConcurrency(workType, n)
+	return nil
+}
+
 func (srv *AppServer) StartWork(workType string, data any) (string, error) {
+	return srv.StartWorkFor("", workType, data)
+}
+
+// Starts work on behalf of a user, so that status updates for the work can be pushed to them (see PushWorkStatusTo())
+func (srv *AppServer) StartWorkFor(userID string, workType string, data any) (string, error) {
 	if srv.WorkHub == nil {
 		return "", errors.New("work hub is not initialized")
 	}
 	wr := workers.NewWorkRequest(workType, data)
+	wr.UserID = userID
 	err := srv.WorkHub.Enqueue(wr)
 	if err != nil {
-		logging.LogToDeck(context.Background(), "error", "TAPROOT", "error", "Failed to enqueue work '" + workType + "': " + err.Error())
+		logging.LogToDeck(context.Background(), "error", "TAPROOT", "error", "Failed to enqueue work '"+workType+"': "+err.Error())
+		return "", err
 	}
 	return wr.ID, nil
-	return "", nil
+}
+
+// Starts work at a given time; until then, the work waits on disk. See workers.WorkQueue.EnqueueAt().
+func (srv *AppServer) StartWorkAt(workType string, data any, runAt time.Time) (string, error) {
+	return srv.StartWorkAtFor("", workType, data, runAt)
+}
+
+// Starts work at a given time on behalf of a user, as with StartWorkFor()
+func (srv *AppServer) StartWorkAtFor(userID string, workType string, data any, runAt time.Time) (string, error) {
+	if srv.WorkHub == nil {
+		return "", errors.New("work hub is not initialized")
+	}
+	wr := workers.NewWorkRequest(workType, data)
+	wr.UserID = userID
+	err := srv.WorkHub.EnqueueAt(wr, runAt)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "TAPROOT", "error", "Failed to schedule work '"+workType+"': "+err.Error())
+		return "", err
+	}
+	return wr.ID, nil
+}
+
+// Starts work once a duration has passed
+func (srv *AppServer) StartWorkAfter(workType string, data any, d time.Duration) (string, error) {
+	return srv.StartWorkAtFor("", workType, data, time.Now().Add(d))
+}
+
+// Starts work once a duration has passed, on behalf of a user
+func (srv *AppServer) StartWorkAfterFor(userID string, workType string, data any, d time.Duration) (string, error) {
+	return srv.StartWorkAtFor(userID, workType, data, time.Now().Add(d))
+}
+
+// Cancels work that hasn't started yet
+func (srv *AppServer) CancelWork(id string) error {
+	if srv.WorkHub == nil {
+		return errors.New("work hub is not initialized")
+	}
+	return srv.WorkHub.Cancel(id)
+}
+
+// Gets the status of work by the ID returned from StartWork()
+func (srv *AppServer) WorkStatus(id string) (workers.JobStatus, error) {
+	if srv.WorkHub == nil {
+		return workers.JobStatus{}, errors.New("work hub is not initialized")
+	}
+	return srv.WorkHub.JobStatus(id)
+}
+
+// Lists the statuses of work matching a filter, newest first
+func (srv *AppServer) WorkStatuses(filter workers.JobFilter) ([]workers.JobStatus, error) {
+	if srv.WorkHub == nil {
+		return nil, errors.New("work hub is not initialized")
+	}
+	return srv.WorkHub.Jobs(filter)
+}
+
+/*
+Pushes each change to the status of a user's work to that user through the named SSE hub, as a "work-status" event
+whose data is the JSON-encoded workers.JobStatus. Work started without a user ID isn't pushed. Updates are queued, so
+a slow client can't hold up the work itself; if too many back up, the oldest are dropped.
+*/
+func (srv *AppServer) PushWorkStatusTo(hubName string) error {
+	if srv.WorkHub == nil {
+		return errors.New("work hub is not initialized")
+	}
+	hub, ok := srv.SSEHubs[hubName]
+	if !ok {
+		return errors.New("SSE hub '" + hubName + "' does not exist")
+	}
+	updates := make(chan workers.JobStatus, WORK_STATUS_PUSH_BUFFER)
+	go func() {
+		for js := range updates {
+			data, err := json.Marshal(js)
+			if err != nil {
+				logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not encode status of work '"+js.ID+"': "+err.Error())
+				continue
+			}
+			hub.WriteOne(js.UserID, sse.SSEEvent{
+				UserID:    js.UserID,
+				EventType: "work-status",
+				Data:      []string{string(data)},
+			})
+		}
+	}()
+	srv.WorkHub.OnStatusChange(func(js workers.JobStatus) {
+		if js.UserID == "" {
+			return
+		}
+		for {
+			select {
+			case updates <- js:
+				return
+			default:
+				select {
+				case <-updates:
+				default:
+				}
+			}
+		}
+	})
+	return nil
 }
@@ -4,6 +4,8 @@ import (
 	"github.com/highgrav/taproot/websock"
 )
 
+// Adds a new WebSocket Hub. If the hub has settings in the "ws.hubs" config, its connections are set up with them.
+// If a message broker is configured, writes to the hub are relayed to the same hub on other server instances.
 func (srv *AppServer) AddWSHub(name string) {
 	if srv.WSHubs == nil {
 		srv.WSHubs = make(map[string]*websock.WSHub)
@@ -11,6 +13,15 @@ func (srv *AppServer) AddWSHub(name string) {
 	if _, ok := srv.WSHubs[name]; ok {
 		return
 	}
-	wsh := websock.NewWSHub(name)
+	cfg, _ := hubConfig(srv.Config.WS.Hubs, name)
+	wsh := websock.NewWSHubWithOptions(name, websock.WSConnOptions{
+		BufferSize:   cfg.BufferSize,
+		Overflow:     websock.OverflowPolicy(checkOverflowPolicy("WS", name, cfg.Overflow)),
+		PingInterval: cfg.PingInterval,
+	})
+	wsh.SetPresenceFrames(cfg.PresenceFrames)
+	if srv.MessageBus != nil {
+		wsh.SetMessageBus(srv.MessageBus)
+	}
 	srv.WSHubs[name] = wsh
 }
@@ -4,7 +4,9 @@ import (
 	"context"
 	"github.com/highgrav/taproot/common"
 	"github.com/highgrav/taproot/logging"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,6 +19,7 @@ func DefaultAuthSecretRotator() (string, []byte, error) {
 }
 
 type AuthSignerManager struct {
+	sync.RWMutex
 	ExpiresAfter time.Duration
 	GracePeriod  time.Duration
 
@@ -45,6 +48,8 @@ func NewAuthSignerManager(rotationTime time.Duration, gracePeriod time.Duration,
 }
 
 func (asm *AuthSignerManager) ListSignerKeys() []string {
+	asm.RLock()
+	defer asm.RUnlock()
 	keys := make([]string, 0)
 	for k, _ := range asm.signers {
 		keys = append(keys, k)
@@ -52,16 +57,71 @@ func (asm *AuthSignerManager) ListSignerKeys() []string {
 	return keys
 }
 
+// Public information about a signer (never its secret)
+type AuthSignerInfo struct {
+	ID        string    `json:"id"`
+	StartsAt  time.Time `json:"startsAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Current   bool      `json:"current"`
+}
+
+// Lists the signers that can still verify tokens, newest first
+func (asm *AuthSignerManager) ListSigners() []AuthSignerInfo {
+	asm.RLock()
+	defer asm.RUnlock()
+	infos := make([]AuthSignerInfo, 0, len(asm.signers))
+	for _, v := range asm.signers {
+		infos = append(infos, AuthSignerInfo{
+			ID:        v.ID,
+			StartsAt:  v.StartsAt,
+			ExpiresAt: v.ExpiresAt,
+			Current:   v == asm.currentSigner,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartsAt.After(infos[j].StartsAt)
+	})
+	return infos
+}
+
+/*
+Rotates to a new signer immediately. New tokens are signed with the new signer, while tokens from older signers remain
+valid until their signers expire. Returns the ID of the new signer.
+*/
+func (asm *AuthSignerManager) Rotate() (string, error) {
+	prev := asm.current().ID
+	err := asm.AddSigner()
+	if err != nil {
+		return "", err
+	}
+	id := asm.current().ID
+	logging.LogToDeck(context.Background(), "info", "AUTH", "info", "manually rotated session signer from "+prev+" to "+id)
+	return id, nil
+}
+
+func (asm *AuthSignerManager) current() *AuthSigner {
+	asm.RLock()
+	defer asm.RUnlock()
+	return asm.currentSigner
+}
+
+func (asm *AuthSignerManager) signer(id string) (*AuthSigner, bool) {
+	asm.RLock()
+	defer asm.RUnlock()
+	s, ok := asm.signers[id]
+	return s, ok
+}
+
 func (asm *AuthSignerManager) rotate() {
 	for {
 		select {
 		case <-asm.Done:
 			return
 		case t := <-asm.ticker.C:
-			if t.After(asm.currentSigner.ExpiresAt.Add(time.Duration(-1) * asm.GracePeriod)) {
-				currSig := asm.currentSigner.ID
+			if t.After(asm.current().ExpiresAt.Add(time.Duration(-1) * asm.GracePeriod)) {
+				currSig := asm.current().ID
 				asm.AddSigner()
-				logging.LogToDeck(context.Background(), "info", "AUTH", "info", "rotating session signer from "+currSig+" to "+asm.currentSigner.ID)
+				logging.LogToDeck(context.Background(), "info", "AUTH", "info", "rotating session signer from "+currSig+" to "+asm.current().ID)
 			}
 			go asm.RemoveSigners()
 		}
@@ -78,6 +138,8 @@ func (asm *AuthSignerManager) AddSigner() error {
 		logging.LogToDeck(context.Background(), "error", "AUTH", "error", "error adding signer: "+err.Error())
 		return err
 	}
+	asm.Lock()
+	defer asm.Unlock()
 	asm.signers[asgn.ID] = &asgn
 	asm.currentSigner = &asgn
 	asm.CurrentSignatureExpiration = asm.currentSigner.ExpiresAt
@@ -85,6 +147,8 @@ func (asm *AuthSignerManager) AddSigner() error {
 }
 
 func (asm *AuthSignerManager) RemoveSigners() {
+	asm.Lock()
+	defer asm.Unlock()
 	toRem := make([]string, 0)
 	for k, v := range asm.signers {
 		if time.Now().After(v.ExpiresAt) {
@@ -97,7 +161,7 @@ func (asm *AuthSignerManager) RemoveSigners() {
 }
 
 func (asm *AuthSignerManager) NewSignedToken(valToEncrypt string) (string, error) {
-	return asm.currentSigner.NewSignedToken(valToEncrypt)
+	return asm.current().NewSignedToken(valToEncrypt)
 }
 
 func (asm *AuthSignerManager) VerifySignedToken(token string) (AuthToken, error) {
@@ -106,7 +170,7 @@ func (asm *AuthSignerManager) VerifySignedToken(token string) (AuthToken, error)
 		return AuthToken{}, ErrMalformedToken
 	}
 
-	if s, ok := asm.signers[elems[0]]; ok {
+	if s, ok := asm.signer(elems[0]); ok {
 		if time.Now().After(s.ExpiresAt) {
 			return AuthToken{}, ErrExpiredToken
 		}
@@ -117,7 +181,7 @@ func (asm *AuthSignerManager) VerifySignedToken(token string) (AuthToken, error)
 }
 
 func (asm *AuthSignerManager) NewEncryptedToken(valToEncrypt string) string {
-	return asm.currentSigner.NewEncryptedToken(valToEncrypt)
+	return asm.current().NewEncryptedToken(valToEncrypt)
 }
 
 func (asm *AuthSignerManager) DecryptToken(token string) (AuthToken, error) {
@@ -125,7 +189,7 @@ func (asm *AuthSignerManager) DecryptToken(token string) (AuthToken, error) {
 	if len(elems) != 2 {
 		return AuthToken{}, ErrMalformedToken
 	}
-	if s, ok := asm.signers[elems[0]]; ok {
+	if s, ok := asm.signer(elems[0]); ok {
 		if time.Now().After(s.ExpiresAt) {
 			return AuthToken{}, ErrExpiredToken
 		}
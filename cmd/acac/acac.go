@@ -1,5 +1,133 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"github.com/google/deck"
+	"github.com/google/deck/backends/discard"
+	"github.com/highgrav/taproot/acacia"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const usage string = `acac is a command-line tool for working with Acacia policy files.
+
+Usage:
+	acac <command> [arguments]
+
+Commands:
+	compile    compile .acacia files into JSON policies
+	lint       check policies for mistakes
+	test       run fixture rights requests against policies and check the results
+	explain    explain how a set of policies evaluates a rights request
+
+Run "acac <command> -h" for a command's arguments.
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	// errors are reported directly, so there's no need for the policy manager's log output
+	deck.Add(discard.Init())
+	var err error
+	switch os.Args[1] {
+	case "compile":
+		err = runCompile(os.Args[2:])
+	case "lint":
+		err = runLint(os.Args[2:])
+	case "test":
+		err = runTest(os.Args[2:])
+	case "explain":
+		err = runExplain(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "acac: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err == errFailed {
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "acac: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+// Returned by commands that have already reported their failures, so main() only needs to set the exit code
+var errFailed = errors.New("failed")
+
+// Finds every .acacia file in a list of files and directories
+func findPolicyFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no policy files or directories given")
+	}
+	files := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if !strings.HasSuffix(path, ".acacia") {
+				return nil, errors.New("not an .acacia file: " + path)
+			}
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(fileName string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(info.Name(), ".acacia") {
+				files = append(files, fileName)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Parses .acacia files, keyed by file name. Files that fail to parse are returned as errors rather than stopping the load.
+func readPolicies(paths []string) (map[string]acacia.Policy, []error, error) {
+	files, err := findPolicyFiles(paths)
+	if err != nil {
+		return nil, nil, err
+	}
+	policies := make(map[string]acacia.Policy)
+	errs := make([]error, 0)
+	for _, fileName := range files {
+		policy, err := acacia.LoadPolicyFile(fileName)
+		if err != nil {
+			errs = append(errs, errors.New(fileName+": "+err.Error()))
+			continue
+		}
+		policies[fileName] = policy
+	}
+	return policies, errs, nil
+}
 
+// Loads .acacia files into a new PolicyManager, failing if any of them fail to parse or compile
+func loadPolicies(paths []string) (*acacia.PolicyManager, error) {
+	policies, errs, err := readPolicies(paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	pm := acacia.NewPolicyManager()
+	for name, policy := range policies {
+		err = pm.AddPolicy(name, policy)
+		if err != nil {
+			return nil, errors.New(name + ": " + err.Error())
+		}
+	}
+	return pm, nil
 }
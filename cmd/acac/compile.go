@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/highgrav/taproot/acacia"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Compiles .acacia files into the JSON Policy form. A single file is written to stdout unless -o is given; otherwise each
+file is written as a .json file, either next to its source or under the -o directory (keeping its relative path).
+Policies with lint errors are not written.
+*/
+func runCompile(args []string) error {
+	fs := flag.NewFlagSet("compile", flag.ExitOnError)
+	outDir := fs.String("o", "", "output directory")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: acac compile [-o <dir>] <file or directory>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	policies, errs, err := readPolicies(fs.Args())
+	if err != nil {
+		return err
+	}
+	failed := len(errs) > 0
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e.Error())
+	}
+	for _, li := range acacia.Lint(policies, nil) {
+		if li.Severity == acacia.LINT_ERROR {
+			fmt.Fprintln(os.Stderr, li.String())
+			delete(policies, li.Policy)
+			failed = true
+		}
+	}
+
+	toStdout := *outDir == "" && len(fs.Args()) == 1 && strings.HasSuffix(fs.Arg(0), ".acacia")
+	for fileName, policy := range policies {
+		js, err := json.MarshalIndent(policy, "", "  ")
+		if err != nil {
+			return err
+		}
+		if toStdout {
+			fmt.Println(string(js))
+			continue
+		}
+		target := strings.TrimSuffix(fileName, ".acacia") + ".json"
+		if *outDir != "" {
+			target = filepath.Join(*outDir, relativeTo(fs.Args(), target))
+		}
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err == nil {
+			err = os.WriteFile(target, js, 0644)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(fileName + " -> " + target)
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+// Returns a file name relative to whichever of the command-line paths contains it
+func relativeTo(paths []string, fileName string) string {
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		rel, err := filepath.Rel(p, fileName)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return filepath.Base(fileName)
+}
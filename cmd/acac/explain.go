@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/highgrav/taproot/acacia"
+	"io"
+	"os"
+	"strings"
+)
+
+// Explains how the policies in a file or directory evaluate a rights request (read as JSON from a file or stdin)
+func runExplain(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	policyPath := fs.String("p", "", "policy file or directory")
+	route := fs.String("r", "", "route to evaluate, e.g. /crm/:id")
+	reqFile := fs.String("q", "-", "rights request JSON file, or - for stdin")
+	asJSON := fs.Bool("json", false, "print the explanation as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: acac explain -p <policies> -r <route> [-q <request.json>] [-json]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *route == "" || *policyPath == "" {
+		return errors.New("explain: policies (-p) and a route (-r) are required")
+	}
+
+	pm, err := loadPolicies([]string{*policyPath})
+	if err != nil {
+		return err
+	}
+
+	var input []byte
+	if *reqFile == "-" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(*reqFile)
+	}
+	if err != nil {
+		return err
+	}
+	rr := &acacia.RightsRequest{}
+	err = json.Unmarshal(input, rr)
+	if err != nil {
+		return errors.New("invalid rights request: " + err.Error())
+	}
+
+	exp, err := pm.Explain(context.Background(), *route, rr)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exp)
+	}
+	printExplanation(os.Stdout, exp)
+	return nil
+}
+
+func printExplanation(w io.Writer, exp acacia.Explanation) {
+	fmt.Fprintf(w, "Route %s (policy generation %d)\n\n", exp.Route, exp.Generation)
+	fmt.Fprintln(w, "Candidate patterns:")
+	for _, c := range exp.Candidates {
+		bound := "no policies"
+		if c.Bound {
+			bound = "bound"
+		}
+		fmt.Fprintf(w, "  %-40s %s\n", c.Pattern, bound)
+	}
+	fmt.Fprintln(w, "\nMatched policies:")
+	if len(exp.Matches) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, m := range exp.Matches {
+		fmt.Fprintf(w, "  [pri %d] %s (%s)\n", m.Priority, m.PolicyID, m.Name)
+		if len(m.Allowed) > 0 {
+			fmt.Fprintf(w, "      allow:    %s\n", strings.Join(m.Allowed, ", "))
+		}
+		if len(m.Denied) > 0 {
+			fmt.Fprintf(w, "      deny:     %s\n", strings.Join(m.Denied, ", "))
+		}
+		if m.Redirect != "" {
+			fmt.Fprintf(w, "      redirect: %s\n", m.Redirect)
+		}
+		if m.ReturnCode > 0 {
+			fmt.Fprintf(w, "      return:   %d\n", m.ReturnCode)
+		}
+	}
+	if len(exp.Steps) > 0 {
+		fmt.Fprintln(w, "\nRights by priority:")
+		for _, s := range exp.Steps {
+			fmt.Fprintf(w, "  [pri %d] -[%s] +[%s] => [%s]\n", s.Priority, strings.Join(s.Denied, ", "),
+				strings.Join(s.Allowed, ", "), strings.Join(s.Rights, ", "))
+		}
+	}
+	fmt.Fprintf(w, "\nResult: %s\n", exp.Reason)
+	switch exp.Result.Type {
+	case acacia.RESP_TYPE_RESPONSE:
+		fmt.Fprintf(w, "  response %d %s\n", exp.Result.Response.ReturnCode, exp.Result.Response.ReturnMsg)
+	case acacia.RESP_TYPE_REDIRECT:
+		fmt.Fprintf(w, "  redirect to %s\n", exp.Result.Redirect)
+	case acacia.RESP_TYPE_RIGHTS:
+		fmt.Fprintf(w, "  rights [%s]\n", strings.Join(exp.Result.Rights, ", "))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/highgrav/taproot/acacia"
+	"os"
+	"strings"
+)
+
+/*
+Lints policies. Exits with an error if any policy fails to parse or has lint errors, or with -strict, if there are any
+warnings. Known rights are read from a file with one right per line; blank lines and lines starting with # are ignored.
+*/
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	rightsFile := fs.String("rights", "", "file listing every known right, one per line")
+	strict := fs.Bool("strict", false, "treat warnings as errors")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: acac lint [-rights <file>] [-strict] <file or directory>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var knownRights []string
+	if *rightsFile != "" {
+		var err error
+		knownRights, err = readRightsFile(*rightsFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	policies, errs, err := readPolicies(fs.Args())
+	if err != nil {
+		return err
+	}
+	for _, e := range errs {
+		fmt.Println(e.Error())
+	}
+	issues := acacia.Lint(policies, knownRights)
+	errCount, warnCount := len(errs), 0
+	for _, li := range issues {
+		fmt.Println(li.String())
+		if li.Severity == acacia.LINT_ERROR {
+			errCount++
+		} else {
+			warnCount++
+		}
+	}
+	fmt.Printf("%d policies, %d errors, %d warnings\n", len(policies)+len(errs), errCount, warnCount)
+	if errCount > 0 || (*strict && warnCount > 0) {
+		return errFailed
+	}
+	return nil
+}
+
+func readRightsFile(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rights := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rights = append(rights, line)
+	}
+	return rights, scanner.Err()
+}
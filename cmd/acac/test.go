@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/highgrav/taproot/acacia"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+A test fixture: a rights request, the route to evaluate it against, and the expected response. Only the parts of the
+expected response that matter for its type are checked (the rights for "rights", the redirect for "redirect", and the
+return code and message for "response").
+*/
+type fixture struct {
+	Name    string               `json:"name"`
+	Route   string               `json:"route"`
+	Request acacia.RightsRequest `json:"request"`
+	Expect  acacia.RightResponse `json:"expect"`
+}
+
+// Runs every .json fixture in a directory against a set of policies
+func runTest(args []string) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	policyPath := fs.String("p", "", "policy file or directory")
+	fixtureDir := fs.String("f", "", "fixture directory")
+	verbose := fs.Bool("v", false, "explain failing fixtures")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: acac test -p <policies> -f <fixtures> [-v]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *policyPath == "" || *fixtureDir == "" {
+		return errors.New("test: policies (-p) and fixtures (-f) are required")
+	}
+
+	pm, err := loadPolicies([]string{*policyPath})
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(*fixtureDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no fixtures found in " + *fixtureDir)
+	}
+	sort.Strings(files)
+
+	failures := 0
+	for _, fileName := range files {
+		f, err := readFixture(fileName)
+		if err != nil {
+			fmt.Printf("FAIL %s: %s\n", fileName, err.Error())
+			failures++
+			continue
+		}
+		res, err := pm.Apply(context.Background(), f.Route, &f.Request)
+		if err == nil {
+			err = compareResponse(f.Expect, res)
+		}
+		if err != nil {
+			fmt.Printf("FAIL %s: %s\n", f.Name, err.Error())
+			failures++
+			if *verbose {
+				exp, _ := pm.Explain(context.Background(), f.Route, &f.Request)
+				printExplanation(os.Stdout, exp)
+				fmt.Println()
+			}
+			continue
+		}
+		fmt.Printf("ok   %s\n", f.Name)
+	}
+	fmt.Printf("%d fixtures, %d failed\n", len(files), failures)
+	if failures > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+func readFixture(fileName string) (fixture, error) {
+	f := fixture{}
+	input, err := os.ReadFile(fileName)
+	if err != nil {
+		return f, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&f)
+	if err != nil {
+		return f, errors.New("invalid fixture: " + err.Error())
+	}
+	if f.Route == "" {
+		return f, errors.New("fixture has no route")
+	}
+	if f.Name == "" {
+		f.Name = fileName
+	}
+	return f, nil
+}
+
+func compareResponse(expect acacia.RightResponse, res acacia.RightResponse) error {
+	if expect.Type != res.Type {
+		return fmt.Errorf("expected a %q response, got %q", expect.Type, res.Type)
+	}
+	switch expect.Type {
+	case acacia.RESP_TYPE_RESPONSE:
+		if expect.Response != res.Response {
+			return fmt.Errorf("expected response %d %q, got %d %q", expect.Response.ReturnCode, expect.Response.ReturnMsg,
+				res.Response.ReturnCode, res.Response.ReturnMsg)
+		}
+	case acacia.RESP_TYPE_REDIRECT:
+		if expect.Redirect != res.Redirect {
+			return fmt.Errorf("expected redirect to %q, got %q", expect.Redirect, res.Redirect)
+		}
+	case acacia.RESP_TYPE_RIGHTS:
+		expected := make([]string, 0, len(expect.Rights))
+		for _, r := range expect.Rights {
+			expected = append(expected, strings.ToLower(r))
+		}
+		actual := append([]string{}, res.Rights...)
+		sort.Strings(expected)
+		sort.Strings(actual)
+		if !reflect.DeepEqual(expected, actual) {
+			return fmt.Errorf("expected rights [%s], got [%s]", strings.Join(expected, ", "), strings.Join(actual, ", "))
+		}
+	}
+	return nil
+}
@@ -1,5 +1,220 @@
 package main
 
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/deck"
+	"github.com/google/deck/backends/discard"
+	"github.com/highgrav/taproot/languages/jsmltranspiler"
+	"github.com/highgrav/taproot/languages/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Guards against include cycles, which would otherwise recurse forever
+const MAX_INCLUDES_PER_FILE int = 256
+
 func main() {
-	
+	fs := flag.NewFlagSet("jsmlc", flag.ExitOnError)
+	srcDir := fs.String("src", "", "directory of .jsml files (jsml_file_path)")
+	outDir := fs.String("out", "", "output directory for compiled .js files (script_file_path/jsml_compiled_file_path)")
+	check := fs.Bool("check", false, "compile without writing any files, and exit with an error if any file fails")
+	watch := fs.Bool("watch", false, "keep running, recompiling files (and the files that include them) as they change")
+	comments := fs.Bool("comments", false, "keep comments in compiled output")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: jsmlc -src <dir> (-out <dir> | -check) [-watch] [-comments]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+	if *srcDir == "" || (*outDir == "" && !*check) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	// errors are printed directly, so there's no need for library log output
+	deck.Add(discard.Init())
+
+	c := &compiler{
+		srcDir:   filepath.Clean(*srcDir),
+		outDir:   *outDir,
+		check:    *check,
+		comments: *comments,
+		deps:     make(map[string]map[string]bool),
+	}
+	failed, err := c.compileAll()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "jsmlc: "+err.Error())
+		os.Exit(1)
+	}
+	if *watch {
+		err = c.watch()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "jsmlc: "+err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+type compiler struct {
+	srcDir   string
+	outDir   string
+	check    bool
+	comments bool
+	// included file -> files that include it
+	deps map[string]map[string]bool
+}
+
+// Records every JSML file that is included while compiling a file
+type trackingAccessor struct {
+	jsmltranspiler.FileScriptAccessor
+	included []string
+}
+
+func (ta *trackingAccessor) GetJSMLScriptByID(id string) (string, error) {
+	if len(ta.included) >= MAX_INCLUDES_PER_FILE {
+		return "", errors.New("too many includes (is there an include cycle?)")
+	}
+	fileName, err := ta.Locate(id)
+	if err != nil {
+		return "", err
+	}
+	ta.included = append(ta.included, filepath.Clean(fileName))
+	return ta.FileScriptAccessor.GetJSMLScriptByID(id)
+}
+
+// Compiles every .jsml file under the source directory, returning the number of files that failed
+func (c *compiler) compileAll() (int, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(c.srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".jsml") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	failed := 0
+	for _, fileName := range files {
+		if !c.compileAndReport(fileName) {
+			failed++
+		}
+	}
+	fmt.Printf("%d files, %d failed\n", len(files), failed)
+	return failed, nil
+}
+
+// Compiles a file and prints the result, returning true if it compiled
+func (c *compiler) compileAndReport(fileName string) bool {
+	err := c.compileFile(fileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, formatError(fileName, err))
+		return false
+	}
+	if !c.check {
+		fmt.Println("compiled " + fileName)
+	}
+	return true
+}
+
+func (c *compiler) compileFile(fileName string) error {
+	fileName = filepath.Clean(fileName)
+	src, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	acc := &trackingAccessor{
+		FileScriptAccessor: jsmltranspiler.FileScriptAccessor{JSMLDir: c.srcDir, JSDir: c.outDir},
+	}
+	id := strings.TrimPrefix(fileName, c.srcDir)
+	trans, err := jsmltranspiler.NewAndTranspile(id, acc, string(src), c.comments)
+	c.setIncludes(fileName, acc.included)
+	if err != nil {
+		return err
+	}
+	if c.check {
+		return nil
+	}
+
+	jsFileName := c.outputFileName(fileName)
+	err = os.MkdirAll(filepath.Dir(jsFileName), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(jsFileName, []byte(trans.Builder().String()), 0644)
+}
+
+// Returns where a compiled file goes, keeping its path relative to the source directory
+func (c *compiler) outputFileName(fileName string) string {
+	relativeFileName := strings.TrimSuffix(strings.TrimPrefix(fileName, c.srcDir), ".jsml") + ".js"
+	return filepath.Join(c.outDir, relativeFileName)
+}
+
+func (c *compiler) setIncludes(fileName string, included []string) {
+	for _, includers := range c.deps {
+		delete(includers, fileName)
+	}
+	for _, inc := range included {
+		if c.deps[inc] == nil {
+			c.deps[inc] = make(map[string]bool)
+		}
+		c.deps[inc][fileName] = true
+	}
+}
+
+// Returns every file that includes a file, directly or indirectly, sorted by name
+func (c *compiler) dependents(fileName string) []string {
+	found := make(map[string]bool)
+	queue := []string{filepath.Clean(fileName)}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		for includer := range c.deps[curr] {
+			if !found[includer] {
+				found[includer] = true
+				queue = append(queue, includer)
+			}
+		}
+	}
+	delete(found, filepath.Clean(fileName))
+	res := make([]string, 0, len(found))
+	for k := range found {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+/*
+Formats a compile error as file:line:col: message where we have a position. Errors from included files are joined onto
+the error at the include, so those are printed on the following lines.
+*/
+func formatError(fileName string, err error) string {
+	var pe *token.PositionError
+	if !errors.As(err, &pe) {
+		return fileName + ": " + err.Error()
+	}
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s:%d:%d: %s", fileName, pe.Line, pe.Column, pe.Message))
+	if pe.Token.Literal != "" {
+		sb.WriteString(fmt.Sprintf(" (near '%s')", pe.Token.Literal))
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			if e == error(pe) {
+				continue
+			}
+			sb.WriteString("\n\t" + strings.ReplaceAll(e.Error(), "\n", "\n\t"))
+		}
+	}
+	return sb.String()
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/highgrav/taproot/common"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Watches the source directory, recompiling changed files and every file that includes them
+func (c *compiler) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	dirs, err := common.GetDirs(c.srcDir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range append([]string{c.srcDir}, dirs...) {
+		err = watcher.Add(dir)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("watching " + c.srcDir + " for changes")
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			c.handleEvent(watcher, event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintln(os.Stderr, "jsmlc: watch error: "+err.Error())
+		}
+	}
+}
+
+func (c *compiler) handleEvent(watcher *fsnotify.Watcher, event fsnotify.Event) {
+	name := filepath.Clean(event.Name)
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		info, err := os.Stat(name)
+		if err == nil && info.IsDir() {
+			watcher.Add(name)
+			filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				if info.IsDir() && path != name {
+					watcher.Add(path)
+				} else if strings.HasSuffix(path, ".jsml") {
+					c.recompile(path)
+				}
+				return nil
+			})
+			return
+		}
+	}
+	if !strings.HasSuffix(name, ".jsml") {
+		return
+	}
+	if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+		c.recompile(name)
+		return
+	}
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		if _, err := os.Stat(name); err == nil {
+			// replaced rather than removed
+			c.recompile(name)
+			return
+		}
+		fmt.Printf("%s removed %s\n", time.Now().Format(time.TimeOnly), name)
+		c.setIncludes(name, nil)
+		if !c.check {
+			os.Remove(c.outputFileName(name))
+		}
+		// anything that included the removed file should now fail, and we want to say so
+		for _, dep := range c.dependents(name) {
+			c.compileAndReport(dep)
+		}
+	}
+}
+
+func (c *compiler) recompile(fileName string) {
+	fmt.Printf("%s %s changed\n", time.Now().Format(time.TimeOnly), fileName)
+	c.compileAndReport(fileName)
+	for _, dep := range c.dependents(fileName) {
+		c.compileAndReport(dep)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A client for the admin and metrics servers, both of which return JSON envelopes with an "ok" flag
+type client struct {
+	adminURL   string
+	metricsURL string
+	token      string
+	http       *http.Client
+}
+
+// Calls the admin server, returning the response envelope
+func (c *client) admin(method string, path string, query url.Values) (map[string]any, error) {
+	if c.adminURL == "" {
+		return nil, errors.New("no admin server URL; use -admin or set TAPCTL_ADMIN_URL")
+	}
+	return c.call(c.adminURL, method, path, query, true)
+}
+
+// Calls the metrics server, returning the response envelope
+func (c *client) metrics(path string, query url.Values) (map[string]any, error) {
+	if c.metricsURL == "" {
+		return nil, errors.New("no metrics server URL; use -metrics or set TAPCTL_METRICS_URL")
+	}
+	return c.call(c.metricsURL, http.MethodGet, path, query, false)
+}
+
+func (c *client) call(base string, method string, path string, query url.Values, auth bool) (map[string]any, error) {
+	u := strings.TrimSuffix(base, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth && c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]any)
+	err = json.Unmarshal(body, &env)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %s (%s)", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode >= 300 || env["ok"] == false {
+		return nil, fmt.Errorf("%s %s: %s: %v", method, path, resp.Status, env["error"])
+	}
+	delete(env, "ok")
+	return env, nil
+}
+
+func (c *client) printAdmin(method string, path string, query url.Values) error {
+	env, err := c.admin(method, path, query)
+	if err != nil {
+		return err
+	}
+	return printJSON(env)
+}
+
+func (c *client) printMetrics(path string, query url.Values) error {
+	env, err := c.metrics(path, query)
+	if err != nil {
+		return err
+	}
+	return printJSON(env)
+}
+
+func printJSON(v any) error {
+	js, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(js))
+	return nil
+}
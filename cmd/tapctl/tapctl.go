@@ -1,5 +1,248 @@
 package main
 
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const usage string = `tapctl controls a running Taproot server through its admin and metrics servers.
+
+Usage:
+	tapctl [flags] <command> [arguments]
+
+Commands:
+	state                      show server state and uptime
+	drain                      stop taking new requests, letting in-flight requests finish
+	resume                     take the server out of drain mode
+	shutdown [timeout-secs]    gracefully shut the server down
+	metrics global|sse|ws      show global, SSE, or WebSocket metrics
+	metrics js [script]        show server-side script metrics
+	metrics work               show work queue metrics
+	metrics cron               show cron job metrics
+	metrics messages           show counts of hub writes relayed between servers
+	metrics paths              list the paths that have metrics
+	metrics stats <path>       show metrics for a path
+	cache [list]               list page cache entries
+	cache flush [id]           flush a page cache entry, or the whole page cache
+	cron [list]                list cron jobs
+	cron pause|resume          pause or resume cron
+	cron run <name>            run a cron job now
+	cron history <name>        show a cron job's recent runs, newest first
+	work [depth]               show work queue, scheduled and dead-letter queue depths
+	work dead [limit]          list dead-lettered work requests
+	work replay [id]           put a dead-lettered request, or all of them, back on the work queue
+	work purge [id]            delete a dead-lettered request, or all of them
+	work status <id>           show the status of a work request
+	work jobs [state]          list work request statuses, newest first
+	work cancel <id>           cancel a work request that hasn't started yet
+	keys [list]                list signing keys
+	keys rotate                rotate to a new signing key
+	acacia [list]              list Acacia policies
+	acacia reload              reload Acacia policies from disk
+	acacia flush [route]       flush Acacia policies for a route, or all policies
+
+Flags:
+`
+
 func main() {
+	fs := flag.NewFlagSet("tapctl", flag.ExitOnError)
+	adminURL := fs.String("admin", os.Getenv("TAPCTL_ADMIN_URL"), "admin server URL (or $TAPCTL_ADMIN_URL)")
+	metricsURL := fs.String("metrics", os.Getenv("TAPCTL_METRICS_URL"), "metrics server URL (or $TAPCTL_METRICS_URL)")
+	token := fs.String("token", os.Getenv("TAPCTL_TOKEN"), "admin server bearer token (or $TAPCTL_TOKEN)")
+	timeout := fs.Duration("timeout", 30*time.Second, "request timeout")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c := &client{
+		adminURL:   *adminURL,
+		metricsURL: *metricsURL,
+		token:      *token,
+		http:       &http.Client{Timeout: *timeout},
+	}
+	err := run(c, fs.Arg(0), fs.Args()[1:])
+	if err == errUsage {
+		fs.Usage()
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "tapctl: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("usage")
+
+// Returns the argument at an index, or a default if there aren't enough arguments
+func arg(args []string, idx int, def string) string {
+	if len(args) > idx {
+		return args[idx]
+	}
+	return def
+}
+
+func run(c *client, cmd string, args []string) error {
+	switch cmd {
+	case "state":
+		env, err := c.admin(http.MethodGet, "/server", nil)
+		if err != nil {
+			return err
+		}
+		printState(env)
+		return nil
+	case "drain":
+		return c.printAdmin(http.MethodPost, "/server/drain", nil)
+	case "resume":
+		return c.printAdmin(http.MethodPost, "/server/resume", nil)
+	case "shutdown":
+		q := url.Values{}
+		if len(args) > 0 {
+			q.Set("timeout", args[0])
+		}
+		return c.printAdmin(http.MethodPost, "/server/shutdown", q)
+	case "metrics":
+		return runMetrics(c, args)
+	case "cache":
+		switch arg(args, 0, "list") {
+		case "list":
+			return c.printAdmin(http.MethodGet, "/cache", nil)
+		case "flush":
+			q := url.Values{}
+			if len(args) > 1 {
+				q.Set("id", args[1])
+			}
+			return c.printAdmin(http.MethodDelete, "/cache", q)
+		}
+	case "cron":
+		switch arg(args, 0, "list") {
+		case "list":
+			return c.printAdmin(http.MethodGet, "/cron", nil)
+		case "pause":
+			return c.printAdmin(http.MethodPost, "/cron/pause", nil)
+		case "resume":
+			return c.printAdmin(http.MethodPost, "/cron/resume", nil)
+		case "run":
+			if len(args) < 2 {
+				return errors.New("cron run: a job name is required")
+			}
+			return c.printAdmin(http.MethodPost, "/cron/run", url.Values{"name": {args[1]}})
+		case "history":
+			if len(args) < 2 {
+				return errors.New("cron history: a job name is required")
+			}
+			return c.printAdmin(http.MethodGet, "/cron/history", url.Values{"name": {args[1]}})
+		}
+	case "work":
+		switch arg(args, 0, "depth") {
+		case "depth":
+			return c.printAdmin(http.MethodGet, "/work", nil)
+		case "dead":
+			q := url.Values{}
+			if len(args) > 1 {
+				q.Set("limit", args[1])
+			}
+			return c.printAdmin(http.MethodGet, "/work/dead", q)
+		case "replay":
+			q := url.Values{}
+			if len(args) > 1 {
+				q.Set("id", args[1])
+			}
+			return c.printAdmin(http.MethodPost, "/work/dead/replay", q)
+		case "purge":
+			q := url.Values{}
+			if len(args) > 1 {
+				q.Set("id", args[1])
+			}
+			return c.printAdmin(http.MethodDelete, "/work/dead", q)
+		case "status":
+			if len(args) < 2 {
+				return errors.New("work status: an ID is required")
+			}
+			return c.printAdmin(http.MethodGet, "/work/status", url.Values{"id": {args[1]}})
+		case "jobs":
+			q := url.Values{}
+			if len(args) > 1 {
+				q.Set("state", args[1])
+			}
+			return c.printAdmin(http.MethodGet, "/work/jobs", q)
+		case "cancel":
+			if len(args) < 2 {
+				return errors.New("work cancel: an ID is required")
+			}
+			return c.printAdmin(http.MethodPost, "/work/cancel", url.Values{"id": {args[1]}})
+		}
+	case "keys":
+		switch arg(args, 0, "list") {
+		case "list":
+			return c.printAdmin(http.MethodGet, "/keys", nil)
+		case "rotate":
+			return c.printAdmin(http.MethodPost, "/keys/rotate", nil)
+		}
+	case "acacia":
+		switch arg(args, 0, "list") {
+		case "list":
+			return c.printAdmin(http.MethodGet, "/acacia", nil)
+		case "reload":
+			return c.printAdmin(http.MethodPost, "/acacia/reload", nil)
+		case "flush":
+			q := url.Values{}
+			if len(args) > 1 {
+				q.Set("route", args[1])
+			}
+			return c.printAdmin(http.MethodDelete, "/acacia", q)
+		}
+	}
+	return errUsage
+}
+
+func runMetrics(c *client, args []string) error {
+	switch arg(args, 0, "global") {
+	case "global":
+		return c.printMetrics("/global", nil)
+	case "sse":
+		return c.printMetrics("/sse", nil)
+	case "ws":
+		return c.printMetrics("/ws", nil)
+	case "js":
+		q := url.Values{}
+		if len(args) > 1 {
+			q.Set("script", args[1])
+		}
+		return c.printMetrics("/js", q)
+	case "work":
+		return c.printMetrics("/work", nil)
+	case "cron":
+		return c.printMetrics("/cron", nil)
+	case "messages":
+		return c.printMetrics("/messages", nil)
+	case "paths":
+		return c.printMetrics("/", nil)
+	case "stats":
+		if len(args) < 2 {
+			return errors.New("metrics stats: a path is required")
+		}
+		return c.printMetrics("/stats", url.Values{"path": {args[1]}})
+	}
+	return errUsage
+}
 
+func printState(env map[string]any) {
+	state, _ := env["state"].(string)
+	uptime, _ := env["uptimeSecs"].(float64)
+	fmt.Printf("state:   %s\n", state)
+	fmt.Printf("started: %v\n", env["startedOn"])
+	fmt.Printf("uptime:  %s\n", (time.Duration(uptime) * time.Second).String())
+	if paused, ok := env["cronPaused"].(bool); ok {
+		fmt.Printf("cron:    paused=%v\n", paused)
+	}
 }
@@ -74,15 +74,15 @@ func (s *RuneString) Peek() rune {
 	return rune(0x0)
 }
 
-// Gets the line number and line position of a character
+// Gets the line number and line position of a character (both 1-based)
 func (s *RuneString) GetLineAndPos(charPos int32) (int, int) {
 	lineCount := 0
-	lastLineCount := 0
+	lastNewline := -1
 	for x := int32(0); x < charPos; x++ {
 		if s.Get(x) == '\n' {
 			lineCount++
-			lastLineCount = int(x)
+			lastNewline = int(x)
 		}
 	}
-	return lineCount + 1, int(charPos) - lastLineCount
+	return lineCount + 1, int(charPos) - lastNewline
 }
@@ -95,8 +95,10 @@ func (sw *StatWindow) Add(t time.Duration) {
 }
 
 func (sw *StatWindow) MakeHistogram() *StatHistogram {
+	sw.Lock()
 	arr := make(StatHistogram, len(sw.window))
 	copy(arr, sw.window)
+	sw.Unlock()
 	sort.Slice(arr, func(i, j int) bool {
 		return arr[i] < arr[j]
 	})
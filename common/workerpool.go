@@ -1,36 +1,108 @@
 package common
 
 import (
+	"errors"
 	"sync"
 )
 
+var ErrPoolStopped = errors.New("worker pool is stopped")
+
 type Worker[I any, O any] struct {
 	Input        I
 	ResponseChan chan O
 }
 
+/*
+WorkerPool runs a function on its inputs with a fixed number of goroutines. Submitting a job blocks until one of the
+workers is free to take it, so callers feel backpressure instead of piling up goroutines.
+*/
 type WorkerPool[I any, O any] struct {
 	sync.Mutex
 	stop     bool
 	maxJobs  int
 	currJobs int
-	JobsChan chan I
+	jobs     chan Worker[I, O]
+	done     chan bool
 	jobFn    func(I) O
-	workers  []Worker[I, O]
+	wg       sync.WaitGroup
 }
 
 func NewWorkerPool[I any, O any](size int, fn func(I) O) *WorkerPool[I, O] {
-	wp := WorkerPool[I, O]{
+	if size < 1 {
+		size = 1
+	}
+	wp := &WorkerPool[I, O]{
 		stop:     false,
 		maxJobs:  size,
 		currJobs: 0,
-		JobsChan: make(chan I, size),
+		jobs:     make(chan Worker[I, O]),
+		done:     make(chan bool),
 		jobFn:    fn,
-		workers:  make([]Worker[I, O], size),
 	}
-	return &wp
+	for i := 0; i < size; i++ {
+		wp.wg.Add(1)
+		go wp.runJobs()
+	}
+	return wp
 }
 
 func (pool *WorkerPool[I, O]) runJobs() {
+	defer pool.wg.Done()
+	for {
+		select {
+		case <-pool.done:
+			return
+		case w := <-pool.jobs:
+			pool.Lock()
+			pool.currJobs++
+			pool.Unlock()
+			out := pool.jobFn(w.Input)
+			pool.Lock()
+			pool.currJobs--
+			pool.Unlock()
+			w.ResponseChan <- out
+		}
+	}
+}
+
+/*
+Hands an input to the next free worker, blocking until one is available. The returned channel receives the job's
+output once it has run. Returns ErrPoolStopped if the pool is stopped before a worker takes the job.
+*/
+func (pool *WorkerPool[I, O]) Submit(input I) (<-chan O, error) {
+	w := Worker[I, O]{
+		Input:        input,
+		ResponseChan: make(chan O, 1),
+	}
+	select {
+	case <-pool.done:
+		return nil, ErrPoolStopped
+	case pool.jobs <- w:
+		return w.ResponseChan, nil
+	}
+}
+
+// Stops the pool from taking new jobs and waits for running jobs to finish
+func (pool *WorkerPool[I, O]) Stop() {
+	pool.Lock()
+	if pool.stop {
+		pool.Unlock()
+		return
+	}
+	pool.stop = true
+	close(pool.done)
+	pool.Unlock()
+	pool.wg.Wait()
+}
+
+// Returns the number of workers in the pool
+func (pool *WorkerPool[I, O]) Size() int {
+	return pool.maxJobs
+}
 
+// Returns the number of jobs currently running
+func (pool *WorkerPool[I, O]) Running() int {
+	pool.Lock()
+	defer pool.Unlock()
+	return pool.currJobs
 }
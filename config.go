@@ -21,6 +21,7 @@ type ServerConfig struct {
 	/* ADMIN SERVER */
 	UseAdminServer bool	`mapstructure:"use_admin_server"`
 	AdminPort      int	`mapstructure:"admin_server_port"`
+	AdminToken     string	`mapstructure:"admin_server_token"`	// Bearer token required for all admin server calls
 
 	/* REDIRECT */
 	UseHttpsRedirectServer bool	`mapstructure:"use_https_redirect_server"`
@@ -50,10 +51,21 @@ type ServerConfig struct {
 	JSMLFilePath         string 		`mapstructure:"jsml_file_path"`					// Where are JSML files stored?
 	UseJSML              bool   		`mapstructure:"use_jsml"`						// Use JSML file templates?
 	JSMLCompiledFilePath string 		`mapstructure:"jsml_compiled_file_path"`		// A subdirectory under the ScriptFilePath where Taproot will put compiled JSML files
+	ScriptPoolSize       int			`mapstructure:"script_pool_size"`				// Number of pre-warmed JS runtimes to keep
+	ScriptTimeout        time.Duration	`mapstructure:"script_timeout"`					// Scripts running longer than this are interrupted (zero for no limit)
+	ScriptMaxCallStack   int			`mapstructure:"script_max_call_stack"`			// Maximum JS call stack depth, to stop runaway recursion
 
 	/* QUEUE */
 	WorkHub WorkHubConfig	`mapstructure:"workhub"`
 
+	/* CRON */
+	Cron CronConfig	`mapstructure:"cron"`
+
+	/* SERVER-SENT EVENTS AND WEBSOCKETS */
+	SSE SSEConfig	`mapstructure:"sse"`
+	WS  WSConfig	`mapstructure:"ws"`
+	Messages MessagesConfig	`mapstructure:"messages"`	// Relays hub writes between server instances
+
 	/* FEATURE FLAGS */
 	Flags ffclient.Config 	// Configuration data for feature flag management
 
@@ -152,7 +164,73 @@ func (c *TLSConfig) IsValid() (bool, error) {
 }
 
 type WorkHubConfig struct {
-	Name        string			`mapstructure:"name"`
-	StorageDir  string			`mapstructure:"storage_path"`
-	SegmentSize int				`mapstructure:"segment_size"`
+	Name               string			`mapstructure:"name"`
+	Backend            string			`mapstructure:"backend"`				// "dque" (the default) or "sql"
+	StorageDir         string			`mapstructure:"storage_path"`			// For the dque backend
+	SegmentSize        int				`mapstructure:"segment_size"`			// For the dque backend
+	SQLDriver          string			`mapstructure:"sql_driver"`			// For the sql backend, e.g. "postgres"; the driver must be imported
+	SQLDSN             string			`mapstructure:"sql_dsn"`				// For the sql backend
+	SQLTable           string			`mapstructure:"sql_table"`			// For the sql backend; defaults to "taproot_work"
+	PollInterval       time.Duration	`mapstructure:"poll_interval"`		// For the sql backend, how often to check for work from other instances
+	DefaultConcurrency int				`mapstructure:"default_concurrency"`	// Requests of a single type that may run at once
+	Concurrency        map[string]int	`mapstructure:"concurrency"`			// Per-type overrides of DefaultConcurrency
+	StatusRetention    time.Duration	`mapstructure:"status_retention"`		// How long statuses of finished work are kept
+	StatusSSEHub       string			`mapstructure:"status_sse_hub"`		// If set, status changes are pushed to users through this SSE hub
+}
+
+type CronConfig struct {
+	StateFile string	`mapstructure:"state_file"`	// If set, job run times are kept in this file, so runs missed during restarts can be caught up
+	Lock      string	`mapstructure:"lock"`			// "file" or "sql" to run each scheduled run on only one server; empty to run on every server
+	LockDir   string	`mapstructure:"lock_dir"`		// For the file lock, a directory shared by the servers on a host
+	SQLDriver string	`mapstructure:"sql_driver"`	// For the sql lock, e.g. "postgres"; the driver must be imported
+	SQLDSN    string	`mapstructure:"sql_dsn"`		// For the sql lock
+	SQLTable  string	`mapstructure:"sql_table"`		// For the sql lock; defaults to "taproot_cron_leases"
+	Jobs      map[string]CronJobConfig	`mapstructure:"jobs"`	// Jobs to schedule at startup, by name
+}
+
+// A cron job declared in config, which either runs a script or enqueues work
+type CronJobConfig struct {
+	Schedule string			`mapstructure:"schedule"`		// A cron expression, optionally prefixed with CRON_TZ=ZONE
+	TimeZone string			`mapstructure:"time_zone"`		// e.g. "America/New_York"; defaults to the server's local time
+	Jitter   time.Duration	`mapstructure:"jitter"`		// Each scheduled run starts after a random delay of up to this long
+	Script   string			`mapstructure:"script"`		// A server-side JS file to run, relative to script_file_path
+	WorkType string			`mapstructure:"work_type"`		// Or a type of work to enqueue
+	WorkData string			`mapstructure:"work_data"`		// JSON data for the enqueued work, if any
+	CatchUp  string			`mapstructure:"catch_up"`		// "skip" (the default), "once", or "all"
+	Overlap  string			`mapstructure:"overlap"`		// "allow" (the default), "forbid", or "replace"
+	Timeout  time.Duration	`mapstructure:"timeout"`
+	Local    bool			`mapstructure:"local"`			// Run on every server, even if a cron lock is configured
+}
+
+type SSEConfig struct {
+	Hubs map[string]SSEHubConfig	`mapstructure:"hubs"`	// Settings for hubs added with AddSSEHub(), by hub name
+}
+
+type SSEHubConfig struct {
+	ReplayEvents int			`mapstructure:"replay_events"`		// Keep up to this many events with IDs, to replay to clients that reconnect
+	ReplayMaxAge time.Duration	`mapstructure:"replay_max_age"`	// Don't replay events older than this
+	ReplayFile   string			`mapstructure:"replay_file"`		// If set, events are also kept in this file, so they can be replayed after a restart
+	BufferSize   int			`mapstructure:"buffer_size"`		// Events queued for each connection; defaults to 64
+	Overflow     string			`mapstructure:"overflow"`			// When a connection's queue is full: "drop-oldest" (the default), "drop-newest", or "disconnect"
+	Heartbeat    time.Duration	`mapstructure:"heartbeat"`			// How often to send a keep-alive comment; defaults to 30s, negative for never
+}
+
+type WSConfig struct {
+	Hubs map[string]WSHubConfig	`mapstructure:"hubs"`	// Settings for hubs added with AddWSHub(), by hub name
+}
+
+type WSHubConfig struct {
+	BufferSize     int			`mapstructure:"buffer_size"`		// Frames queued for each connection; defaults to 64
+	Overflow       string			`mapstructure:"overflow"`			// When a connection's queue is full: "drop-oldest" (the default), "drop-newest", or "disconnect"
+	PingInterval   time.Duration	`mapstructure:"ping_interval"`		// How often to ping each connection; defaults to 30s, negative for never
+	PresenceFrames bool			`mapstructure:"presence_frames"`	// Send join and leave events to the connections in a room
+}
+
+type MessagesConfig struct {
+	Broker       string			`mapstructure:"broker"`			// "sql" to relay SSE and WebSocket hub writes between servers; empty for none
+	SQLDriver    string			`mapstructure:"sql_driver"`		// For the sql broker, e.g. "postgres"; the driver must be imported
+	SQLDSN       string			`mapstructure:"sql_dsn"`			// For the sql broker
+	SQLTable     string			`mapstructure:"sql_table"`			// For the sql broker; defaults to "taproot_messages"
+	PollInterval time.Duration	`mapstructure:"poll_interval"`		// For the sql broker, how often to check for messages; defaults to 250ms
+	Retention    time.Duration	`mapstructure:"retention"`			// For the sql broker, how long messages are kept; defaults to 1m
 }
@@ -30,5 +30,25 @@ func loadConfig(cfgDirs []string) (ServerConfig, error) {
 		panic(err)
 	}
 
+	err = viper.UnmarshalKey("cron", &cfg.Cron)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = viper.UnmarshalKey("sse", &cfg.SSE)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = viper.UnmarshalKey("ws", &cfg.WS)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = viper.UnmarshalKey("messages", &cfg.Messages)
+	if err != nil {
+		return cfg, err
+	}
+
 	return cfg, nil
 }
@@ -1,13 +1,79 @@
 package cron
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
-type CronJob func() error
+/*
+A job run by a CronHub. The context is cancelled when the job times out, when the run is replaced (see
+OVERLAP_REPLACE), or when the hub is stopped; long-running jobs should watch it and return early. A returned error is
+recorded in the job's run history.
+*/
+type CronJob func(ctx context.Context) error
+
+// What a CronHub does about runs of a job that were missed while the server was down
+type CatchUpPolicy string
+
+const (
+	CATCHUP_SKIP CatchUpPolicy = "skip" // Missed runs are dropped (the default)
+	CATCHUP_ONCE CatchUpPolicy = "once" // The job is run once if any runs were missed
+	CATCHUP_ALL  CatchUpPolicy = "all"  // The job is run once for each missed run, one after another
+)
+
+// Catch-up runs beyond this many are dropped
+const CRON_MAX_CATCHUP_RUNS int = 100
+
+// What a CronHub does when a job comes due while its previous run is still going
+type OverlapPolicy string
+
+const (
+	OVERLAP_ALLOW   OverlapPolicy = "allow"   // Runs overlap (the default)
+	OVERLAP_FORBID  OverlapPolicy = "forbid"  // The new run is skipped
+	OVERLAP_REPLACE OverlapPolicy = "replace" // The new run starts, and the previous run's context is cancelled
+)
+
+type JobOptions struct {
+	CatchUp     CatchUpPolicy
+	Overlap     OverlapPolicy
+	Timeout     time.Duration  // If set, each run's context is cancelled after this long
+	HistorySize int            // Number of recent runs to keep; defaults to CRON_DEFAULT_HISTORY_SIZE
+	Local       bool           // Runs on every server, rather than being claimed through the hub's CronLocker
+	Location    *time.Location // Time zone the schedule is read in, unless it has a CRON_TZ= prefix; defaults to local time
+	Jitter      time.Duration  // If set, scheduled runs start after a random delay of up to this long
+}
 
 type CronEntry struct {
 	Name        string
 	Malformed   bool
 	Schedule    string
 	NextRunTime time.Time
+	LastRunTime time.Time
+	Options     JobOptions
 	Job         CronJob
+	running     int                // runs in progress
+	generation  int                // incremented when running runs are replaced, so they aren't counted when they finish
+	runCtx      context.Context    // parent of the current generation's runs
+	cancelRuns  context.CancelFunc // cancels the current generation's runs
+	missed      int                // runs missed while the server was down, still to be caught up
+	missedDueAt time.Time          // when the latest missed run was due
+	location    *time.Location     // time zone the schedule is read in
+	history     *cronHistory
+}
+
+// A snapshot of a scheduled job, for reporting
+type CronJobInfo struct {
+	Name        string        `json:"name"`
+	Schedule    string        `json:"schedule"`
+	NextRunTime time.Time     `json:"nextRunTime"`
+	LastRunTime time.Time     `json:"lastRunTime"`
+	LastError   string        `json:"lastError,omitempty"` // The error from the most recent run, if it failed
+	Malformed   bool          `json:"malformed"`
+	Running     bool          `json:"running"`
+	CatchUp     CatchUpPolicy `json:"catchUp"`
+	Overlap     OverlapPolicy `json:"overlap"`
+	Timeout     time.Duration `json:"timeout"`
+	Local       bool          `json:"local"`
+	TimeZone    string        `json:"timeZone"`
+	Jitter      time.Duration `json:"jitter"`
 }
@@ -0,0 +1,51 @@
+package cron
+
+import "time"
+
+// Number of recent runs kept for each job, unless its options say otherwise
+const CRON_DEFAULT_HISTORY_SIZE int = 20
+
+// The outcome of a single run of a job
+type CronRun struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Manual   bool          `json:"manual"` // Run through CronHub.RunJob() rather than on schedule
+	Error    string        `json:"error,omitempty"`
+	TimedOut bool          `json:"timedOut,omitempty"`
+	Panic    string        `json:"panic,omitempty"` // The stack trace, if the job panicked
+}
+
+// A ring buffer of a job's most recent runs
+type cronHistory struct {
+	runs []CronRun
+	next int
+	full bool
+}
+
+func newCronHistory(size int) *cronHistory {
+	if size < 1 {
+		size = CRON_DEFAULT_HISTORY_SIZE
+	}
+	return &cronHistory{runs: make([]CronRun, size)}
+}
+
+func (h *cronHistory) add(run CronRun) {
+	h.runs[h.next] = run
+	h.next = (h.next + 1) % len(h.runs)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Returns the runs, newest first
+func (h *cronHistory) list() []CronRun {
+	n := h.next
+	if h.full {
+		n = len(h.runs)
+	}
+	res := make([]CronRun, 0, n)
+	for i := 1; i <= n; i++ {
+		res = append(res, h.runs[(h.next-i+len(h.runs))%len(h.runs)])
+	}
+	return res
+}
@@ -3,17 +3,34 @@ package cron
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/gorhill/cronexpr"
 	"github.com/highgrav/taproot/logging"
+	"math/rand"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 var ErrNamedJobAlreadyExists = errors.New("job already exists, please remove by name first")
+var ErrJobNotFound = errors.New("job not found")
+var ErrJobRunning = errors.New("job is already running")
 
 /*
 The CronHub schedules jobs using a simple cron syntax (see github.com/gorhill/cronexpr).
-Unlike workers, cronjobs are not durable between restarts, so if a server is down, jobs may be missed.
+
+The hub records when each job last ran in its CronStateStore. When a job is added, runs it missed while the server was
+down are caught up according to its CatchUpPolicy, on the hub's next check for due jobs. With the default memory
+store, nothing is recorded between restarts, so missed runs are lost.
+
+Each job runs with a context that's cancelled when the hub is stopped, and its recent runs, with their errors and any
+panics, are kept for reporting; see History().
+
+When several servers share the same jobs, give each hub a CronLocker (see SetLocker()), so each scheduled run happens
+on only one of them. Jobs with JobOptions.Local set run on every server regardless.
 */
 type CronHub struct {
 	sync.Mutex
@@ -22,44 +39,148 @@ type CronHub struct {
 	Done    chan bool
 	Paused  bool
 	Metrics *CronMetrics
+	store   CronStateStore
+	locker  CronLocker
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stop    sync.Once
 }
 
+// Creates a hub that keeps run times in memory
 func New() *CronHub {
+	return NewWithStore(NewMemoryCronStateStore())
+}
+
+// Creates a hub that keeps run times in a state store, so missed runs can be caught up after a restart
+func NewWithStore(store CronStateStore) *CronHub {
 	ch := &CronHub{
 		Mutex:   sync.Mutex{},
 		Entries: make(map[string]*CronEntry, 0),
 		Pause:   make(chan bool),
 		Done:    make(chan bool),
+		Metrics: NewCronMetrics(),
+		store:   store,
 	}
+	ch.ctx, ch.cancel = context.WithCancel(context.Background())
 
 	go ch.loopForJobs()
 	return ch
 }
 
-// Adds a job with a name
+// Sets the locker used to claim scheduled runs, so that they run on only one server. A nil locker claims every run.
+func (ch *CronHub) SetLocker(locker CronLocker) {
+	ch.Lock()
+	defer ch.Unlock()
+	ch.locker = locker
+}
+
+// Adds a job with a name, which skips missed runs and allows runs to overlap
 func (ch *CronHub) AddJob(name, schedule string, job CronJob) error {
-	if _, ok := ch.Entries[name]; ok {
-		return ErrNamedJobAlreadyExists
-	}
+	return ch.AddJobWithOptions(name, schedule, job, JobOptions{})
+}
 
+// Adds a job with a name, and policies for missed and overlapping runs
+func (ch *CronHub) AddJobWithOptions(name, schedule string, job CronJob, opts JobOptions) error {
+	if opts.CatchUp == "" {
+		opts.CatchUp = CATCHUP_SKIP
+	}
+	if opts.Overlap == "" {
+		opts.Overlap = OVERLAP_ALLOW
+	}
 	entry := &CronEntry{
 		Name:        name,
 		Malformed:   false,
 		Schedule:    schedule,
 		NextRunTime: time.Time{},
+		Options:     opts,
 		Job:         job,
+		history:     newCronHistory(opts.HistorySize),
 	}
-	t, err := cronexpr.Parse(entry.Schedule)
+	t, loc, err := parseSchedule(entry.Schedule)
 	if err != nil {
 		return err
 	}
-	entry.NextRunTime = t.Next(time.Now())
+	if loc == nil {
+		loc = opts.Location
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	entry.location = loc
+	now := time.Now().In(loc)
+	entry.NextRunTime = t.Next(now)
+
 	ch.Lock()
 	defer ch.Unlock()
+	if _, ok := ch.Entries[name]; ok {
+		return ErrNamedJobAlreadyExists
+	}
+	lastRun, err := ch.store.LastRun(name)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "CRON", "error", "could not get last run time of "+name+": "+err.Error())
+	} else if lastRun.IsZero() {
+		// start the record now, so runs missed while the server is down can be caught up even if the job hasn't run yet
+		ch.saveLastRun(name, now)
+	} else {
+		entry.LastRunTime = lastRun
+		entry.missed, entry.missedDueAt = missedRuns(t, lastRun.In(loc), now, opts.CatchUp)
+		if entry.missed > 0 {
+			logging.LogToDeck(context.Background(), "info", "CRON", "info", "job "+name+" will catch up "+strconv.Itoa(entry.missed)+" missed run(s)")
+		}
+	}
 	ch.Entries[name] = entry
 	return nil
 }
 
+/*
+Parses a cron expression, which may be prefixed with CRON_TZ=ZONE or TZ=ZONE (e.g. "CRON_TZ=America/New_York 0 3 * * *")
+to run it in a time zone other than the hub's default. The location is nil if there's no prefix.
+*/
+func parseSchedule(schedule string) (*cronexpr.Expression, *time.Location, error) {
+	var loc *time.Location
+	schedule = strings.TrimSpace(schedule)
+	if strings.HasPrefix(schedule, "CRON_TZ=") || strings.HasPrefix(schedule, "TZ=") {
+		zone, expr, _ := strings.Cut(schedule, " ")
+		_, name, _ := strings.Cut(zone, "=")
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		loc, schedule = l, expr
+	}
+	expr, err := cronexpr.Parse(schedule)
+	if err != nil {
+		return nil, nil, err
+	}
+	return expr, loc, nil
+}
+
+/*
+Returns how many runs due between lastRun and now should be caught up under a policy, and when the latest run that
+was missed was due.
+*/
+func missedRuns(expr *cronexpr.Expression, lastRun, now time.Time, policy CatchUpPolicy) (int, time.Time) {
+	if policy != CATCHUP_ONCE && policy != CATCHUP_ALL {
+		return 0, time.Time{}
+	}
+	n := 0
+	var dueAt time.Time
+	for t := expr.Next(lastRun); !t.IsZero() && !t.After(now); t = expr.Next(t) {
+		dueAt = t
+		if n < CRON_MAX_CATCHUP_RUNS && (policy == CATCHUP_ALL || n == 0) {
+			n++
+		}
+	}
+	return n, dueAt
+}
+
+func (ch *CronHub) saveLastRun(name string, t time.Time) {
+	err := ch.store.SaveLastRun(name, t)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "CRON", "error", "could not save last run time of "+name+": "+err.Error())
+	}
+}
+
 // Removes a job using its unique name
 func (ch *CronHub) RemoveJob(name string) {
 	ch.Lock()
@@ -67,18 +188,105 @@ func (ch *CronHub) RemoveJob(name string) {
 	delete(ch.Entries, name)
 }
 
+// Stops scheduling jobs and cancels the contexts of any running jobs. The hub can't be restarted.
+func (ch *CronHub) Stop() {
+	ch.stop.Do(func() {
+		ch.cancel()
+		close(ch.Done)
+	})
+}
+
+// Returns a job's recent runs, newest first
+func (ch *CronHub) History(name string) ([]CronRun, error) {
+	ch.Lock()
+	defer ch.Unlock()
+	entry, ok := ch.Entries[name]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return entry.history.list(), nil
+}
+
+// Returns a snapshot of all jobs, sorted by name
+func (ch *CronHub) Jobs() []CronJobInfo {
+	ch.Lock()
+	defer ch.Unlock()
+	jobs := make([]CronJobInfo, 0, len(ch.Entries))
+	for _, entry := range ch.Entries {
+		lastError := ""
+		if runs := entry.history.list(); len(runs) > 0 {
+			lastError = runs[0].Error
+		}
+		jobs = append(jobs, CronJobInfo{
+			Name:        entry.Name,
+			Schedule:    entry.Schedule,
+			NextRunTime: entry.NextRunTime,
+			LastRunTime: entry.LastRunTime,
+			LastError:   lastError,
+			Malformed:   entry.Malformed,
+			Running:     entry.running > 0,
+			CatchUp:     entry.Options.CatchUp,
+			Overlap:     entry.Options.Overlap,
+			Timeout:     entry.Options.Timeout,
+			Local:       entry.Options.Local,
+			TimeZone:    entry.location.String(),
+			Jitter:      entry.Options.Jitter,
+		})
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+	return jobs
+}
+
+/*
+Runs a job immediately, whether or not the hub is paused. The job's schedule is not affected. Returns ErrJobRunning if
+the job forbids overlapping runs and is already running.
+*/
+func (ch *CronHub) RunJob(name string) error {
+	ch.Lock()
+	defer ch.Unlock()
+	entry, ok := ch.Entries[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	logging.LogToDeck(context.Background(), "info", "CRON", "info", "manually running job "+name)
+	if !ch.startRun(entry, 1, false) {
+		return ErrJobRunning
+	}
+	return nil
+}
+
+// Pauses or resumes running of scheduled jobs. Jobs that come due while the hub is paused are skipped.
+func (ch *CronHub) SetPaused(paused bool) {
+	ch.Lock()
+	resumed := ch.Paused && !paused
+	ch.Paused = paused
+	ch.Unlock()
+	if resumed {
+		ch.scheduleAll()
+	}
+}
+
+// Returns true if the hub is not currently running jobs
+func (ch *CronHub) IsPaused() bool {
+	ch.Lock()
+	defer ch.Unlock()
+	return ch.Paused
+}
+
 func (ch *CronHub) scheduleAll() {
 	ch.Lock()
 	defer ch.Unlock()
 	currTime := time.Now()
 	for name, entry := range ch.Entries {
-		t, err := cronexpr.Parse(entry.Schedule)
-		if err == nil {
+		t, _, err := parseSchedule(entry.Schedule)
+		if err != nil {
 			entry.Malformed = true
 			logging.LogToDeck(context.Background(), "error", "CRON", "error", "Malformed cron entry for "+name+" ("+entry.Schedule+")")
 			continue
 		}
-		entry.NextRunTime = t.Next(currTime)
+		entry.NextRunTime = t.Next(currTime.In(entry.location))
 	}
 }
 
@@ -92,9 +300,9 @@ func (ch *CronHub) loopForJobs() {
 			case <-ch.Done:
 				return
 			case <-ch.Pause:
-				ch.Paused = !ch.Paused
+				ch.SetPaused(!ch.IsPaused())
 			case t := <-ticker.C:
-				if !ch.Paused {
+				if !ch.IsPaused() {
 					ch.runJobs(t)
 				}
 			}
@@ -102,22 +310,158 @@ func (ch *CronHub) loopForJobs() {
 	}()
 }
 
+// A run that has come due, and when it was due
+type dueRun struct {
+	entry *CronEntry
+	runs  int
+	dueAt time.Time
+}
+
 func (ch *CronHub) runJobs(currTime time.Time) {
 	ch.Lock()
-	defer ch.Unlock()
+	due := make([]dueRun, 0)
 	for _, entry := range ch.Entries {
 		if entry.Malformed {
 			continue
 		}
+		if entry.missed > 0 {
+			logging.LogToDeck(context.Background(), "info", "CRON", "info", "catching up "+strconv.Itoa(entry.missed)+" missed run(s) of job "+entry.Name)
+			due = append(due, dueRun{entry: entry, runs: entry.missed, dueAt: entry.missedDueAt})
+			entry.missed = 0
+		}
 		if currTime.After(entry.NextRunTime) {
-			t, err := cronexpr.Parse(entry.Schedule)
+			t, _, err := parseSchedule(entry.Schedule)
 			if err != nil {
 				logging.LogToDeck(context.Background(), "error", "CRON", "error", "malformed cron entry for "+entry.Name+" ("+entry.Schedule+")")
 				entry.Malformed = true
 				continue
 			}
-			entry.NextRunTime = t.Next(time.Now())
-			go entry.Job()
+			due = append(due, dueRun{entry: entry, runs: 1, dueAt: entry.NextRunTime})
+			entry.NextRunTime = t.Next(time.Now().In(entry.location))
+		}
+	}
+	locker := ch.locker
+	ch.Unlock()
+
+	// claims can take a round trip to a database, so they're made without holding the hub
+	for _, d := range due {
+		if !ch.claim(locker, d) {
+			continue
+		}
+		ch.Lock()
+		ch.startRun(d.entry, d.runs, true)
+		ch.Unlock()
+	}
+}
+
+// Returns true if this server should make a run, because it claimed it or the job runs everywhere
+func (ch *CronHub) claim(locker CronLocker, d dueRun) bool {
+	if locker == nil || d.entry.Options.Local {
+		return true
+	}
+	ok, err := locker.Claim(d.entry.Name, d.dueAt)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "CRON", "error", "could not claim run of job "+d.entry.Name+", skipping it: "+err.Error())
+		return false
+	}
+	if !ok {
+		ch.Metrics.claimedElsewhere(d.entry.Name)
+	}
+	return ok
+}
+
+/*
+Starts running a job in the background, the given number of times in a row, applying the job's overlap policy.
+Scheduled runs are recorded in the state store. Returns false if the run was skipped. Must be called with the hub
+locked.
+*/
+func (ch *CronHub) startRun(entry *CronEntry, runs int, scheduled bool) bool {
+	if entry.running > 0 {
+		switch entry.Options.Overlap {
+		case OVERLAP_FORBID:
+			logging.LogToDeck(context.Background(), "info", "CRON", "info", "skipping job "+entry.Name+", since its previous run is still going")
+			ch.Metrics.skipped(entry.Name)
+			return false
+		case OVERLAP_REPLACE:
+			logging.LogToDeck(context.Background(), "info", "CRON", "info", "replacing the running job "+entry.Name)
+			entry.cancelRuns()
+			entry.runCtx = nil
+			entry.generation++
+			entry.running = 0
+		}
+	}
+	if entry.runCtx == nil {
+		entry.runCtx, entry.cancelRuns = context.WithCancel(ch.ctx)
+	}
+	entry.running++
+	if scheduled {
+		entry.LastRunTime = time.Now()
+		ch.saveLastRun(entry.Name, entry.LastRunTime)
+	}
+	go ch.run(entry, entry.runCtx, runs, entry.generation, !scheduled)
+	return true
+}
+
+func (ch *CronHub) run(entry *CronEntry, ctx context.Context, runs int, generation int, manual bool) {
+	defer func() {
+		ch.Lock()
+		if entry.generation == generation {
+			entry.running--
+		}
+		ch.Unlock()
+	}()
+	// spread scheduled runs out, so servers and jobs sharing a schedule don't all start at once
+	if !manual && entry.Options.Jitter > 0 {
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(entry.Options.Jitter))))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+	for i := 0; i < runs; i++ {
+		// stop catching up if this run has been replaced, or the hub stopped
+		if ctx.Err() != nil {
+			return
+		}
+		ch.Metrics.started(entry.Name)
+		run := runJob(ctx, entry.Job, entry.Options.Timeout)
+		run.Manual = manual
+		ch.Metrics.finished(entry.Name, run)
+		if run.Panic != "" {
+			logging.LogToDeck(context.Background(), "error", "CRON", "error", "job "+entry.Name+" panicked: "+run.Error+"\n"+run.Panic)
+		} else if run.Error != "" {
+			logging.LogToDeck(context.Background(), "error", "CRON", "error", "job "+entry.Name+" failed: "+run.Error)
+		}
+		ch.Lock()
+		entry.history.add(run)
+		ch.Unlock()
+	}
+}
+
+// Runs a job once, applying its timeout and recovering from any panic
+func runJob(ctx context.Context, job CronJob, timeout time.Duration) (run CronRun) {
+	run.Start = time.Now()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			run.Error = fmt.Sprintf("job panicked: %v", r)
+			run.Panic = string(debug.Stack())
 		}
+		run.Duration = time.Since(run.Start)
+		run.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+		if run.TimedOut && run.Error == "" {
+			run.Error = ctx.Err().Error()
+		}
+	}()
+	err := job(ctx)
+	if err != nil {
+		run.Error = err.Error()
 	}
+	return run
 }
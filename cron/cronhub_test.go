@@ -0,0 +1,316 @@
+package cron
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gorhill/cronexpr"
+	"github.com/highgrav/taproot/dbutils"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestScheduleAll(t *testing.T) {
+	ch := New()
+	defer ch.Stop()
+	if err := ch.AddJob("good", "0 3 * * *", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	ch.Entries["bad"] = &CronEntry{Name: "bad", Schedule: "not a schedule"}
+	ch.scheduleAll()
+	if ch.Entries["good"].Malformed || !ch.Entries["good"].NextRunTime.After(time.Now()) {
+		t.Fatalf("valid job was not scheduled: %+v", ch.Entries["good"])
+	}
+	if !ch.Entries["bad"].Malformed {
+		t.Fatal("invalid job was not marked malformed")
+	}
+}
+
+func TestMissedRuns(t *testing.T) {
+	expr := cronexpr.MustParse("0 * * * *")
+	last := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	now := time.Date(2023, 5, 1, 15, 30, 0, 0, time.UTC)
+	for policy, want := range map[CatchUpPolicy]int{CATCHUP_SKIP: 0, CATCHUP_ONCE: 1, CATCHUP_ALL: 5} {
+		if n, _ := missedRuns(expr, last, now, policy); n != want {
+			t.Fatalf("%s: expected %d missed runs, got %d", policy, want, n)
+		}
+	}
+	n, dueAt := missedRuns(expr, last.AddDate(-1, 0, 0), now, CATCHUP_ALL)
+	if n != CRON_MAX_CATCHUP_RUNS {
+		t.Fatalf("expected catch-up to be capped, got %d", n)
+	}
+	if !dueAt.Equal(time.Date(2023, 5, 1, 15, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected due time %v", dueAt)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cron.json")
+	store, err := NewFileCronStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	last := now.Truncate(time.Minute).Add(-5 * time.Minute)
+	for _, name := range []string{"skip", "once", "all"} {
+		store.SaveLastRun(name, last)
+	}
+	// reopen, as a restarted server would
+	store, err = NewFileCronStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := NewWithStore(store)
+	defer ch.Stop()
+	counts := make(map[string]*int32)
+	for _, policy := range []CatchUpPolicy{CATCHUP_SKIP, CATCHUP_ONCE, CATCHUP_ALL} {
+		n := new(int32)
+		counts[string(policy)] = n
+		err := ch.AddJobWithOptions(string(policy), "* * * * *", func(ctx context.Context) error {
+			atomic.AddInt32(n, 1)
+			return nil
+		}, JobOptions{CatchUp: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch.AddJob("new", "* * * * *", func(ctx context.Context) error { return nil })
+	ch.runJobs(now)
+
+	waitFor(t, "catch-up runs", func() bool { return atomic.LoadInt32(counts["all"]) == 5 })
+	if n := atomic.LoadInt32(counts["once"]); n != 1 {
+		t.Fatalf("expected 1 catch-up run, got %d", n)
+	}
+	if n := atomic.LoadInt32(counts["skip"]); n != 0 {
+		t.Fatalf("expected no catch-up runs, got %d", n)
+	}
+	if lr, _ := store.LastRun("all"); !lr.After(last) {
+		t.Fatal("catch-up run was not recorded")
+	}
+	if lr, _ := store.LastRun("new"); lr.IsZero() {
+		t.Fatal("new job was not recorded")
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	ch := New()
+	defer ch.Stop()
+	release := make(chan bool)
+	ch.AddJobWithOptions("forbid", "0 3 * * *", func(ctx context.Context) error {
+		<-release
+		return nil
+	}, JobOptions{Overlap: OVERLAP_FORBID})
+	gates := []chan bool{make(chan bool), make(chan bool)}
+	var replaceRuns int32
+	ch.AddJobWithOptions("replace", "0 3 * * *", func(ctx context.Context) error {
+		n := atomic.AddInt32(&replaceRuns, 1)
+		<-gates[n-1]
+		return nil
+	}, JobOptions{Overlap: OVERLAP_REPLACE})
+	running := func(name string) bool {
+		for _, j := range ch.Jobs() {
+			if j.Name == name {
+				return j.Running
+			}
+		}
+		return false
+	}
+
+	if err := ch.RunJob("forbid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.RunJob("forbid"); err != ErrJobRunning {
+		t.Fatalf("expected ErrJobRunning, got %v", err)
+	}
+	close(release)
+	waitFor(t, "forbidden job to finish", func() bool { return !running("forbid") })
+	if err := ch.RunJob("forbid"); err != nil {
+		t.Fatal(err)
+	}
+
+	ch.RunJob("replace")
+	waitFor(t, "first run to start", func() bool { return atomic.LoadInt32(&replaceRuns) == 1 })
+	if err := ch.RunJob("replace"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "second run to start", func() bool { return atomic.LoadInt32(&replaceRuns) == 2 })
+	// the abandoned run finishing doesn't end the run that replaced it
+	close(gates[0])
+	time.Sleep(20 * time.Millisecond)
+	if !running("replace") {
+		t.Fatal("replacing run should still be running")
+	}
+	close(gates[1])
+	waitFor(t, "replacing run to finish", func() bool { return !running("replace") })
+}
+
+func TestRunHistory(t *testing.T) {
+	ch := New()
+	ch.AddJobWithOptions("slow", "0 3 * * *", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, JobOptions{Timeout: 20 * time.Millisecond, HistorySize: 2})
+	ch.AddJob("panics", "0 3 * * *", func(ctx context.Context) error {
+		panic("oops")
+	})
+	ch.AddJob("fails", "0 3 * * *", func(ctx context.Context) error {
+		return errors.New("failed")
+	})
+	ch.AddJob("waits", "0 3 * * *", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	history := func(name string) []CronRun {
+		runs, err := ch.History(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runs
+	}
+
+	for i := 0; i < 3; i++ {
+		ch.RunJob("slow")
+	}
+	ch.RunJob("panics")
+	ch.RunJob("fails")
+	ch.RunJob("waits")
+	waitFor(t, "runs to finish", func() bool {
+		return len(history("slow")) == 2 && len(history("panics")) == 1 && len(history("fails")) == 1
+	})
+	if run := history("slow")[0]; !run.TimedOut || !run.Manual || run.Error == "" || run.Duration < 20*time.Millisecond {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	if run := history("panics")[0]; run.Panic == "" || run.Error != "job panicked: oops" {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	if _, err := ch.History("missing"); err != ErrJobNotFound {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+
+	// stopping the hub cancels running jobs
+	ch.Stop()
+	waitFor(t, "running job to be cancelled", func() bool { return len(history("waits")) == 1 })
+	rpt := ch.Metrics.Snapshot()
+	if rpt.Failed != 5 || rpt.TimedOut != 3 || rpt.Panicked != 1 || rpt.Succeeded != 1 || rpt.Jobs["slow"].Failed != 3 {
+		t.Fatalf("unexpected metrics %+v", rpt)
+	}
+	for _, j := range ch.Jobs() {
+		if j.Name == "fails" && j.LastError != "failed" {
+			t.Fatalf("unexpected job info %+v", j)
+		}
+	}
+}
+
+func TestLockers(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cron.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := NewSQLCronLocker(db, "sqlite3", "leases; DROP TABLE x"); err != dbutils.ErrInvalidTableName {
+		t.Fatalf("expected ErrInvalidTableName, got %v", err)
+	}
+	dir := t.TempDir()
+	lockers := map[string]func() CronLocker{
+		"sql": func() CronLocker {
+			l, err := NewSQLCronLocker(db, "sqlite3", "cron_leases")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return l
+		},
+		"file": func() CronLocker {
+			l, err := NewFileCronLocker(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return l
+		},
+	}
+
+	for kind, newLocker := range lockers {
+		// three hubs sharing a locker stand in for three servers
+		var runs, localRuns int32
+		hubs := make([]*CronHub, 3)
+		for i := range hubs {
+			hubs[i] = New()
+			defer hubs[i].Stop()
+			hubs[i].SetLocker(newLocker())
+			hubs[i].AddJob("shared", "* * * * *", func(ctx context.Context) error {
+				atomic.AddInt32(&runs, 1)
+				return nil
+			})
+			hubs[i].AddJobWithOptions("local", "* * * * *", func(ctx context.Context) error {
+				atomic.AddInt32(&localRuns, 1)
+				return nil
+			}, JobOptions{Local: true})
+		}
+		for round := 1; round <= 2; round++ {
+			var wg sync.WaitGroup
+			for _, ch := range hubs {
+				ch.Lock()
+				for _, entry := range ch.Entries {
+					entry.NextRunTime = time.Date(2023, 5, 1, 10, round, 0, 0, time.UTC)
+				}
+				ch.Unlock()
+				wg.Add(1)
+				go func(ch *CronHub) {
+					defer wg.Done()
+					ch.runJobs(time.Now())
+				}(ch)
+			}
+			wg.Wait()
+			waitFor(t, kind+" runs", func() bool {
+				return atomic.LoadInt32(&runs) == int32(round) && atomic.LoadInt32(&localRuns) == int32(3*round)
+			})
+		}
+		elsewhere := int64(0)
+		for _, ch := range hubs {
+			elsewhere += ch.Metrics.Snapshot().Elsewhere
+		}
+		if elsewhere != 4 {
+			t.Fatalf("%s: expected 4 runs claimed elsewhere, got %d", kind, elsewhere)
+		}
+	}
+}
+
+func TestTimeZones(t *testing.T) {
+	ch := New()
+	defer ch.Stop()
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	if err := ch.AddJob("prefixed", "CRON_TZ=America/New_York 30 3 * * *", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.AddJobWithOptions("option", "30 3 * * *", func(ctx context.Context) error { return nil }, JobOptions{Location: ny}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.AddJob("bad", "TZ=Nowhere/Special 30 3 * * *", func(ctx context.Context) error { return nil }); err == nil {
+		t.Fatal("expected an unknown time zone to fail")
+	}
+	for _, j := range ch.Jobs() {
+		next := j.NextRunTime.In(ny)
+		if j.TimeZone != "America/New_York" || next.Hour() != 3 || next.Minute() != 30 {
+			t.Fatalf("unexpected schedule %+v", j)
+		}
+	}
+}
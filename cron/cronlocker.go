@@ -0,0 +1,26 @@
+package cron
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+A CronLocker makes sure each scheduled run of a job happens on only one of the servers sharing it. Before running a
+job on schedule, each server tries to claim the run, identified by the job's name and the time the run was due;
+since every server computes the same due times from the same schedule, only one claim for each run succeeds.
+
+Claim() must return true for exactly one caller for each name and due time, and false for a due time at or before one
+that's already been claimed. A leader-election scheme can also be plugged in here, by claiming every run while this
+server is the leader.
+*/
+type CronLocker interface {
+	Claim(name string, dueAt time.Time) (bool, error)
+}
+
+// Identifies this server in lock records, for debugging
+func lockHolder() string {
+	host, _ := os.Hostname()
+	return host + ":" + strconv.Itoa(os.Getpid())
+}
@@ -0,0 +1,54 @@
+package cron
+
+import (
+	"github.com/gofrs/flock"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+A CronLocker for servers on a single host, which share a directory of lock files. Each job has its own file, holding
+the due time of its last claimed run, which is locked with flock() while a claim is made.
+*/
+type FileCronLocker struct {
+	dir string
+}
+
+func NewFileCronLocker(dir string) (*FileCronLocker, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileCronLocker{dir: dir}, nil
+}
+
+func (fl *FileCronLocker) Claim(name string, dueAt time.Time) (bool, error) {
+	path := filepath.Join(fl.dir, url.PathEscape(name)+".lock")
+	lock := flock.New(path)
+	err := lock.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) > 0 {
+		claimed, err := strconv.ParseInt(fields[0], 10, 64)
+		if err == nil && claimed >= dueAt.UnixNano() {
+			return false, nil
+		}
+	}
+	err = os.WriteFile(path, []byte(strconv.FormatInt(dueAt.UnixNano(), 10)+" "+lockHolder()+"\n"), 0644)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
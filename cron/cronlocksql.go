@@ -0,0 +1,59 @@
+package cron
+
+import (
+	"database/sql"
+	"github.com/highgrav/taproot/dbutils"
+	"time"
+)
+
+/*
+A CronLocker for servers sharing a database, which keeps a lease table with a row for each job, holding the due time of
+its last claimed run. A claim is a single upsert that only succeeds if the run is later than the one already claimed,
+so it's safe without any other locking. Postgres and SQLite are supported.
+*/
+type SQLCronLocker struct {
+	db     *sql.DB
+	claim  string
+	holder string
+}
+
+/*
+Creates a SQL locker using a table in an open database, creating the table if it doesn't exist. The driver is the name
+the database was opened with, such as "postgres" or "sqlite3".
+*/
+func NewSQLCronLocker(db *sql.DB, driver string, table string) (*SQLCronLocker, error) {
+	dialect, err := dbutils.DialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	err = dbutils.CheckTableName(table)
+	if err != nil {
+		return nil, err
+	}
+	claim := `INSERT INTO {table} (name, due_at, holder, claimed_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET due_at = excluded.due_at, holder = excluded.holder, claimed_at = excluded.claimed_at
+		WHERE {table}.due_at < excluded.due_at`
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS " + table + ` (
+		name VARCHAR(255) PRIMARY KEY,
+		due_at BIGINT NOT NULL,
+		holder VARCHAR(255) NOT NULL,
+		claimed_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLCronLocker{
+		db:     db,
+		claim:  dialect.Bind(claim, table),
+		holder: lockHolder(),
+	}, nil
+}
+
+func (sl *SQLCronLocker) Claim(name string, dueAt time.Time) (bool, error) {
+	res, err := sl.db.Exec(sl.claim, name, dueAt.UnixNano(), sl.holder, time.Now().UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
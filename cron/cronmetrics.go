@@ -1,4 +1,107 @@
 package cron
 
+import (
+	"sync"
+	"time"
+)
+
+// Counts for a single job
+type CronJobMetrics struct {
+	Running       int64         `json:"running"`
+	Succeeded     int64         `json:"succeeded"`
+	Failed        int64         `json:"failed"` // Failed runs, including ones that panicked or timed out
+	Panicked      int64         `json:"panicked"`
+	TimedOut      int64         `json:"timedOut"`
+	Skipped       int64         `json:"skipped"`          // Runs skipped because the previous run was still going
+	Elsewhere     int64         `json:"claimedElsewhere"` // Scheduled runs claimed by another server
+	TotalDuration time.Duration `json:"totalDuration"`
+	LastDuration  time.Duration `json:"lastDuration"`
+}
+
+// A snapshot of a CronHub's metrics
+type CronMetricsReport struct {
+	Running   int64                     `json:"running"`
+	Succeeded int64                     `json:"succeeded"`
+	Failed    int64                     `json:"failed"`
+	Panicked  int64                     `json:"panicked"`
+	TimedOut  int64                     `json:"timedOut"`
+	Skipped   int64                     `json:"skipped"`
+	Elsewhere int64                     `json:"claimedElsewhere"`
+	Jobs      map[string]CronJobMetrics `json:"jobs"`
+}
+
+// CronMetrics keeps running counts of the runs a CronHub has made, by job name.
 type CronMetrics struct {
+	sync.Mutex
+	jobs map[string]*CronJobMetrics
+}
+
+func NewCronMetrics() *CronMetrics {
+	return &CronMetrics{
+		jobs: make(map[string]*CronJobMetrics),
+	}
+}
+
+// Applies a change to the counts for a job
+func (cm *CronMetrics) update(name string, fn func(m *CronJobMetrics)) {
+	cm.Lock()
+	defer cm.Unlock()
+	m, ok := cm.jobs[name]
+	if !ok {
+		m = &CronJobMetrics{}
+		cm.jobs[name] = m
+	}
+	fn(m)
+}
+
+func (cm *CronMetrics) started(name string) {
+	cm.update(name, func(m *CronJobMetrics) { m.Running++ })
+}
+
+func (cm *CronMetrics) finished(name string, run CronRun) {
+	cm.update(name, func(m *CronJobMetrics) {
+		m.Running--
+		m.TotalDuration += run.Duration
+		m.LastDuration = run.Duration
+		if run.Error == "" {
+			m.Succeeded++
+			return
+		}
+		m.Failed++
+		if run.Panic != "" {
+			m.Panicked++
+		}
+		if run.TimedOut {
+			m.TimedOut++
+		}
+	})
+}
+
+func (cm *CronMetrics) skipped(name string) {
+	cm.update(name, func(m *CronJobMetrics) { m.Skipped++ })
+}
+
+func (cm *CronMetrics) claimedElsewhere(name string) {
+	cm.update(name, func(m *CronJobMetrics) { m.Elsewhere++ })
+}
+
+// Returns the current counts, totalled and by job
+func (cm *CronMetrics) Snapshot() CronMetricsReport {
+	cm.Lock()
+	defer cm.Unlock()
+	rpt := CronMetricsReport{
+		Jobs: make(map[string]CronJobMetrics),
+	}
+	for k, v := range cm.jobs {
+		m := *v
+		rpt.Jobs[k] = m
+		rpt.Running += m.Running
+		rpt.Succeeded += m.Succeeded
+		rpt.Failed += m.Failed
+		rpt.Panicked += m.Panicked
+		rpt.TimedOut += m.TimedOut
+		rpt.Skipped += m.Skipped
+		rpt.Elsewhere += m.Elsewhere
+	}
+	return rpt
 }
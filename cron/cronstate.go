@@ -0,0 +1,93 @@
+package cron
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+A CronStateStore records when each job last ran on schedule, so that a CronHub can tell which runs were missed while
+the server was down.
+*/
+type CronStateStore interface {
+	LastRun(name string) (time.Time, error) // Returns the zero time if the job has no recorded run
+	SaveLastRun(name string, t time.Time) error
+}
+
+// A CronStateStore that keeps run times in memory, so nothing is caught up after a restart. This is the default.
+type MemoryCronStateStore struct {
+	mu       sync.Mutex
+	lastRuns map[string]time.Time
+}
+
+func NewMemoryCronStateStore() *MemoryCronStateStore {
+	return &MemoryCronStateStore{lastRuns: make(map[string]time.Time)}
+}
+
+func (ms *MemoryCronStateStore) LastRun(name string) (time.Time, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.lastRuns[name], nil
+}
+
+func (ms *MemoryCronStateStore) SaveLastRun(name string, t time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.lastRuns[name] = t
+	return nil
+}
+
+// A CronStateStore that keeps the run times of all jobs in a single JSON file
+type FileCronStateStore struct {
+	mu       sync.Mutex
+	path     string
+	lastRuns map[string]time.Time
+}
+
+// Creates a file state store, loading any run times already saved in the file
+func NewFileCronStateStore(path string) (*FileCronStateStore, error) {
+	fs := &FileCronStateStore{path: path, lastRuns: make(map[string]time.Time)}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &fs.lastRuns)
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileCronStateStore) LastRun(name string) (time.Time, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.lastRuns[name], nil
+}
+
+func (fs *FileCronStateStore) SaveLastRun(name string, t time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.lastRuns[name] = t
+	data, err := json.Marshal(fs.lastRuns)
+	if err != nil {
+		return err
+	}
+	// write and rename, so a crash never leaves a partial file
+	tmp := fs.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fs.path)
+}
@@ -0,0 +1,68 @@
+package dbutils
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The databases supported by Taproot's SQL-backed components, such as work queues, cron locks, and message brokers
+const (
+	SQL_DIALECT_POSTGRES string = "postgres"
+	SQL_DIALECT_SQLITE   string = "sqlite"
+)
+
+var (
+	ErrUnsupportedDriver = errors.New("unsupported database driver")
+	ErrInvalidTableName  = errors.New("invalid table name")
+	tableNamePattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// The differences between the supported databases that every SQL-backed component has to deal with
+type SQLDialect struct {
+	Name     string // SQL_DIALECT_POSTGRES or SQL_DIALECT_SQLITE
+	Numbered bool   // uses $1, $2... rather than ? for parameters
+}
+
+// Returns the dialect for the driver name a database was opened with, such as "postgres" or "sqlite3"
+func DialectFor(driver string) (SQLDialect, error) {
+	switch driver {
+	case "postgres", "pgx":
+		return SQLDialect{Name: SQL_DIALECT_POSTGRES, Numbered: true}, nil
+	case "sqlite3", "sqlite":
+		return SQLDialect{Name: SQL_DIALECT_SQLITE}, nil
+	}
+	return SQLDialect{}, ErrUnsupportedDriver
+}
+
+// Rewrites a query's ? parameters into the dialect's style
+func (d SQLDialect) Rebind(query string) string {
+	if !d.Numbered {
+		return query
+	}
+	sb := strings.Builder{}
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// Fills in a query's {table} placeholders with a table name, and rewrites its parameters into the dialect's style
+func (d SQLDialect) Bind(query string, table string) string {
+	return d.Rebind(strings.ReplaceAll(query, "{table}", table))
+}
+
+// Checks that a table name is safe to build queries with, since it can't be passed as a parameter
+func CheckTableName(table string) error {
+	if !tableNamePattern.MatchString(table) {
+		return ErrInvalidTableName
+	}
+	return nil
+}
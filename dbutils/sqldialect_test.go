@@ -0,0 +1,20 @@
+package dbutils
+
+import "testing"
+
+func TestSQLDialect(t *testing.T) {
+	pg, _ := DialectFor("postgres")
+	if q := pg.Rebind("SELECT a FROM t WHERE b = ? AND c <= ?"); q != "SELECT a FROM t WHERE b = $1 AND c <= $2" {
+		t.Fatalf("unexpected query %s", q)
+	}
+	lite, _ := DialectFor("sqlite3")
+	if q := lite.Bind("SELECT a FROM {table} WHERE b = ?", "work"); q != "SELECT a FROM work WHERE b = ?" {
+		t.Fatalf("unexpected query %s", q)
+	}
+	if _, err := DialectFor("oracle"); err != ErrUnsupportedDriver {
+		t.Fatalf("expected ErrUnsupportedDriver, got %v", err)
+	}
+	if CheckTableName("work; DROP TABLE x") != ErrInvalidTableName || CheckTableName("taproot_work") != nil {
+		t.Fatal("unexpected table name check")
+	}
+}
@@ -9,12 +9,14 @@ require (
 	github.com/felixge/httpsnoop v1.0.3
 	github.com/fsnotify/fsnotify v1.6.0
 	github.com/gobwas/ws v1.1.0
+	github.com/gofrs/flock v0.7.1
 	github.com/google/deck v1.0.0
 	github.com/gorhill/cronexpr v0.0.0-20180427100037-88b0669f7d75
 	github.com/joncrlsn/dque v0.0.0-20211108142734-c2ef48c5192a
 	github.com/jpillora/ipfilter v1.2.9
 	github.com/julienschmidt/httprouter v1.3.1-0.20220603155159-34250257ea14
 	github.com/justinas/alice v1.2.0
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/microcosm-cc/bluemonday v1.0.26
 	github.com/phuslu/iploc v1.0.20230201
 	github.com/spf13/viper v1.15.0
@@ -36,7 +38,6 @@ require (
 	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
-	github.com/gofrs/flock v0.7.1 // indirect
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/gorilla/css v1.0.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
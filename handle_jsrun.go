@@ -5,8 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/dop251/goja"
-	"github.com/dop251/goja_nodejs/console"
-	"github.com/dop251/goja_nodejs/require"
 	"github.com/highgrav/taproot/authn"
 	"github.com/highgrav/taproot/common"
 	"github.com/highgrav/taproot/constants"
@@ -105,6 +103,19 @@ func injectHttpRequest(r *http.Request, vm *goja.Runtime) {
 	vm.Set("request", reqData)
 }
 
+// Injects the functors available to every server-side script, whether it's handling a request or running as work
+func (srv *AppServer) injectScriptFunctors(ctx context.Context, userID string, vm *goja.Runtime) {
+	jsrun.InjectJSDBFunctor(srv.DBs, vm)
+	jsrun.InjectJSWorkFunctor(srv.WorkHub, userID, vm)
+	jsrun.InjectJSWSFunctor(srv.WSHubs, vm)
+	jsrun.InjectJSFnFunctor(&srv.jsfns, vm)
+	addJSUtilFunctor(srv, vm)
+
+	for _, v := range srv.jsinjections {
+		v(ctx, vm)
+	}
+}
+
 // Injects some utility functions into the JS runtime
 func addJSUtilFunctor(svr *AppServer, vm *goja.Runtime) {
 	obj := vm.NewObject()
@@ -149,16 +160,16 @@ func (srv *AppServer) HandleScript(scriptKey string, cachedDuration int, customI
 			}
 		}
 
-		script, err := srv.js.GetScript(scriptKey)
+		_, err := srv.js.GetScript(scriptKey)
 		if err != nil {
 			logging.LogToDeck(r.Context(), "info", "JS", "error", err.Error())
 			w.WriteHeader(http.StatusExpectationFailed)
 			return
 		}
-		vm := goja.New()
-		vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
-		new(require.Registry).Enable(vm)
-		console.Enable(vm)
+		// pooled runtimes already have require() and console enabled, and are reset when they're returned
+		rt := srv.js.Pool.Get()
+		defer srv.js.Pool.Put(rt)
+		vm := rt.VM
 
 		// Pass in the context
 		ctx := r.Context()
@@ -216,12 +227,7 @@ func (srv *AppServer) HandleScript(scriptKey string, cachedDuration int, customI
 		bufwriter := common.NewBufferedHttpResponseWriter(w)
 
 		jsrun.InjectJSHttpFunctor(w, r, bufwriter, vm)
-		jsrun.InjectJSDBFunctor(srv.DBs, vm)
-		addJSUtilFunctor(srv, vm)
-
-		for _, v := range srv.jsinjections {
-			v(ctx, vm)
-		}
+		srv.injectScriptFunctors(ctx, ctxItems["user"].(authn.User).UserID, vm)
 		for _, v := range customInjectors {
 			v(ctx, vm)
 		}
@@ -229,10 +235,18 @@ func (srv *AppServer) HandleScript(scriptKey string, cachedDuration int, customI
 		logging.LogToDeck(r.Context(), "info", "JS", "run", "running "+scriptKey)
 		injectHttpRequest(r, vm)
 		jsrun.InjectJSSysFunctor(vm)
-		_, err = vm.RunProgram(script)
+		_, err = srv.js.Run(r.Context(), rt, scriptKey)
 
 		if err == nil || strings.HasPrefix(err.Error(), jsrun.JS_EXPECTED_INTERRUPT) {
 			logging.LogToDeck(r.Context(), "info", "JS", "done", "completed "+scriptKey)
+		} else if err == jsrun.ErrScriptTimeout {
+			// drop any partial output, so it's neither sent nor cached
+			bufwriter.Close()
+			logging.LogToDeck(r.Context(), "error", "JS", "timeout", "timed out running "+scriptKey)
+			srv.ErrorResponse(w, r, http.StatusServiceUnavailable, err.Error())
+		} else if err == jsrun.ErrScriptCancelled {
+			bufwriter.Close()
+			logging.LogToDeck(r.Context(), "info", "JS", "cancel", "request cancelled while running "+scriptKey)
 		} else if jserr, ok := err.(*goja.Exception); ok {
 			logging.LogToDeck(r.Context(), "error", "JS", "fail", "error running "+scriptKey+": "+jserr.Error())
 			srv.ErrorResponse(w, r, http.StatusInternalServerError, jserr.Error())
@@ -250,7 +264,7 @@ func (srv *AppServer) HandleScript(scriptKey string, cachedDuration int, customI
 
 		if cachedDuration > 0 {
 			// cache result
-			if bufwriter.Code == 200 {
+			if bufwriter.Code == 200 && !bufwriter.IsClosed {
 				srv.PageCache.Put(scriptKey, bufwriter.Result.String(), cachedDuration)
 			}
 		}
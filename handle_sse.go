@@ -2,17 +2,30 @@ package taproot
 
 import (
 	"github.com/highgrav/taproot/authn"
+	"github.com/highgrav/taproot/logging"
 	"github.com/highgrav/taproot/sse"
 	"net/http"
 	"time"
 )
 
+/*
+Chooses the topics a client subscribes to when it connects to an SSE hub. Topics returned by a hook aren't checked with
+Acacia, so the hook must only return topics the user may see; an error refuses the connection with a 403.
+*/
+type SSETopicHook func(r *http.Request, user authn.User) ([]string, error)
+
 /*
 This is a generic middleware for connecting to a Server-Sent Events Hub and handling messages.
 Often you'll need specific logic, so you'd want to write your own handler, but this is a decent starting point for custom code, and can be used for simple prototyping needs.
 Set autoTimeoutMinutes to something reasonably far in the future -- 72 hours or so.
+Clients can subscribe to topics by naming them in "topic" query parameters, as long as Acacia allows it (see CanSubscribe()).
 */
 func (srv *AppServer) HandleSSE(brokerName string, autoTimeoutMinutes int) http.HandlerFunc {
+	return srv.HandleSSEWithTopics(brokerName, autoTimeoutMinutes, nil)
+}
+
+// As HandleSSE(), but also subscribes each client to the topics chosen by a hook
+func (srv *AppServer) HandleSSEWithTopics(brokerName string, autoTimeoutMinutes int, hook SSETopicHook) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if autoTimeoutMinutes < 1 {
 			autoTimeoutMinutes = 525600
@@ -26,14 +39,63 @@ func (srv *AppServer) HandleSSE(brokerName string, autoTimeoutMinutes int) http.
 			srv.ErrorResponse(w, r, 500, "message source not available")
 			return
 		}
+		topics := make([]string, 0)
+		if hook != nil {
+			hookTopics, err := hook(r, user)
+			if err != nil {
+				srv.ErrorResponse(w, r, http.StatusForbidden, err.Error())
+				return
+			}
+			for _, topic := range hookTopics {
+				if sse.CheckTopic(topic, true) != nil {
+					logging.LogToDeck(r.Context(), "error", "SSE", "error", "topic hook for hub "+brokerName+" returned invalid topic '"+topic+"'")
+					srv.ErrorResponse(w, r, 500, "message source not available")
+					return
+				}
+			}
+			topics = append(topics, hookTopics...)
+		}
+		for _, topic := range r.URL.Query()[SSE_TOPIC_QUERY_PARAM] {
+			if sse.CheckTopic(topic, true) != nil {
+				srv.ErrorResponse(w, r, http.StatusBadRequest, "invalid topic '"+topic+"'")
+				return
+			}
+			allowed, err := srv.CanSubscribe(r, brokerName, topic)
+			if err != nil {
+				srv.ErrorResponse(w, r, 500, "failed to apply security policy")
+				return
+			}
+			if !allowed {
+				srv.ErrorResponse(w, r, http.StatusForbidden, "not allowed to subscribe to topic '"+topic+"'")
+				return
+			}
+			topics = append(topics, topic)
+		}
+
 		fl := w.(http.Flusher)
 		w.Header().Set("Content-Type", sse.SSE_MIMETYPE)
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
 
 		ch := make(chan sse.SSEEvent)
-		broker.AddClient(user.UserID, ch)
+		// a reconnecting client is first sent whatever it missed, if the hub keeps a replay log
+		missed, err := broker.AddClientWithTopics(user.UserID, topics, r.Header.Get(sse.SSE_LAST_EVENT_SEEN_HEADER), ch)
+		if err != nil {
+			srv.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
 		defer broker.RemoveClient(user.UserID, ch)
+		for _, msg := range missed {
+			w.Write([]byte(msg.Dispatch()))
+		}
+		fl.Flush()
+		// a heartbeat keeps proxies from closing an idle connection, and finds connections that have gone away
+		var heartbeat <-chan time.Time
+		if d := broker.HeartbeatInterval(); d > 0 {
+			ticker := time.NewTicker(d)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
 		for {
 			select {
 			case <-timer.C:
@@ -42,8 +104,21 @@ func (srv *AppServer) HandleSSE(brokerName string, autoTimeoutMinutes int) http.
 			case <-r.Context().Done():
 				// client's broken the connection
 				return
-			case msg := <-ch:
-				w.Write([]byte(msg.Dispatch()))
+			case <-heartbeat:
+				_, err := w.Write([]byte(string(sse.SSEFIELD_COMMENT) + "heartbeat\n\n"))
+				if err != nil {
+					return
+				}
+				fl.Flush()
+			case msg, ok := <-ch:
+				if !ok {
+					// the hub has disconnected us for falling behind
+					return
+				}
+				_, err := w.Write([]byte(msg.Dispatch()))
+				if err != nil {
+					return
+				}
 				fl.Flush()
 			}
 		}
@@ -14,6 +14,8 @@ type GenerateWSHandler func() websock.IWebSocketHandler
 /*
 HandleWS() is a simple handler for creating and running WS connections. Unlike SSEs, you may want to create your own
 handler. This could be considered a starting point for a more tailored approach.
+Handlers that implement websock.IWebSocketConnHandler are passed the hub and connection once it's added, so they can
+join rooms; the connection leaves its rooms when it closes.
 */
 func (srv *AppServer) HandleWS(brokerName string, createHandler GenerateWSHandler) http.HandlerFunc {
 	if _, ok := srv.WSHubs[brokerName]; !ok {
@@ -55,22 +57,24 @@ func (srv *AppServer) HandleWS(brokerName string, createHandler GenerateWSHandle
 		if sessid == "" && u.UserID == "" {
 			sessid = hub.GenerateNewId(16)
 		}
-		wsc := websock.NewWSConn(sessid, u, conn, rw)
-		srv.WSHubs[brokerName].AddClient(&wsc)
+		wsc := websock.NewWSConnWithOptions(sessid, u, conn, rw, hub.ConnOptions())
+		hub.AddClient(&wsc)
 		logging.LogToDeck(r.Context(), "info", "WS", "info", "opening WS handler")
-		defer srv.WSHubs[brokerName].RemoveClient(&wsc)
+		defer hub.RemoveClient(&wsc)
+		if ch, ok := handler.(websock.IWebSocketConnHandler); ok {
+			ch.Connected(hub, &wsc)
+		}
 
 		for {
 			select {
-			case isDone := <-wsc.CloseChan:
-				if isDone {
-					logging.LogToDeck(r.Context(), "info", "WS", "info", "closing WS handler")
-					return
-				}
+			case <-wsc.CloseChan:
+				logging.LogToDeck(r.Context(), "info", "WS", "info", "closing WS handler")
+				return
 			case inc := <-wsc.Reader:
 				wsReaderChan <- inc
 			case outg := <-wsWriterChan:
-				wsc.Writer <- outg
+				// queued, so a slow client holds up neither the handler nor the hub
+				wsc.Send(outg)
 			}
 		}
 	}
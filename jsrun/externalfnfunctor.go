@@ -6,6 +6,10 @@ import (
 
 type ExternalJSFunction func(...any) *JSCallReturnValue
 
+/*
+InjectJSFnFunctor() injects Go functions registered by the application into a JS runtime, as a top-level object named
+'fns'. Call fns.exec(NAME, ...) to run one; the variadic args are passed to it as exported Go values.
+*/
 func InjectJSFnFunctor(externalFns *map[string]ExternalJSFunction, vm *goja.Runtime) {
 	obj := vm.NewObject()
 
@@ -29,9 +33,11 @@ func InjectJSFnFunctor(externalFns *map[string]ExternalJSFunction, vm *goja.Runt
 			retval.Results = make(map[string]interface{})
 			return retval
 		}
-		return fn(args[1:])
-
-		return retval
+		fnArgs := make([]any, len(args)-1)
+		for i, v := range args[1:] {
+			fnArgs[i] = v.Export()
+		}
+		return fn(fnArgs...)
 	}
 
 	obj.Set("exec", executeFn)
@@ -7,3 +7,14 @@ type JSCallReturnValue struct {
 	ResultDescription string                 `json:"resultDesc"`
 	Results           map[string]interface{} `json:"results"`
 }
+
+// Returns a failed call's value to a script, with a single error
+func errorReturnValue(code int32, err string) *JSCallReturnValue {
+	return &JSCallReturnValue{
+		OK:                false,
+		ResultCode:        code,
+		ResultDescription: "Error (see errors array)",
+		Errors:            []string{err},
+		Results:           make(map[string]interface{}),
+	}
+}
@@ -1,6 +1,12 @@
 package jsrun
 
+import (
+	"sort"
+	"sync"
+)
+
 type JSDependencies struct {
+	mu               sync.RWMutex
 	importingScripts map[string][]string // Maps imported scripts to array of importing scripts
 }
 
@@ -11,15 +17,63 @@ func NewJSDependencies() JSDependencies {
 }
 
 func (jsd *JSDependencies) AddDependency(importedScript, importingScript string) {
+	jsd.mu.Lock()
+	defer jsd.mu.Unlock()
 	if _, ok := jsd.importingScripts[importedScript]; !ok {
 		jsd.importingScripts[importedScript] = make([]string, 0)
 	}
+	for _, v := range jsd.importingScripts[importedScript] {
+		if v == importingScript {
+			return
+		}
+	}
 	jsd.importingScripts[importedScript] = append(jsd.importingScripts[importedScript], importingScript)
 }
 
+// Removes every dependency recorded for an importing script, usually because it is about to be recompiled or was deleted
+func (jsd *JSDependencies) RemoveDependent(importingScript string) {
+	jsd.mu.Lock()
+	defer jsd.mu.Unlock()
+	for imported, importers := range jsd.importingScripts {
+		kept := make([]string, 0, len(importers))
+		for _, v := range importers {
+			if v != importingScript {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			delete(jsd.importingScripts, imported)
+		} else {
+			jsd.importingScripts[imported] = kept
+		}
+	}
+}
+
 func (jsd *JSDependencies) GetDependents(script string) []string {
+	jsd.mu.RLock()
+	defer jsd.mu.RUnlock()
 	if _, ok := jsd.importingScripts[script]; !ok {
 		return []string{}
 	}
-	return jsd.importingScripts[script]
+	return append([]string{}, jsd.importingScripts[script]...)
+}
+
+// Returns every script that imports a script, directly or through other imports, sorted by name
+func (jsd *JSDependencies) GetAllDependents(script string) []string {
+	seen := map[string]bool{script: true}
+	queue := []string{script}
+	res := make([]string, 0)
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		for _, dep := range jsd.GetDependents(curr) {
+			if !seen[dep] {
+				seen[dep] = true
+				res = append(res, dep)
+				queue = append(queue, dep)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
 }
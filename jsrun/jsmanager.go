@@ -5,12 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
 	"github.com/fsnotify/fsnotify"
 	"github.com/highgrav/taproot/logging"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
@@ -24,14 +26,31 @@ type JSManager struct {
 	fileDir          string
 	fileDirs         []string
 	compileMu        sync.Mutex
+	scriptsMu        sync.RWMutex
 	scripts          map[string]string
 	compiledScripts  map[string]*goja.Program
+	modules          map[string]*goja.Program // scripts compiled as modules for require()
 	fnRunOnRecompile JSRunOnFileEventFn
 	fnRunOnDelete    JSRunOnFileEventFn
+	lexicalScripts   map[string]bool // scripts that declare top-level let, const, or class bindings
 	Dependencies     JSDependencies
+	Pool             *JSRuntimePool
+	Metrics          *JSMetrics
+	ScriptTimeout    time.Duration // If greater than zero, scripts are interrupted after running this long
+}
+
+// Limits applied to the runtimes a JSManager runs scripts on. Zero values fall back to the defaults.
+type JSRuntimeLimits struct {
+	PoolSize         int           // Number of pre-warmed runtimes to keep
+	MaxCallStackSize int           // Maximum JS call stack depth
+	ScriptTimeout    time.Duration // Maximum time a single script may run for; zero means no limit
 }
 
 func New(dir string, runOnRecompile, runOnDelete JSRunOnFileEventFn) (*JSManager, error) {
+	return NewWithLimits(dir, runOnRecompile, runOnDelete, JSRuntimeLimits{})
+}
+
+func NewWithLimits(dir string, runOnRecompile, runOnDelete JSRunOnFileEventFn, limits JSRuntimeLimits) (*JSManager, error) {
 	s, err := os.Stat(dir)
 	if err != nil {
 		return nil, err
@@ -41,15 +60,18 @@ func New(dir string, runOnRecompile, runOnDelete JSRunOnFileEventFn) (*JSManager
 	}
 
 	jsm := &JSManager{}
-	jsm.Dependencies = JSDependencies{
-		importingScripts: make(map[string][]string),
-	}
+	jsm.Dependencies = NewJSDependencies()
 	jsm.fnRunOnDelete = runOnDelete
 	jsm.fnRunOnRecompile = runOnRecompile
 	jsm.fileDir = dir
 	jsm.watchDir = make(chan bool)
 	jsm.compiledScripts = make(map[string]*goja.Program)
 	jsm.scripts = make(map[string]string)
+	jsm.lexicalScripts = make(map[string]bool)
+	jsm.modules = make(map[string]*goja.Program)
+	jsm.Pool = NewRuntimePool(limits.PoolSize, limits.MaxCallStackSize)
+	jsm.Metrics = NewJSMetrics()
+	jsm.ScriptTimeout = limits.ScriptTimeout
 	logging.LogToDeck(context.Background(), "info", "JS", "-", "jsmanager compiling scripts")
 	err = jsm.CompileAll()
 	if err != nil {
@@ -57,9 +79,9 @@ func New(dir string, runOnRecompile, runOnDelete JSRunOnFileEventFn) (*JSManager
 		return nil, err
 	}
 	logging.LogToDeck(context.Background(), "info", "JS", "-", "jsmanager done compiling")
-	go jsm.watchDirAndRecompile()
-	if err != nil {
-		return nil, err
+	watcher := jsm.startWatcher()
+	if watcher != nil {
+		go jsm.watchDirAndRecompile(watcher)
 	}
 	logging.LogToDeck(context.Background(), "info", "JS", "-", "jsmanager ready")
 	return jsm, nil
@@ -70,25 +92,82 @@ func (jsm *JSManager) GetScriptText(key string) (string, error) {
 		key = key[:len(key)-2]
 	}
 	fullKey := filepath.Join(jsm.fileDir, key)
+	jsm.scriptsMu.RLock()
 	script, ok := jsm.scripts[fullKey]
+	jsm.scriptsMu.RUnlock()
 	if !ok {
 		err := jsm.CompileOne(fullKey)
 		if err != nil {
 			return "", errors.New("could not locate script '" + key + "': " + err.Error())
 		}
+		jsm.scriptsMu.RLock()
+		script = jsm.scripts[fullKey]
+		jsm.scriptsMu.RUnlock()
 	}
 	return script, nil
 }
 
 func (jsm *JSManager) GetScript(key string) (*goja.Program, error) {
 	fullKey := filepath.Join(jsm.fileDir, key)
+	jsm.scriptsMu.RLock()
 	v, ok := jsm.compiledScripts[fullKey]
+	jsm.scriptsMu.RUnlock()
 	if !ok {
 		return nil, errors.New("Script '" + key + "' not found for path " + fullKey + "!")
 	}
 	return v, nil
 }
 
+/*
+Runs a compiled script on a runtime from the manager's pool, applying ScriptTimeout and cancelling the script if ctx is
+done, and records the run in the manager's metrics. The runtime should have had any per-request values injected into
+it already; it is not returned to the pool.
+*/
+func (jsm *JSManager) Run(ctx context.Context, rt *JSRuntime, key string) (goja.Value, error) {
+	script, err := jsm.GetScript(key)
+	if err != nil {
+		return nil, err
+	}
+	fullKey := filepath.Join(jsm.fileDir, key)
+	jsm.scriptsMu.RLock()
+	lexical := jsm.lexicalScripts[fullKey]
+	jsm.scriptsMu.RUnlock()
+	// top-level lexical bindings can't be removed from a runtime, and would fail when the script is run again
+	if lexical {
+		rt.Discard()
+	}
+	jsm.InjectJSModuleFunctor(fullKey, rt.VM)
+
+	start := time.Now()
+	val, err := rt.Run(ctx, script, jsm.ScriptTimeout)
+	if err != nil && strings.HasPrefix(err.Error(), JS_EXPECTED_INTERRUPT) {
+		jsm.Metrics.Record(key, time.Since(start), nil)
+	} else {
+		jsm.Metrics.Record(key, time.Since(start), err)
+	}
+	return val, err
+}
+
+// Compiles a script, also reporting whether it declares any top-level lexical bindings
+func compileScript(name, src string) (*goja.Program, bool, error) {
+	prg, err := goja.Parse(name, src)
+	if err != nil {
+		return nil, false, err
+	}
+	lexical := false
+	for _, stmt := range prg.Body {
+		switch stmt.(type) {
+		case *ast.LexicalDeclaration, *ast.ClassDeclaration:
+			lexical = true
+		}
+	}
+	comp, err := goja.CompileAST(prg, false)
+	if err != nil {
+		return nil, false, err
+	}
+	return comp, lexical, nil
+}
+
 // Compiles all the scripts under the source directory
 func (jsm *JSManager) CompileAll() error {
 	dirList := []string{jsm.fileDir}
@@ -151,14 +230,13 @@ func (jsm *JSManager) compileDir(dirName string) error {
 			continue
 		}
 
-		comp, err := goja.Compile(filepath.Join(dirName, script), string(src), false)
+		comp, lexical, err := compileScript(filepath.Join(dirName, script), string(src))
 		if err != nil {
 			logging.LogToDeck(context.Background(), "error", "JS", "error", "error compiling JSScript "+script+": "+err.Error())
 			continue
 		}
 		logging.LogToDeck(context.Background(), "info", "JS", "info", fmt.Sprintf("loaded JSScript '%s'\n", script))
-		jsm.compiledScripts[script] = comp
-		jsm.scripts[script] = string(src) // TODO -- make sure this preserves unicode
+		jsm.storeScript(script, string(src), comp, lexical) // TODO -- make sure this preserves unicode
 	}
 	return nil
 }
@@ -171,55 +249,97 @@ func (jsm *JSManager) CompileOne(script string) error {
 		return err
 	}
 
-	comp, err := goja.Compile(filepath.Join(jsm.fileDir, script), string(src), false)
+	comp, lexical, err := compileScript(filepath.Join(jsm.fileDir, script), string(src))
 	if err != nil {
 		logging.LogToDeck(context.Background(), "error", "JS", "error", "error compiling JSScript "+script+": "+err.Error())
 		return err
 	}
 	logging.LogToDeck(context.Background(), "info", "JS", "info", fmt.Sprintf("loaded JSScript '%s'\n", script))
-	jsm.compiledScripts[script] = comp
-	jsm.scripts[script] = string(src)
+	jsm.storeScript(script, string(src), comp, lexical)
 	return nil
 }
 
 func (jsm *JSManager) CompileOneAs(key string, script string) error {
-	comp, err := goja.Compile(key, script, false)
+	comp, lexical, err := compileScript(key, script)
 	if err != nil {
 		logging.LogToDeck(context.Background(), "error", "JS", "error", "error compiling JSScript "+script+": "+err.Error())
 		return err
 	}
 	logging.LogToDeck(context.Background(), "info", "JS", "info", fmt.Sprintf("loaded JSScript '%s'\n", key))
-	jsm.compiledScripts[key] = comp
-	jsm.scripts[key] = string(script)
+	jsm.storeScript(key, script, comp, lexical)
 	return nil
 }
 
-func (jsm *JSManager) watchDirAndRecompile() {
+// Stores a compiled script, dropping any module compiled from its old source and updating its dependencies
+func (jsm *JSManager) storeScript(key, src string, comp *goja.Program, lexical bool) {
+	jsm.scriptsMu.Lock()
+	jsm.compiledScripts[key] = comp
+	jsm.lexicalScripts[key] = lexical
+	jsm.scripts[key] = src
+	delete(jsm.modules, key)
+	jsm.scriptsMu.Unlock()
+	jsm.scanRequires(key, src)
+}
+
+// Removes a script, returning false if it wasn't loaded
+func (jsm *JSManager) removeScript(key string) bool {
+	jsm.scriptsMu.Lock()
+	_, ok := jsm.compiledScripts[key]
+	delete(jsm.compiledScripts, key)
+	delete(jsm.lexicalScripts, key)
+	delete(jsm.scripts, key)
+	delete(jsm.modules, key)
+	jsm.scriptsMu.Unlock()
+	jsm.Dependencies.RemoveDependent(key)
+	return ok
+}
+
+/*
+Recompiles every script that requires a changed or deleted script, directly or through other modules, and runs the
+recompile callback for each of them (which, in the app server, evicts their page cache entries).
+*/
+func (jsm *JSManager) recompileDependents(script string) {
+	for _, dep := range jsm.Dependencies.GetAllDependents(script) {
+		logging.LogToDeck(context.Background(), "info", "JS", "info", "recompiling "+dep+", which depends on "+script)
+		err := jsm.CompileOne(dep)
+		if err != nil {
+			logging.LogToDeck(context.Background(), "error", "JS", "error", "error when recompiling "+dep+": "+err.Error())
+		}
+		jsm.fnRunOnRecompile(dep)
+	}
+}
+
+// Sets up a watcher on the script directories. This happens before New() returns, so that no changes are missed.
+func (jsm *JSManager) startWatcher() *fsnotify.Watcher {
 	dirList := []string{jsm.fileDir}
 
 	// populate with initial subdirectories
 	subdirs, err := jsm.getDirs(jsm.fileDir)
 	if err != nil {
 		logging.LogToDeck(context.Background(), "error", "JS", "error", "JS file watcher could not be started: "+err.Error())
-		return
+		return nil
 	}
 	dirList = append(dirList, subdirs...)
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		logging.LogToDeck(context.Background(), "error", "JS", "error", "error creating JS filewatcher: "+err.Error())
-		return
+		return nil
 	}
-	defer watcher.Close()
 	for _, v := range dirList {
 		err = watcher.Add(v)
 		if err != nil {
 			logging.LogToDeck(context.Background(), "error", "JS", "error", "error watching directory "+v+": "+err.Error())
-			return
+			watcher.Close()
+			return nil
 		}
 	}
 	logging.LogToDeck(context.Background(), "info", "JS", "info", "watching script file directories")
+	return watcher
+}
 
+func (jsm *JSManager) watchDirAndRecompile(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
 	for {
 		select {
 		case exitFlag := <-jsm.watchDir:
@@ -238,6 +358,7 @@ func (jsm *JSManager) watchDirAndRecompile() {
 						logging.LogToDeck(context.Background(), "error", "JS", "error", "error when recompiling "+event.Name+": "+err.Error())
 					}
 					jsm.fnRunOnRecompile(event.Name)
+					jsm.recompileDependents(event.Name)
 				}
 			}
 
@@ -264,6 +385,7 @@ func (jsm *JSManager) watchDirAndRecompile() {
 							logging.LogToDeck(context.Background(), "error", "JS", "error", "error when recompiling "+event.Name+": "+err.Error())
 						}
 						jsm.fnRunOnRecompile(event.Name)
+						jsm.recompileDependents(event.Name)
 					}
 				}
 			}
@@ -273,13 +395,11 @@ func (jsm *JSManager) watchDirAndRecompile() {
 				// Try to remove watcher from directory
 				watcher.Remove(event.Name)
 				// Try to remove the filename from cache
-				_, ok := jsm.compiledScripts[event.Name]
-				if ok {
-					delete(jsm.compiledScripts, event.Name)
-					delete(jsm.scripts, event.Name)
+				if jsm.removeScript(event.Name) {
 					logging.LogToDeck(context.Background(), "info", "JS", "info", "removed compiled script "+event.Name)
 					jsm.fnRunOnDelete(event.Name)
 				}
+				jsm.recompileDependents(event.Name)
 
 			}
 			if event.Op&fsnotify.Rename == fsnotify.Rename {
@@ -287,13 +407,11 @@ func (jsm *JSManager) watchDirAndRecompile() {
 				// Try to remove watcher from directory
 				watcher.Remove(event.Name)
 				// Try to remove the filename from cache
-				_, ok := jsm.compiledScripts[event.Name]
-				if ok {
-					delete(jsm.compiledScripts, event.Name)
-					delete(jsm.scripts, event.Name)
+				if jsm.removeScript(event.Name) {
 					logging.LogToDeck(context.Background(), "info", "JS", "info", "removed compiled script "+event.Name)
 					jsm.fnRunOnDelete(event.Name)
 				}
+				jsm.recompileDependents(event.Name)
 				// A rename fires off a create event also, so it'll handle
 				// watcher/compilation in that block
 			}
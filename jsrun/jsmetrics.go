@@ -0,0 +1,113 @@
+package jsrun
+
+import (
+	"github.com/highgrav/taproot/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+const JS_METRICS_WINDOW_SIZE int = 1000
+
+type scriptStats struct {
+	calls     int64
+	errors    int64
+	timeouts  int64
+	totalTime time.Duration
+	maxTime   time.Duration
+	lastRun   time.Time
+	window    *common.StatWindow
+}
+
+// A snapshot of the call and latency metrics for a single script
+type ScriptMetrics struct {
+	Script       string             `json:"script"`
+	Calls        int64              `json:"calls"`
+	Errors       int64              `json:"errors"`
+	Timeouts     int64              `json:"timeouts"`
+	TotalMicros  int64              `json:"totalMicros"`
+	AvgMicros    int64              `json:"avgMicros"`
+	MaxMicros    int64              `json:"maxMicros"`
+	LastRun      time.Time          `json:"lastRun"`
+	LatencyStats *common.StatWindow `json:"-"`
+}
+
+// JSMetrics tracks calls, errors, timeouts, and latency for each script run by a JSManager.
+type JSMetrics struct {
+	sync.RWMutex
+	scripts map[string]*scriptStats
+}
+
+func NewJSMetrics() *JSMetrics {
+	return &JSMetrics{
+		scripts: make(map[string]*scriptStats),
+	}
+}
+
+// Records a single run of a script
+func (m *JSMetrics) Record(script string, elapsed time.Duration, err error) {
+	m.Lock()
+	st, ok := m.scripts[script]
+	if !ok {
+		st = &scriptStats{
+			window: common.NewStatWindow(script, JS_METRICS_WINDOW_SIZE),
+		}
+		m.scripts[script] = st
+	}
+	st.calls++
+	if err != nil {
+		st.errors++
+		if err == ErrScriptTimeout {
+			st.timeouts++
+		}
+	}
+	st.totalTime += elapsed
+	if elapsed > st.maxTime {
+		st.maxTime = elapsed
+	}
+	st.lastRun = time.Now()
+	m.Unlock()
+	st.window.Add(elapsed)
+}
+
+func (st *scriptStats) snapshot(script string) ScriptMetrics {
+	sm := ScriptMetrics{
+		Script:       script,
+		Calls:        st.calls,
+		Errors:       st.errors,
+		Timeouts:     st.timeouts,
+		TotalMicros:  st.totalTime.Microseconds(),
+		MaxMicros:    st.maxTime.Microseconds(),
+		LastRun:      st.lastRun,
+		LatencyStats: st.window,
+	}
+	if st.calls > 0 {
+		sm.AvgMicros = sm.TotalMicros / st.calls
+	}
+	return sm
+}
+
+// Returns the metrics for every script that has been run, sorted by script name
+func (m *JSMetrics) All() []ScriptMetrics {
+	m.RLock()
+	defer m.RUnlock()
+	res := make([]ScriptMetrics, 0, len(m.scripts))
+	for k, v := range m.scripts {
+		res = append(res, v.snapshot(k))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Script < res[j].Script
+	})
+	return res
+}
+
+// Returns the metrics for a single script
+func (m *JSMetrics) Get(script string) (ScriptMetrics, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	st, ok := m.scripts[script]
+	if !ok {
+		return ScriptMetrics{}, false
+	}
+	return st.snapshot(script), true
+}
@@ -1,3 +1,170 @@
 package jsrun
 
+import (
+	"errors"
+	"github.com/dop251/goja"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
 // Extends SSJS with the ability to reference external modules
+
+var (
+	ErrModuleNotFound = errors.New("module not found")
+	requirePattern    = regexp.MustCompile(`\brequire\(\s*['"]([^'"]+)['"]\s*\)`)
+)
+
+/*
+Resolves a module name to a script file. Names starting with './' or '../' are resolved relative to the requiring
+script's directory, and anything else relative to the script directory. A name may leave off the '.js' extension,
+or name a directory containing an 'index.js'. Modules outside of the script directory can't be loaded.
+*/
+func (jsm *JSManager) resolveModule(from, name string) (string, error) {
+	var base string
+	if strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") {
+		base = filepath.Join(filepath.Dir(from), name)
+	} else {
+		base = filepath.Join(jsm.fileDir, name)
+	}
+	rel, err := filepath.Rel(jsm.fileDir, base)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrModuleNotFound
+	}
+
+	candidates := []string{base + ".js", filepath.Join(base, "index.js")}
+	if strings.HasSuffix(base, ".js") {
+		candidates = append([]string{base}, candidates...)
+	}
+	for _, c := range candidates {
+		fi, err := os.Stat(c)
+		if err == nil && !fi.IsDir() {
+			return c, nil
+		}
+	}
+	return "", ErrModuleNotFound
+}
+
+// Gets the compiled wrapper for a module, compiling it the first time it's needed
+func (jsm *JSManager) getModule(path string) (*goja.Program, error) {
+	jsm.scriptsMu.RLock()
+	prg, ok := jsm.modules[path]
+	jsm.scriptsMu.RUnlock()
+	if ok {
+		return prg, nil
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	prg, err = goja.Compile(path, "(function(exports, require, module, __filename, __dirname) {"+string(src)+"\n})", false)
+	if err != nil {
+		return nil, err
+	}
+	jsm.scriptsMu.Lock()
+	jsm.modules[path] = prg
+	jsm.scriptsMu.Unlock()
+	return prg, nil
+}
+
+// Records the modules a script requires by name, so that the script can be recompiled when one of them changes
+func (jsm *JSManager) scanRequires(script, src string) {
+	jsm.Dependencies.RemoveDependent(script)
+	for _, m := range requirePattern.FindAllStringSubmatch(src, -1) {
+		path, err := jsm.resolveModule(script, m[1])
+		if err == nil {
+			jsm.Dependencies.AddDependency(path, script)
+		}
+	}
+}
+
+/*
+Loads modules for a single script run. Each module is compiled once by the JSManager, but is evaluated at most once
+per run, so module state never leaks between requests and a run always sees the latest version of a module.
+*/
+type moduleLoader struct {
+	jsm     *JSManager
+	vm      *goja.Runtime
+	native  goja.Value
+	modules map[string]*goja.Object
+}
+
+/*
+Injects a require() function into a runtime for a script. Module names that don't resolve to a script are passed on to
+the runtime's built-in require(), so native modules such as 'util' keep working.
+*/
+func (jsm *JSManager) InjectJSModuleFunctor(script string, vm *goja.Runtime) {
+	ml := &moduleLoader{
+		jsm:     jsm,
+		vm:      vm,
+		native:  vm.Get("require"),
+		modules: make(map[string]*goja.Object),
+	}
+	vm.Set("require", ml.requireFrom(script))
+}
+
+// Returns a require() function that resolves modules relative to a script
+func (ml *moduleLoader) requireFrom(from string) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		name := call.Argument(0).String()
+		path, err := ml.jsm.resolveModule(from, name)
+		if err != nil {
+			if fn, ok := goja.AssertFunction(ml.native); ok {
+				val, err := fn(goja.Undefined(), call.Arguments...)
+				if err != nil {
+					panic(err)
+				}
+				return val
+			}
+			panic(ml.vm.NewGoError(errors.New("cannot find module '" + name + "'")))
+		}
+		ml.jsm.Dependencies.AddDependency(path, from)
+
+		module, ok := ml.modules[path]
+		if ok {
+			return module.Get("exports")
+		}
+		module, err = ml.load(path)
+		if err != nil {
+			switch err.(type) {
+			case *goja.Exception, *goja.InterruptedError:
+				// rethrown as-is, so a script can't catch its own timeout
+				panic(err)
+			}
+			panic(ml.vm.NewGoError(err))
+		}
+		return module.Get("exports")
+	}
+}
+
+// Evaluates a module, caching it before it runs so that circular requires get its partial exports, as in Node
+func (ml *moduleLoader) load(path string) (*goja.Object, error) {
+	prg, err := ml.jsm.getModule(path)
+	if err != nil {
+		return nil, err
+	}
+	exports := ml.vm.NewObject()
+	module := ml.vm.NewObject()
+	module.Set("exports", exports)
+	module.Set("id", path)
+	ml.modules[path] = module
+
+	wrapper, err := ml.vm.RunProgram(prg)
+	if err != nil {
+		delete(ml.modules, path)
+		return nil, err
+	}
+	fn, ok := goja.AssertFunction(wrapper)
+	if !ok {
+		delete(ml.modules, path)
+		return nil, errors.New("module '" + path + "' did not compile to a function")
+	}
+	_, err = fn(exports, exports, ml.vm.ToValue(ml.requireFrom(path)), module, ml.vm.ToValue(path), ml.vm.ToValue(filepath.Dir(path)))
+	if err != nil {
+		delete(ml.modules, path)
+		return nil, err
+	}
+	return module, nil
+}
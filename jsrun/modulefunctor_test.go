@@ -0,0 +1,109 @@
+package jsrun
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, dir, name, src string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name), []byte(src), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runScript(t *testing.T, jsm *JSManager, key string) string {
+	t.Helper()
+	rt := jsm.Pool.Get()
+	defer jsm.Pool.Put(rt)
+	v, err := jsm.Run(context.Background(), rt, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.String()
+}
+
+func TestRequire(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "lib/greet.js", "var fmt = require('./fmt'); var calls = 0; exports.hello = function(n) { calls++; return fmt.wrap('hello ' + n) + calls; };")
+	writeScript(t, dir, "lib/fmt.js", "module.exports = { wrap: function(s) { return '[' + s + ']'; } };")
+	writeScript(t, dir, "lib/a.js", "exports.loaded = false; var b = require('./b'); exports.loaded = true; exports.sawB = b.sawA;")
+	writeScript(t, dir, "lib/b.js", "exports.sawA = require('./a').loaded;")
+	writeScript(t, dir, "page.js", "var g = require('lib/greet'); g.hello('a') + g.hello('b') + require('lib/greet.js').hello('c');")
+	writeScript(t, dir, "cycle.js", "var a = require('./lib/a'); a.loaded + ',' + a.sawB;")
+	writeScript(t, dir, "native.js", "require('util').format('%s!', 'hi');")
+	writeScript(t, dir, "escape.js", "try { require('../outside'); 'loaded' } catch (e) { 'blocked' }")
+
+	jsm, err := NewWithLimits(dir, func(string) {}, func(string) {}, JSRuntimeLimits{PoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// module state is shared within a run, but not between runs
+	for i := 0; i < 2; i++ {
+		if res := runScript(t, jsm, "page.js"); res != "[hello a]1[hello b]2[hello c]3" {
+			t.Fatalf("unexpected result %s", res)
+		}
+	}
+	if res := runScript(t, jsm, "cycle.js"); res != "true,false" {
+		t.Fatalf("unexpected result for a circular require: %s", res)
+	}
+	if res := runScript(t, jsm, "native.js"); res != "hi!" {
+		t.Fatalf("unexpected result for a native module: %s", res)
+	}
+	if res := runScript(t, jsm, "escape.js"); res != "blocked" {
+		t.Fatal("loaded a module outside of the script directory")
+	}
+
+	deps := jsm.Dependencies.GetAllDependents(filepath.Join(dir, "lib/fmt.js"))
+	if len(deps) != 2 || deps[0] != filepath.Join(dir, "lib/greet.js") || deps[1] != filepath.Join(dir, "page.js") {
+		t.Fatalf("unexpected dependents %v", deps)
+	}
+}
+
+func TestRequireRecompilesDependents(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "lib/fmt.js", "exports.wrap = function(s) { return '[' + s + ']'; };")
+	writeScript(t, dir, "page.js", "require('./lib/fmt').wrap('x');")
+
+	var mu sync.Mutex
+	recompiled := make(map[string]bool)
+	onRecompile := func(name string) {
+		mu.Lock()
+		recompiled[name] = true
+		mu.Unlock()
+	}
+	jsm, err := NewWithLimits(dir, onRecompile, func(string) {}, JSRuntimeLimits{PoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := runScript(t, jsm, "page.js"); res != "[x]" {
+		t.Fatalf("unexpected result %s", res)
+	}
+
+	writeScript(t, dir, "lib/fmt.js", "exports.wrap = function(s) { return '<' + s + '>'; };")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := recompiled[filepath.Join(dir, "page.js")]
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dependent script was not recompiled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if res := runScript(t, jsm, "page.js"); res != "<x>" {
+		t.Fatalf("changed module was not reloaded: %s", res)
+	}
+}
@@ -0,0 +1,240 @@
+package jsrun
+
+import (
+	"context"
+	"errors"
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/console"
+	"github.com/dop251/goja_nodejs/require"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	JS_DEFAULT_POOL_SIZE        int = 16
+	JS_DEFAULT_MAX_RUNTIME_USES int = 1000
+)
+
+var (
+	ErrScriptTimeout   = errors.New("script exceeded its time limit")
+	ErrScriptCancelled = errors.New("script cancelled")
+)
+
+/*
+A goja runtime checked out from a JSRuntimePool. The VM comes with require() and console already enabled; anything else
+a script needs should be injected into it before running, and is removed again when the runtime is returned to the pool.
+*/
+type JSRuntime struct {
+	VM          *goja.Runtime
+	globalNames goja.Callable
+	baseline    map[string]goja.Value
+	uses        int
+	reusable    bool
+}
+
+// Marks a runtime so that it is thrown away rather than returned to the pool
+func (rt *JSRuntime) Discard() {
+	rt.reusable = false
+}
+
+/*
+Runs a program, interrupting it when the timeout elapses (if the timeout is greater than zero) or when the context is
+done. A timed-out script returns ErrScriptTimeout and a cancelled one returns ErrScriptCancelled; scripts that call
+system.exit() return an interrupt error prefixed with JS_EXPECTED_INTERRUPT, as before.
+*/
+func (rt *JSRuntime) Run(ctx context.Context, script *goja.Program, timeout time.Duration) (goja.Value, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				rt.VM.Interrupt(ErrScriptTimeout)
+			} else {
+				rt.VM.Interrupt(ErrScriptCancelled)
+			}
+		}
+	}()
+
+	val, err := rt.VM.RunProgram(script)
+	close(done)
+	// the watcher must be finished before anything can clear the interrupt flag
+	wg.Wait()
+
+	if ierr, ok := err.(*goja.InterruptedError); ok {
+		if e, ok := ierr.Value().(error); ok && (e == ErrScriptTimeout || e == ErrScriptCancelled) {
+			// an interrupted runtime may have been stopped halfway through changing shared state
+			rt.Discard()
+			return nil, e
+		}
+	}
+	return val, err
+}
+
+// Resets a runtime's globals to the state they were in when the runtime was created
+func (rt *JSRuntime) reset() bool {
+	rt.VM.ClearInterrupt()
+	names, err := rt.globalNames(goja.Undefined())
+	if err != nil {
+		return false
+	}
+	var current []string
+	err = rt.VM.ExportTo(names, &current)
+	if err != nil {
+		return false
+	}
+
+	global := rt.VM.GlobalObject()
+	for _, name := range current {
+		orig, ok := rt.baseline[name]
+		if !ok {
+			// top-level vars and functions can't be deleted, so we at least clear them
+			if global.Delete(name) != nil {
+				global.Set(name, goja.Undefined())
+			}
+			continue
+		}
+		if !global.Get(name).SameAs(orig) {
+			global.Set(name, orig)
+		}
+	}
+	for name, orig := range rt.baseline {
+		if global.Get(name) == nil {
+			global.Set(name, orig)
+		}
+	}
+	return true
+}
+
+/*
+JSRuntimePool keeps a set of pre-warmed goja runtimes, so that requests don't pay the cost of creating a runtime and
+enabling require() and console every time a script is run. Runtimes are reset when they are returned, and are retired
+after a number of uses, or if the script they ran left state behind that can't be reset.
+*/
+type JSRuntimePool struct {
+	runtimes         chan *JSRuntime
+	maxCallStackSize int
+	maxUses          int
+	created          atomic.Int64
+	reused           atomic.Int64
+	retired          atomic.Int64
+}
+
+// Statistics about a pool's use
+type JSRuntimePoolStats struct {
+	Size      int   `json:"size"`
+	Available int   `json:"available"`
+	Created   int64 `json:"created"`
+	Reused    int64 `json:"reused"`
+	Retired   int64 `json:"retired"`
+}
+
+// Creates a new pool holding up to size runtimes. A maxCallStackSize of zero leaves goja's default in place.
+func NewRuntimePool(size int, maxCallStackSize int) *JSRuntimePool {
+	if size <= 0 {
+		size = JS_DEFAULT_POOL_SIZE
+	}
+	pool := &JSRuntimePool{
+		runtimes:         make(chan *JSRuntime, size),
+		maxCallStackSize: maxCallStackSize,
+		maxUses:          JS_DEFAULT_MAX_RUNTIME_USES,
+	}
+	for i := 0; i < size; i++ {
+		pool.runtimes <- pool.newRuntime()
+	}
+	return pool
+}
+
+func (pool *JSRuntimePool) newRuntime() *JSRuntime {
+	vm := goja.New()
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+	if pool.maxCallStackSize > 0 {
+		vm.SetMaxCallStackSize(pool.maxCallStackSize)
+	}
+	new(require.Registry).Enable(vm)
+	console.Enable(vm)
+
+	// Held outside of the global scope, so that scripts can't replace it
+	fn, err := vm.RunString("(function(g, o) { return function() { return g(o); }; })(Object.getOwnPropertyNames, globalThis)")
+	if err != nil {
+		panic(err)
+	}
+	names, _ := goja.AssertFunction(fn)
+
+	rt := &JSRuntime{
+		VM:          vm,
+		globalNames: names,
+		baseline:    make(map[string]goja.Value),
+		reusable:    true,
+	}
+	res, err := names(goja.Undefined())
+	if err != nil {
+		panic(err)
+	}
+	var all []string
+	err = vm.ExportTo(res, &all)
+	if err != nil {
+		panic(err)
+	}
+	global := vm.GlobalObject()
+	for _, name := range all {
+		rt.baseline[name] = global.Get(name)
+	}
+	pool.created.Add(1)
+	return rt
+}
+
+// Gets a runtime from the pool, creating a new one if the pool is empty
+func (pool *JSRuntimePool) Get() *JSRuntime {
+	select {
+	case rt := <-pool.runtimes:
+		if rt.uses > 0 {
+			pool.reused.Add(1)
+		}
+		rt.uses++
+		return rt
+	default:
+		rt := pool.newRuntime()
+		rt.uses++
+		return rt
+	}
+}
+
+// Returns a runtime to the pool. Runtimes that can't be reused are dropped and replaced with a fresh one.
+func (pool *JSRuntimePool) Put(rt *JSRuntime) {
+	if rt == nil {
+		return
+	}
+	if !rt.reusable || rt.uses >= pool.maxUses || !rt.reset() {
+		pool.retired.Add(1)
+		if len(pool.runtimes) >= cap(pool.runtimes) {
+			return
+		}
+		rt = pool.newRuntime()
+	}
+	select {
+	case pool.runtimes <- rt:
+	default:
+		// the pool is already full
+	}
+}
+
+func (pool *JSRuntimePool) Stats() JSRuntimePoolStats {
+	return JSRuntimePoolStats{
+		Size:      cap(pool.runtimes),
+		Available: len(pool.runtimes),
+		Created:   pool.created.Load(),
+		Reused:    pool.reused.Load(),
+		Retired:   pool.retired.Load(),
+	}
+}
@@ -0,0 +1,90 @@
+package jsrun
+
+import (
+	"context"
+	"github.com/dop251/goja"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRuntimeTimeout(t *testing.T) {
+	pool := NewRuntimePool(1, 0)
+	rt := pool.Get()
+	start := time.Now()
+	_, err := rt.Run(context.Background(), goja.MustCompile("loop", "while(true) {}", false), 50*time.Millisecond)
+	if err != ErrScriptTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("script was not interrupted promptly")
+	}
+	pool.Put(rt)
+	if pool.Stats().Retired != 1 {
+		t.Fatal("an interrupted runtime should be retired")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rt = pool.Get()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err = rt.Run(ctx, goja.MustCompile("loop", "while(true) {}", false), 0)
+	if err != ErrScriptCancelled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	pool.Put(rt)
+}
+
+func TestRuntimeReset(t *testing.T) {
+	pool := NewRuntimePool(1, 0)
+	rt := pool.Get()
+	rt.VM.Set("secret", "user-1")
+	_, err := rt.Run(context.Background(), goja.MustCompile("a", "var leaked = secret; function f() {}; JSON = null;", false), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(rt)
+
+	rt2 := pool.Get()
+	if rt2 != rt {
+		t.Fatal("expected the runtime to be reused")
+	}
+	v, err := rt2.Run(context.Background(), goja.MustCompile("b", "[typeof secret, typeof leaked, typeof f, typeof JSON.stringify].join(',')", false), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "undefined,undefined,undefined,function" {
+		t.Fatalf("runtime state was not reset: %s", v.String())
+	}
+	pool.Put(rt2)
+}
+
+func TestManagerRunLexicalScript(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "let.js"), []byte("let x = 1; const y = 2; x + y"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsm, err := NewWithLimits(dir, func(string) {}, func(string) {}, JSRuntimeLimits{PoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		rt := jsm.Pool.Get()
+		v, err := jsm.Run(context.Background(), rt, "let.js")
+		jsm.Pool.Put(rt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.ToInteger() != 3 {
+			t.Fatalf("unexpected result %v", v)
+		}
+	}
+	st, ok := jsm.Metrics.Get("let.js")
+	if !ok || st.Calls != 3 || st.Errors != 0 {
+		t.Fatalf("unexpected metrics: %+v", st)
+	}
+}
@@ -0,0 +1,122 @@
+package jsrun
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"github.com/dop251/goja"
+	"github.com/highgrav/taproot/workers"
+)
+
+func init() {
+	// work data from scripts is made of these, and work requests are gob-encoded by the queue
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// Converts a Go value to plain JSON-style data, so that scripts see strings for times rather than wrapped Go objects
+func toJSData(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var res any
+	err = json.Unmarshal(data, &res)
+	return res, err
+}
+
+/*
+InjectJSWorkFunctor() injects functions to start and look up background work into a JS runtime, as a top-level object
+named 'work'.
+
+Call work.enqueue(TYPE, DATA) to start work; the work is started on behalf of userID (if any), and its ID is returned
+in the results. Call work.status(ID) with a work ID to get the work's status, or
+work.list({type: ..., state: ..., userId: ..., limit: ...}) to list statuses, newest first.
+*/
+func InjectJSWorkFunctor(wq *workers.WorkQueue, userID string, vm *goja.Runtime) {
+	obj := vm.NewObject()
+
+	enqueue := func(workType string, data goja.Value) *JSCallReturnValue {
+		if wq == nil {
+			return errorReturnValue(-9701, "work hub is not initialized")
+		}
+		if workType == "" {
+			return errorReturnValue(-9704, "First argument must be a work type")
+		}
+		var d any
+		if data != nil {
+			d = data.Export()
+		}
+		wr := workers.NewWorkRequest(workType, d)
+		wr.UserID = userID
+		err := wq.Enqueue(wr)
+		if err != nil {
+			return errorReturnValue(-9702, err.Error())
+		}
+		return &JSCallReturnValue{
+			OK:         true,
+			ResultCode: 200,
+			Results:    map[string]interface{}{"id": wr.ID},
+		}
+	}
+
+	status := func(id string) *JSCallReturnValue {
+		if wq == nil {
+			return errorReturnValue(-9701, "work hub is not initialized")
+		}
+		js, err := wq.JobStatus(id)
+		if err == workers.ErrJobNotFound {
+			return errorReturnValue(404, "work '"+id+"' does not exist")
+		} else if err != nil {
+			return errorReturnValue(-9702, err.Error())
+		}
+		data, err := toJSData(js)
+		if err != nil {
+			return errorReturnValue(-9703, err.Error())
+		}
+		return &JSCallReturnValue{
+			OK:         true,
+			ResultCode: 200,
+			Results:    map[string]interface{}{"status": data},
+		}
+	}
+
+	list := func(filter map[string]any) *JSCallReturnValue {
+		if wq == nil {
+			return errorReturnValue(-9701, "work hub is not initialized")
+		}
+		f := workers.JobFilter{}
+		if v, ok := filter["type"].(string); ok {
+			f.Type = v
+		}
+		if v, ok := filter["state"].(string); ok {
+			f.State = workers.JobState(v)
+		}
+		if v, ok := filter["userId"].(string); ok {
+			f.UserID = v
+		}
+		switch v := filter["limit"].(type) {
+		case int64:
+			f.Limit = int(v)
+		case float64:
+			f.Limit = int(v)
+		}
+		jobs, err := wq.Jobs(f)
+		if err != nil {
+			return errorReturnValue(-9702, err.Error())
+		}
+		data, err := toJSData(jobs)
+		if err != nil {
+			return errorReturnValue(-9703, err.Error())
+		}
+		return &JSCallReturnValue{
+			OK:         true,
+			ResultCode: 200,
+			Results:    map[string]interface{}{"jobs": data},
+		}
+	}
+
+	obj.Set("enqueue", enqueue)
+	obj.Set("status", status)
+	obj.Set("list", list)
+	vm.Set("work", obj)
+}
@@ -0,0 +1,56 @@
+package jsrun
+
+import (
+	"github.com/dop251/goja"
+	"github.com/highgrav/taproot/workers"
+	"testing"
+	"time"
+)
+
+func TestWorkFunctor(t *testing.T) {
+	wq, err := workers.New("jswork", t.TempDir(), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wq.Close()
+	go func() {
+		for range wq.Status {
+		}
+	}()
+	seen := make(chan *workers.WorkRequest, 1)
+	wq.AddWorkFunc("greet", func(msg *workers.WorkRequest) workers.WorkStatusReport {
+		seen <- msg
+		return workers.WorkStatusReport{Type: msg.Type, ID: msg.ID}
+	})
+
+	vm := goja.New()
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+	InjectJSWorkFunctor(wq, "user-1", vm)
+	val, err := vm.RunString(`work.enqueue("greet", {name: "ann", tags: ["a"]})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := val.Export().(*JSCallReturnValue)
+	if !res.OK {
+		t.Fatalf("enqueue failed: %v", res.Errors)
+	}
+	id := res.Results["id"].(string)
+
+	select {
+	case msg := <-seen:
+		data := msg.Data.(map[string]interface{})
+		if msg.ID != id || msg.UserID != "user-1" || data["name"] != "ann" {
+			t.Fatalf("unexpected request %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("enqueued work did not run")
+	}
+
+	val, err = vm.RunString(`work.enqueue("")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Export().(*JSCallReturnValue).OK {
+		t.Fatal("enqueue without a type should fail")
+	}
+}
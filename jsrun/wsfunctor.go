@@ -0,0 +1,158 @@
+package jsrun
+
+import (
+	"encoding/json"
+	"github.com/dop251/goja"
+	"github.com/gobwas/ws"
+	"github.com/highgrav/taproot/websock"
+)
+
+// Turns a value from a script into a text frame: strings are sent as they are, and anything else as JSON
+func toWSFrame(data goja.Value) (websock.WSFrame, error) {
+	if data == nil || goja.IsUndefined(data) {
+		return websock.WSFrame{Op: ws.OpText}, nil
+	}
+	if s, ok := data.Export().(string); ok {
+		return websock.WSFrame{Op: ws.OpText, Data: []byte(s)}, nil
+	}
+	b, err := json.Marshal(data.Export())
+	if err != nil {
+		return websock.WSFrame{}, err
+	}
+	return websock.WSFrame{Op: ws.OpText, Data: b}, nil
+}
+
+/*
+InjectJSWSFunctor() injects functions to write to WebSocket hubs, and manage their rooms, into a JS runtime, as a
+top-level object named 'ws'. Every function takes the hub's name first.
+
+Call ws.send(HUB, KEY, DATA) to write to a client's connections, ws.sendRoom(HUB, ROOM, DATA) to write to a room, or
+ws.sendAll(HUB, DATA) to write to the whole hub; strings are sent as they are, anything else as JSON. Call
+ws.join(HUB, ROOM, KEY) and ws.leave(HUB, ROOM, KEY) to add a client's connections to a room or remove them, and
+ws.rooms(HUB) and ws.presence(HUB, ROOM) to list rooms and the clients in one.
+*/
+func InjectJSWSFunctor(hubs map[string]*websock.WSHub, vm *goja.Runtime) {
+	obj := vm.NewObject()
+
+	getHub := func(name string) (*websock.WSHub, *JSCallReturnValue) {
+		hub, ok := hubs[name]
+		if !ok {
+			return nil, errorReturnValue(-9801, "websocket hub '"+name+"' does not exist")
+		}
+		return hub, nil
+	}
+	sent := func() *JSCallReturnValue {
+		return &JSCallReturnValue{
+			OK:         true,
+			ResultCode: 200,
+			Results:    make(map[string]interface{}),
+		}
+	}
+
+	send := func(hubName string, key string, data goja.Value) *JSCallReturnValue {
+		hub, errVal := getHub(hubName)
+		if errVal != nil {
+			return errVal
+		}
+		if key == "" {
+			return errorReturnValue(-9804, "Second argument must be a client key")
+		}
+		frame, err := toWSFrame(data)
+		if err != nil {
+			return errorReturnValue(-9803, err.Error())
+		}
+		hub.WriteOne(key, frame)
+		return sent()
+	}
+
+	sendRoom := func(hubName string, room string, data goja.Value) *JSCallReturnValue {
+		hub, errVal := getHub(hubName)
+		if errVal != nil {
+			return errVal
+		}
+		if room == "" {
+			return errorReturnValue(-9804, "Second argument must be a room name")
+		}
+		frame, err := toWSFrame(data)
+		if err != nil {
+			return errorReturnValue(-9803, err.Error())
+		}
+		hub.WriteRoom(room, frame)
+		return sent()
+	}
+
+	sendAll := func(hubName string, data goja.Value) *JSCallReturnValue {
+		hub, errVal := getHub(hubName)
+		if errVal != nil {
+			return errVal
+		}
+		frame, err := toWSFrame(data)
+		if err != nil {
+			return errorReturnValue(-9803, err.Error())
+		}
+		hub.WriteAll(frame)
+		return sent()
+	}
+
+	join := func(hubName string, room string, key string) *JSCallReturnValue {
+		hub, errVal := getHub(hubName)
+		if errVal != nil {
+			return errVal
+		}
+		n, err := hub.JoinClient(room, key)
+		if err != nil {
+			return errorReturnValue(-9802, err.Error())
+		}
+		return &JSCallReturnValue{
+			OK:         true,
+			ResultCode: 200,
+			Results:    map[string]interface{}{"connections": n},
+		}
+	}
+
+	leave := func(hubName string, room string, key string) *JSCallReturnValue {
+		hub, errVal := getHub(hubName)
+		if errVal != nil {
+			return errVal
+		}
+		hub.LeaveClient(room, key)
+		return sent()
+	}
+
+	rooms := func(hubName string) *JSCallReturnValue {
+		hub, errVal := getHub(hubName)
+		if errVal != nil {
+			return errVal
+		}
+		return &JSCallReturnValue{
+			OK:         true,
+			ResultCode: 200,
+			Results:    map[string]interface{}{"rooms": hub.Rooms()},
+		}
+	}
+
+	presence := func(hubName string, room string) *JSCallReturnValue {
+		hub, errVal := getHub(hubName)
+		if errVal != nil {
+			return errVal
+		}
+		data, err := toJSData(hub.Presence(room))
+		if err != nil {
+			return errorReturnValue(-9803, err.Error())
+		}
+		return &JSCallReturnValue{
+			OK:         true,
+			ResultCode: 200,
+			Results:    map[string]interface{}{"members": data},
+		}
+	}
+
+	obj.Set("send", send)
+	obj.Set("sendRoom", sendRoom)
+	obj.Set("sendAll", sendAll)
+	obj.Set("join", join)
+	obj.Set("leave", leave)
+	obj.Set("rooms", rooms)
+	obj.Set("presence", presence)
+	vm.Set("ws", obj)
+}
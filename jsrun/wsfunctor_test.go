@@ -0,0 +1,42 @@
+package jsrun
+
+import (
+	"github.com/dop251/goja"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/highgrav/taproot/authn"
+	"github.com/highgrav/taproot/websock"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWSFunctor(t *testing.T) {
+	hub := websock.NewWSHubWithOptions("chat", websock.WSConnOptions{PingInterval: -1})
+	server, client := net.Pipe()
+	defer client.Close()
+	wsc := websock.NewWSConnWithOptions("sess-1", authn.User{UserID: "ann"}, server, nil, hub.ConnOptions())
+	hub.AddClient(&wsc)
+
+	vm := goja.New()
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+	InjectJSWSFunctor(map[string]*websock.WSHub{"chat": hub}, vm)
+	val, err := vm.RunString(`
+		const joined = ws.join("chat", "room-1", "sess-1");
+		const members = ws.presence("chat", "room-1").results.members;
+		ws.sendRoom("chat", "room-1", {text: "hi"});
+		[joined.results.connections, members.length, members[0].userId, ws.send("nope", "sess-1", "x").ok].join(",")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := val.String(); res != "1,1,ann,false" {
+		t.Fatalf("unexpected result %s", res)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := wsutil.ReadServerText(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"text":"hi"}` {
+		t.Fatalf("unexpected frame %s", data)
+	}
+}
@@ -2,7 +2,6 @@ package jsmlparser
 
 import (
 	"errors"
-	"fmt"
 	"github.com/highgrav/taproot/common"
 	"github.com/highgrav/taproot/languages/token"
 	"strings"
@@ -94,7 +93,12 @@ func (parse *Parser) throwError(msg string) error {
 	if msg == "" {
 		message = tok.Message
 	}
-	return errors.New(fmt.Sprintf("error '%s' at line %d, pos %d, (token type: %s, literal: '%s')", message, line, linepos, tok.Type, tok.Literal))
+	return &token.PositionError{
+		Line:    line,
+		Column:  linepos,
+		Message: message,
+		Token:   tok,
+	}
 }
 
 func (parse *Parser) current() token.Token {
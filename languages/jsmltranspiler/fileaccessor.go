@@ -0,0 +1,49 @@
+package jsmltranspiler
+
+import (
+	"github.com/highgrav/taproot/common"
+	"os"
+	"strings"
+)
+
+/*
+A FileScriptAccessor reads scripts straight from disk, for compiling JSML outside of a running server. Included JSML is
+looked up under JSMLDir and compiled JS under JSDir, the same way the server locates relocated files.
+*/
+type FileScriptAccessor struct {
+	JSMLDir string
+	JSDir   string
+}
+
+// Finds the JSML file for a script ID
+func (fsa FileScriptAccessor) Locate(id string) (string, error) {
+	// Make sure we're looking for a JSML file
+	if strings.HasSuffix(id, ".js") {
+		id = id + "ml"
+	}
+	return common.FindRelocatedFile(fsa.JSMLDir, id)
+}
+
+func (fsa FileScriptAccessor) GetJSMLScriptByID(id string) (string, error) {
+	fileName, err := fsa.Locate(id)
+	if err != nil {
+		return "", err
+	}
+	script, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	return string(script), nil
+}
+
+func (fsa FileScriptAccessor) GetJSScriptByID(id string) (string, error) {
+	fileName, err := common.FindRelocatedFile(fsa.JSDir, id)
+	if err != nil {
+		return "", err
+	}
+	script, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	return string(script), nil
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/highgrav/taproot/common"
 	"github.com/highgrav/taproot/languages/jsmlparser"
 	"github.com/highgrav/taproot/languages/lexer"
+	"github.com/highgrav/taproot/languages/token"
 	"strings"
 )
 
@@ -95,7 +96,12 @@ func (tr *Transpiler) throwError(node jsmlparser.ParseNode, msg string) error {
 	if msg == "" {
 		message = tok.Message
 	}
-	return errors.New(fmt.Sprintf("error '%s' at line %d, pos %d, (token type: %s, literal: '%s')", message, line, linepos, tok.Type, tok.Literal))
+	return &token.PositionError{
+		Line:    line,
+		Column:  linepos,
+		Message: message,
+		Token:   tok,
+	}
 }
 
 func (tr *Transpiler) mode() transpMode {
@@ -1,9 +1,11 @@
 package jsmltranspiler
 
 import (
+	"errors"
 	"fmt"
 	"github.com/highgrav/taproot/languages/jsmlparser"
 	"github.com/highgrav/taproot/languages/lexer"
+	"github.com/highgrav/taproot/languages/token"
 	"os"
 	"testing"
 )
@@ -75,3 +77,15 @@ func TestParse(t *testing.T) {
 	fmt.Println(tr.output.String())
 	os.WriteFile("/tmp/test.js", []byte(tr.output.String()), 777)
 }
+
+func TestErrorPosition(t *testing.T) {
+	const input string = "<html>\n<body>\n<go.include/>\n</body>\n</html>"
+	_, err := NewAndTranspile("test", testScriptAccessor{}, input, false)
+	var pe *token.PositionError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a position error, got %v", err)
+	}
+	if pe.Line != 3 {
+		t.Errorf("expected error on line 3, got line %d", pe.Line)
+	}
+}
@@ -1,7 +1,6 @@
 package lexer
 
 import (
-	"errors"
 	"fmt"
 	"github.com/highgrav/taproot/common"
 	"github.com/highgrav/taproot/languages/token"
@@ -81,7 +80,13 @@ func (lex *Lexer) Lex() ([]token.Token, error) {
 			}
 			if tok.Type == token.TOKEN_ERROR {
 				tokens = append(tokens, tok)
-				return tokens, errors.New(tok.Message)
+				line, linepos := lex.input.GetLineAndPos(int32(tok.CharPos))
+				return tokens, &token.PositionError{
+					Line:    line,
+					Column:  linepos,
+					Message: tok.Message,
+					Token:   tok,
+				}
 			}
 			tokens = append(tokens, tok)
 		}
@@ -56,3 +56,15 @@ func (pn Token) IsOfType(types []TokenType) bool {
 func New(tokenType TokenType, ch rune) Token {
 	return Token{Type: tokenType, Literal: string(ch)}
 }
+
+// An error at a position in a script. Line and Column are 1-based.
+type PositionError struct {
+	Line    int
+	Column  int
+	Message string
+	Token   Token
+}
+
+func (pe *PositionError) Error() string {
+	return fmt.Sprintf("error '%s' at line %d, pos %d, (token type: %s, literal: '%s')", pe.Message, pe.Line, pe.Column, pe.Token.Type, pe.Token.Literal)
+}
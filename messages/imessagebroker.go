@@ -0,0 +1,13 @@
+package messages
+
+/*
+An IMessageBroker carries messages between server instances. Every message published by any instance, including this
+one, must be passed to the handlers of every instance, in the order each instance published them; the MessageBus
+ignores an instance's own messages. Delivery can be best-effort, as with the hubs themselves: a message may be lost if
+an instance is down or far behind, but shouldn't be delivered twice.
+*/
+type IMessageBroker interface {
+	Publish(msg Message) error
+	Subscribe(handler func(msg Message)) error
+	Close() error
+}
@@ -1,4 +1,6 @@
 package messages
 
+// An IMessageTarget delivers messages relayed from other instances, such as an SSE or WebSocket hub
 type IMessageTarget interface {
+	HandleMessage(msg Message) error
 }
@@ -0,0 +1,103 @@
+package messages
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrBrokerClosed = errors.New("message broker is closed")
+
+/*
+An IMessageBroker that passes messages between buses in the same process, for tests and single-server setups. Each
+handler is called from its own goroutine, in the order messages were published, so a slow handler doesn't hold up
+publishing or the other handlers.
+*/
+type MemoryBroker struct {
+	mu     sync.Mutex
+	subs   []*memorySub
+	closed bool
+}
+
+type memorySub struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []Message
+	closed  bool
+	handler func(msg Message)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subs: make([]*memorySub, 0),
+	}
+}
+
+func (mb *MemoryBroker) Publish(msg Message) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return ErrBrokerClosed
+	}
+	for _, sub := range mb.subs {
+		sub.mu.Lock()
+		sub.pending = append(sub.pending, msg)
+		sub.mu.Unlock()
+		sub.cond.Signal()
+	}
+	return nil
+}
+
+func (mb *MemoryBroker) Subscribe(handler func(msg Message)) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return ErrBrokerClosed
+	}
+	sub := &memorySub{
+		pending: make([]Message, 0),
+		handler: handler,
+	}
+	sub.cond = sync.NewCond(&sub.mu)
+	mb.subs = append(mb.subs, sub)
+	go sub.run()
+	return nil
+}
+
+func (sub *memorySub) run() {
+	for {
+		sub.mu.Lock()
+		for len(sub.pending) == 0 && !sub.closed {
+			sub.cond.Wait()
+		}
+		if sub.closed {
+			sub.mu.Unlock()
+			return
+		}
+		msgs := sub.pending
+		sub.pending = make([]Message, 0)
+		sub.mu.Unlock()
+		for _, msg := range msgs {
+			sub.handler(msg)
+		}
+	}
+}
+
+/*
+Stops delivering messages to every handler. Since the broker is usually shared between buses, closing any of them
+closes it for all.
+*/
+func (mb *MemoryBroker) Close() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return nil
+	}
+	mb.closed = true
+	for _, sub := range mb.subs {
+		sub.mu.Lock()
+		sub.closed = true
+		sub.mu.Unlock()
+		sub.cond.Signal()
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package messages
+
+import "encoding/json"
+
+// The kinds of hub a message can be for
+const (
+	MSG_KIND_SSE string = "sse"
+	MSG_KIND_WS  string = "ws"
+)
+
+// The hub writes a message can relay
+const (
+	MSG_OP_ONE     string = "one"     // To one client; Targets holds its ID
+	MSG_OP_MANY    string = "many"    // To several clients; Targets holds their IDs
+	MSG_OP_ALL     string = "all"     // To every client
+	MSG_OP_PUBLISH string = "publish" // To a topic's subscribers; Targets holds the topic
+	MSG_OP_ROOM    string = "room"    // To the connections in a WebSocket room; Targets holds the room
+)
+
+/*
+A Message is a write to a hub on one server instance, relayed so that the same hub on every other instance can deliver
+it to the clients connected there. The payload is the JSON-encoded event or frame.
+*/
+type Message struct {
+	Origin  string          `json:"origin"` // The instance that sent the message
+	Kind    string          `json:"kind"`
+	Hub     string          `json:"hub"`
+	Op      string          `json:"op"`
+	Targets []string        `json:"targets"`
+	Payload json.RawMessage `json:"payload"`
+}
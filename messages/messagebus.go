@@ -0,0 +1,139 @@
+package messages
+
+import (
+	"context"
+	"github.com/highgrav/taproot/common"
+	"github.com/highgrav/taproot/logging"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Messages waiting to be published before new ones are dropped, so a slow broker can't hold up the hubs
+const MSG_DEFAULT_QUEUE_SIZE int = 1024
+
+/*
+A MessageBus connects the hubs on this instance to a broker. Hubs send their writes to the bus, which publishes them
+without waiting; messages from other instances are handed to the hub with the same kind and name.
+*/
+type MessageBus struct {
+	InstanceID string
+	Metrics    *MessageBusMetrics
+	broker     IMessageBroker
+	mu         sync.RWMutex
+	targets    map[string]IMessageTarget
+	queue      chan Message
+	closeOnce  sync.Once
+	done       chan bool
+}
+
+// Counts of the messages a bus has handled, updated atomically
+type MessageBusMetrics struct {
+	Sent     int64 `json:"sent"`
+	Dropped  int64 `json:"dropped"` // Messages dropped because the queue to the broker was full
+	Failed   int64 `json:"failed"`  // Messages the broker couldn't publish, or a hub couldn't deliver
+	Received int64 `json:"received"`
+	Unrouted int64 `json:"unrouted"` // Messages for hubs this instance doesn't have
+}
+
+func (m *MessageBusMetrics) Snapshot() MessageBusMetrics {
+	return MessageBusMetrics{
+		Sent:     atomic.LoadInt64(&m.Sent),
+		Dropped:  atomic.LoadInt64(&m.Dropped),
+		Failed:   atomic.LoadInt64(&m.Failed),
+		Received: atomic.LoadInt64(&m.Received),
+		Unrouted: atomic.LoadInt64(&m.Unrouted),
+	}
+}
+
+// Creates a bus on a broker, and starts receiving messages from it
+func NewMessageBus(broker IMessageBroker) (*MessageBus, error) {
+	host, _ := os.Hostname()
+	bus := &MessageBus{
+		InstanceID: host + "-" + common.CreateRandString(12),
+		Metrics:    &MessageBusMetrics{},
+		broker:     broker,
+		targets:    make(map[string]IMessageTarget),
+		queue:      make(chan Message, MSG_DEFAULT_QUEUE_SIZE),
+		done:       make(chan bool),
+	}
+	err := broker.Subscribe(bus.receive)
+	if err != nil {
+		return nil, err
+	}
+	go bus.publish()
+	return bus, nil
+}
+
+// Sets the hub that messages of a kind and hub name are delivered to
+func (bus *MessageBus) AddTarget(kind string, hub string, target IMessageTarget) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.targets[kind+":"+hub] = target
+}
+
+func (bus *MessageBus) RemoveTarget(kind string, hub string) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	delete(bus.targets, kind+":"+hub)
+}
+
+// Queues a message to be published to the other instances. Returns false if it was dropped.
+func (bus *MessageBus) Send(msg Message) bool {
+	msg.Origin = bus.InstanceID
+	select {
+	case <-bus.done:
+		return false
+	case bus.queue <- msg:
+		return true
+	default:
+		atomic.AddInt64(&bus.Metrics.Dropped, 1)
+		return false
+	}
+}
+
+func (bus *MessageBus) publish() {
+	for {
+		select {
+		case <-bus.done:
+			return
+		case msg := <-bus.queue:
+			err := bus.broker.Publish(msg)
+			if err != nil {
+				atomic.AddInt64(&bus.Metrics.Failed, 1)
+				logging.LogToDeck(context.Background(), "error", "MSG", "error", "could not publish message for "+msg.Kind+" hub "+msg.Hub+": "+err.Error())
+				continue
+			}
+			atomic.AddInt64(&bus.Metrics.Sent, 1)
+		}
+	}
+}
+
+func (bus *MessageBus) receive(msg Message) {
+	if msg.Origin == bus.InstanceID {
+		return
+	}
+	atomic.AddInt64(&bus.Metrics.Received, 1)
+	bus.mu.RLock()
+	target, ok := bus.targets[msg.Kind+":"+msg.Hub]
+	bus.mu.RUnlock()
+	if !ok {
+		atomic.AddInt64(&bus.Metrics.Unrouted, 1)
+		return
+	}
+	err := target.HandleMessage(msg)
+	if err != nil {
+		atomic.AddInt64(&bus.Metrics.Failed, 1)
+		logging.LogToDeck(context.Background(), "error", "MSG", "error", "could not deliver message to "+msg.Kind+" hub "+msg.Hub+": "+err.Error())
+	}
+}
+
+// Stops publishing and closes the broker; messages still queued are dropped
+func (bus *MessageBus) Close() error {
+	var err error
+	bus.closeOnce.Do(func() {
+		close(bus.done)
+		err = bus.broker.Close()
+	})
+	return err
+}
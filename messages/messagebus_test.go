@@ -0,0 +1,138 @@
+package messages
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/highgrav/taproot/dbutils"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type testTarget chan Message
+
+func (tt testTarget) HandleMessage(msg Message) error {
+	tt <- msg
+	return nil
+}
+
+func expectMessages(t *testing.T, tt testTarget, targets ...string) {
+	t.Helper()
+	for _, want := range targets {
+		select {
+		case msg := <-tt:
+			if len(msg.Targets) != 1 || msg.Targets[0] != want {
+				t.Fatalf("expected message for %s, got %v", want, msg.Targets)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for message for %s", want)
+		}
+	}
+}
+
+// Sends numbered messages from one bus, and checks they reach a hub on the other, in order, and not the sender's own
+func testRelay(t *testing.T, a *MessageBus, b *MessageBus) {
+	t.Helper()
+	ta, tb := make(testTarget, 100), make(testTarget, 100)
+	a.AddTarget(MSG_KIND_SSE, "updates", ta)
+	b.AddTarget(MSG_KIND_SSE, "updates", tb)
+	want := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		id := strconv.Itoa(i)
+		a.Send(Message{Kind: MSG_KIND_SSE, Hub: "updates", Op: MSG_OP_ONE, Targets: []string{id}})
+		want = append(want, id)
+	}
+	b.Send(Message{Kind: MSG_KIND_WS, Hub: "updates", Op: MSG_OP_ALL})
+	b.Send(Message{Kind: MSG_KIND_SSE, Hub: "updates", Op: MSG_OP_ONE, Targets: []string{"from-b"}})
+	expectMessages(t, tb, want...)
+	expectMessages(t, ta, "from-b")
+	if len(ta) != 0 || len(tb) != 0 {
+		t.Fatalf("unexpected extra messages")
+	}
+	if m := a.Metrics.Snapshot(); m.Received != 2 || m.Unrouted != 1 {
+		t.Fatalf("unexpected metrics %+v", m)
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	broker := NewMemoryBroker()
+	a, err := NewMessageBus(broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewMessageBus(broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRelay(t, a, b)
+	a.Close()
+	if err := broker.Publish(Message{}); err != ErrBrokerClosed {
+		t.Fatalf("expected ErrBrokerClosed, got %v", err)
+	}
+}
+
+func TestSQLBroker(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "messages.db")+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := NewSQLBroker(db, "sqlite3", "messages; DROP TABLE x"); err != dbutils.ErrInvalidTableName {
+		t.Fatalf("expected ErrInvalidTableName, got %v", err)
+	}
+	if _, err := NewSQLBroker(db, "oracle", "messages"); err != dbutils.ErrUnsupportedDriver {
+		t.Fatalf("expected ErrUnsupportedDriver, got %v", err)
+	}
+
+	// two brokers sharing a database stand in for two server instances
+	buses := make([]*MessageBus, 0)
+	for i := 0; i < 2; i++ {
+		sb, err := NewSQLBroker(db, "sqlite3", "messages")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb.PollInterval = 10 * time.Millisecond
+		sb.Retention = 50 * time.Millisecond
+		if i == 0 {
+			// published before either instance subscribes, so never delivered
+			sb.Publish(Message{Origin: "old", Kind: MSG_KIND_SSE, Hub: "updates", Targets: []string{"old"}})
+		}
+		bus, err := NewMessageBus(sb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { bus.Close() })
+		buses = append(buses, bus)
+	}
+	testRelay(t, buses[0], buses[1])
+
+	time.Sleep(150 * time.Millisecond)
+	n := 0
+	err = db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected old messages to be deleted, %d left", n)
+	}
+}
+
+func TestOpenSQLBroker(t *testing.T) {
+	sb, err := OpenSQLBroker("sqlite3", filepath.Join(t.TempDir(), "messages.db"), "messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus, err := NewMessageBus(sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closing the bus closes the broker, and with it the database the broker opened
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sb.db.Ping(); err == nil {
+		t.Fatal("expected the database to be closed")
+	}
+}
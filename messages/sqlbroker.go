@@ -0,0 +1,254 @@
+package messages
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/highgrav/taproot/dbutils"
+	"github.com/highgrav/taproot/logging"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How often a SQLBroker checks for messages from other instances, and how long it keeps them, unless configured otherwise
+const (
+	MSG_DEFAULT_POLL_INTERVAL time.Duration = 250 * time.Millisecond
+	MSG_DEFAULT_RETENTION     time.Duration = time.Minute
+)
+
+// How many IDs behind the newest seen a SQLBroker looks again, for rows whose transactions committed late
+const msgPollLookback int64 = 256
+
+/*
+SQLBroker is an IMessageBroker for server instances sharing a database. Published messages are inserted into a table
+that every instance polls for rows newer than the last it saw, so messages arrive within about one PollInterval; rows
+older than Retention are deleted as instances poll. Postgres and SQLite are supported.
+
+Since an instance only reads messages published after it first subscribes, and rows are deleted after Retention, an
+instance that stops polling for longer than that misses messages, as a client disconnected from a hub would.
+PollInterval and Retention must be set before Subscribe() is called.
+*/
+type SQLBroker struct {
+	PollInterval time.Duration
+	Retention    time.Duration
+	db           *sql.DB
+	ownsDB       bool // Set if the broker opened the database, and so closes it
+	dialect      dbutils.SQLDialect
+	table        string
+	mu           sync.Mutex
+	handlers     []func(msg Message)
+	closeOnce    sync.Once
+	closed       chan bool
+}
+
+/*
+Creates a SQL broker using a table in an open database, creating the table if it doesn't exist. The driver is the name
+the database was opened with, such as "postgres" or "sqlite3".
+*/
+func NewSQLBroker(db *sql.DB, driver string, table string) (*SQLBroker, error) {
+	dialect, err := dbutils.DialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	err = dbutils.CheckTableName(table)
+	if err != nil {
+		return nil, err
+	}
+	sb := &SQLBroker{
+		PollInterval: MSG_DEFAULT_POLL_INTERVAL,
+		Retention:    MSG_DEFAULT_RETENTION,
+		db:           db,
+		dialect:      dialect,
+		table:        table,
+		handlers:     make([]func(msg Message), 0),
+		closed:       make(chan bool),
+	}
+	// AUTOINCREMENT, so IDs are never reused after old rows are deleted
+	idType := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if dialect.Name == dbutils.SQL_DIALECT_POSTGRES {
+		idType = "BIGSERIAL PRIMARY KEY"
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS " + table + ` (
+		id ` + idType + `,
+		origin VARCHAR(255) NOT NULL,
+		created_at BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS " + table + "_created ON " + table + " (created_at)")
+	if err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+/*
+Opens a database and creates a SQL broker using a table in it, as with NewSQLBroker(). The broker owns the database,
+closing it when the broker is closed.
+*/
+func OpenSQLBroker(driver string, dsn string, table string) (*SQLBroker, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := NewSQLBroker(db, driver, table)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	sb.ownsDB = true
+	return sb, nil
+}
+
+// Fills in the table name and rewrites the parameters of a query for the database
+func (sb *SQLBroker) sql(query string) string {
+	return sb.dialect.Bind(query, sb.table)
+}
+
+func (sb *SQLBroker) Publish(msg Message) error {
+	select {
+	case <-sb.closed:
+		return ErrBrokerClosed
+	default:
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = sb.db.Exec(sb.sql("INSERT INTO {table} (origin, created_at, data) VALUES (?, ?, ?)"),
+		msg.Origin, time.Now().UnixNano(), string(data))
+	return err
+}
+
+// Adds a handler for messages published from now on. The first call starts polling.
+func (sb *SQLBroker) Subscribe(handler func(msg Message)) error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	select {
+	case <-sb.closed:
+		return ErrBrokerClosed
+	default:
+	}
+	sb.handlers = append(sb.handlers, handler)
+	if len(sb.handlers) > 1 {
+		return nil
+	}
+	var start sql.NullInt64
+	err := sb.db.QueryRow(sb.sql("SELECT MAX(id) FROM {table}")).Scan(&start)
+	if err != nil {
+		sb.handlers = sb.handlers[:0]
+		return err
+	}
+	go sb.poll(start.Int64)
+	return nil
+}
+
+/*
+Reads new rows until the broker is closed. IDs are handed out when rows are inserted but become visible when their
+transactions commit, which on Postgres may be out of order, so each poll also looks back over recent IDs for any that
+showed up late, skipping those already passed on.
+*/
+func (sb *SQLBroker) poll(start int64) {
+	ticker := time.NewTicker(sb.PollInterval)
+	defer ticker.Stop()
+	seen := make(map[int64]bool)
+	newest := start
+	lastCleanup := time.Now()
+	for {
+		select {
+		case <-sb.closed:
+			return
+		case <-ticker.C:
+		}
+		from := newest - msgPollLookback
+		if from < start {
+			from = start
+		}
+		msgs, err := sb.read(from, seen)
+		if err != nil {
+			select {
+			case <-sb.closed:
+				// the database may have been closed along with the broker
+				return
+			default:
+			}
+			logging.LogToDeck(context.Background(), "error", "MSG", "error", "could not read messages from "+sb.table+": "+err.Error())
+			continue
+		}
+		sb.mu.Lock()
+		handlers := sb.handlers
+		sb.mu.Unlock()
+		for _, m := range msgs {
+			seen[m.id] = true
+			if m.id > newest {
+				newest = m.id
+			}
+			for _, handler := range handlers {
+				handler(m.msg)
+			}
+		}
+		for id := range seen {
+			if id <= newest-msgPollLookback {
+				delete(seen, id)
+			}
+		}
+		if time.Since(lastCleanup) > sb.Retention {
+			lastCleanup = time.Now()
+			_, err = sb.db.Exec(sb.sql("DELETE FROM {table} WHERE created_at < ?"), time.Now().Add(-sb.Retention).UnixNano())
+			if err != nil {
+				logging.LogToDeck(context.Background(), "error", "MSG", "error", "could not delete old messages from "+sb.table+": "+err.Error())
+			}
+		}
+	}
+}
+
+type sqlMessage struct {
+	id  int64
+	msg Message
+}
+
+// Returns the messages with IDs after the given one that haven't been seen, oldest first
+func (sb *SQLBroker) read(from int64, seen map[int64]bool) ([]sqlMessage, error) {
+	rows, err := sb.db.Query(sb.sql("SELECT id, data FROM {table} WHERE id > ? ORDER BY id"), from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := make([]sqlMessage, 0)
+	for rows.Next() {
+		var id int64
+		var data string
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			continue
+		}
+		m := sqlMessage{id: id}
+		err = json.Unmarshal([]byte(data), &m.msg)
+		if err != nil {
+			// marks it seen, so a bad row is only reported once
+			seen[id] = true
+			logging.LogToDeck(context.Background(), "error", "MSG", "error", "could not decode message "+strconv.FormatInt(id, 10)+" from "+sb.table+": "+err.Error())
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// Stops polling. The database is closed if the broker opened it with OpenSQLBroker(), and left open otherwise.
+func (sb *SQLBroker) Close() error {
+	var err error
+	sb.closeOnce.Do(func() {
+		close(sb.closed)
+		if sb.ownsDB {
+			err = sb.db.Close()
+		}
+	})
+	return err
+}
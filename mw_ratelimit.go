@@ -8,9 +8,61 @@ import (
 	"time"
 )
 
+// The current per-IP rate limits. These start out as the configured values and can be changed at runtime.
+type ipRateLimitSettings struct {
+	sync.RWMutex
+	requestsPerSecond int
+	burstableRequests int
+}
+
+func (s *ipRateLimitSettings) get() (rate.Limit, int) {
+	s.RLock()
+	defer s.RUnlock()
+	return rate.Limit(s.requestsPerSecond), s.burstableRequests
+}
+
+func (s *ipRateLimitSettings) set(requestsPerSecond, burstableRequests int) {
+	s.Lock()
+	defer s.Unlock()
+	s.requestsPerSecond = requestsPerSecond
+	s.burstableRequests = burstableRequests
+}
+
+// Changes the global rate limit at runtime.
+func (srv *AppServer) SetGlobalRateLimit(requestsPerSecond, burstableRequests int) {
+	if srv.globalRateLimiter == nil {
+		srv.globalRateLimiter = rate.NewLimiter(rate.Limit(requestsPerSecond), burstableRequests)
+		return
+	}
+	srv.globalRateLimiter.SetLimit(rate.Limit(requestsPerSecond))
+	srv.globalRateLimiter.SetBurst(burstableRequests)
+}
+
+// Returns the current global rate limit (requests per second) and burst size.
+func (srv *AppServer) GlobalRateLimit() (int, int) {
+	if srv.globalRateLimiter == nil {
+		return srv.Config.HttpServer.GlobalRateLimits.RequestsPerSecond, srv.Config.HttpServer.GlobalRateLimits.BurstableRequests
+	}
+	return int(srv.globalRateLimiter.Limit()), srv.globalRateLimiter.Burst()
+}
+
+// Changes the per-IP rate limit at runtime. Clients that are already being tracked pick up the new limit on their next request.
+func (srv *AppServer) SetIPRateLimit(requestsPerSecond, burstableRequests int) {
+	srv.ipRateLimits.set(requestsPerSecond, burstableRequests)
+}
+
+// Returns the current per-IP rate limit (requests per second) and burst size.
+func (srv *AppServer) IPRateLimit() (int, int) {
+	lim, burst := srv.ipRateLimits.get()
+	return int(lim), burst
+}
+
 // TODO -- HttpServer config
 func (srv *AppServer) HandleGlobalRateLimit(next http.Handler) http.Handler {
-	limiter := rate.NewLimiter(rate.Limit(srv.Config.HttpServer.GlobalRateLimits.RequestsPerSecond), srv.Config.HttpServer.GlobalRateLimits.BurstableRequests)
+	if srv.globalRateLimiter == nil {
+		srv.globalRateLimiter = rate.NewLimiter(rate.Limit(srv.Config.HttpServer.GlobalRateLimits.RequestsPerSecond), srv.Config.HttpServer.GlobalRateLimits.BurstableRequests)
+	}
+	limiter := srv.globalRateLimiter
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !limiter.Allow() {
@@ -52,10 +104,12 @@ func (srv *AppServer) HandleIPRateLimit(next http.Handler) http.Handler {
 			srv.ServerErrorResponse(w, r)
 			return
 		}
+		limit, burst := srv.ipRateLimits.get()
 		mu.Lock()
 		if _, exists := clients[ipstr]; !exists {
 			ip := net.ParseIP(ipstr)
 			if ip == nil {
+				mu.Unlock()
 				srv.ServerErrorResponse(w, r)
 				return
 			}
@@ -70,17 +124,27 @@ func (srv *AppServer) HandleIPRateLimit(next http.Handler) http.Handler {
 			}
 			if _, nowexists := clients[ipstr]; !nowexists {
 				clients[ipstr] = &client{
-					limiter:  rate.NewLimiter(rate.Limit(srv.Config.HttpServer.IpRateLimits.RequestsPerSecond), srv.Config.HttpServer.IpRateLimits.BurstableRequests),
+					limiter:  rate.NewLimiter(limit, burst),
 					lastSeen: time.Now(),
 					exempted: false,
 				}
 			}
 		}
-		clients[ipstr].lastSeen = time.Now()
-		if !clients[ipstr].exempted && !clients[ipstr].limiter.Allow() {
-			mu.Unlock()
-			srv.RateLimitExceededResponse(w, r)
-			return
+		c := clients[ipstr]
+		c.lastSeen = time.Now()
+		if !c.exempted {
+			// pick up any limit changes made since this client was first seen
+			if c.limiter.Limit() != limit {
+				c.limiter.SetLimit(limit)
+			}
+			if c.limiter.Burst() != burst {
+				c.limiter.SetBurst(burst)
+			}
+			if !c.limiter.Allow() {
+				mu.Unlock()
+				srv.RateLimitExceededResponse(w, r)
+				return
+			}
 		}
 		mu.Unlock()
 		next.ServeHTTP(w, r)
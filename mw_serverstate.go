@@ -0,0 +1,22 @@
+package taproot
+
+import (
+	"net/http"
+)
+
+/*
+Rejects new requests once the server has been told to drain (or is shutting down), so that load balancers can move
+traffic elsewhere while in-flight requests complete.
+*/
+func (srv *AppServer) handleServerState(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := srv.state.getState()
+		if st == SERVER_STATE_CLOSING || st == SERVER_STATE_CLOSED {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "30")
+			srv.ErrorResponse(w, r, http.StatusServiceUnavailable, "server is shutting down")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,9 +3,12 @@ package pagecache
 import (
 	"context"
 	"github.com/highgrav/taproot/logging"
+	"sort"
+	"sync"
 )
 
 type PageCache struct {
+	sync.RWMutex
 	Metrics *PageCacheMetrics
 	cache   map[string]*PageCacheEntry
 }
@@ -19,6 +22,8 @@ func NewPageCache() *PageCache {
 }
 
 func (pc *PageCache) Get(id string) (string, bool) {
+	pc.RLock()
+	defer pc.RUnlock()
 	pce, ok := pc.cache[id]
 	if !ok {
 		return "", ok
@@ -27,17 +32,49 @@ func (pc *PageCache) Get(id string) (string, bool) {
 }
 
 func (pc *PageCache) expire(id string) {
-	// TODO -- flags as a race condition
+	pc.Lock()
+	defer pc.Unlock()
 	delete(pc.cache, id)
 }
 
 func (pc *PageCache) Put(id, data string, secsToKeep int) {
 	pce := NewPageCacheEntry(id, data, secsToKeep, pc.expire)
+	pc.Lock()
 	pc.cache[id] = pce
+	pc.Unlock()
 	logging.LogToDeck(context.Background(), "info", "CACHE", "info", "adding "+id+" to page cache")
 }
 
 func (pc *PageCache) Flush(id string) {
+	pc.Lock()
 	delete(pc.cache, id)
+	pc.Unlock()
 	logging.LogToDeck(context.Background(), "info", "CACHE", "info", "removing "+id+" from page cache")
 }
+
+// Removes every entry from the page cache
+func (pc *PageCache) FlushAll() {
+	pc.Lock()
+	pc.cache = make(map[string]*PageCacheEntry)
+	pc.Unlock()
+	logging.LogToDeck(context.Background(), "info", "CACHE", "info", "removing all entries from page cache")
+}
+
+// Returns a description of every entry currently in the page cache, sorted by ID
+func (pc *PageCache) List() []PageCacheEntryInfo {
+	pc.RLock()
+	defer pc.RUnlock()
+	infos := make([]PageCacheEntryInfo, 0, len(pc.cache))
+	for _, v := range pc.cache {
+		infos = append(infos, PageCacheEntryInfo{
+			ID:        v.ID,
+			Size:      len(v.Data),
+			CachedOn:  v.CachedOn,
+			ExpiresOn: v.ExpiresOn,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
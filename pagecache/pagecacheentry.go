@@ -5,16 +5,29 @@ import "time"
 type PageCacheEvacFn func(id string)
 
 type PageCacheEntry struct {
-	ID    string
-	Data  string
-	timer *time.Timer
+	ID        string
+	Data      string
+	CachedOn  time.Time
+	ExpiresOn time.Time
+	timer     *time.Timer
+}
+
+// A summary of a cached page, without the page data
+type PageCacheEntryInfo struct {
+	ID        string    `json:"id"`
+	Size      int       `json:"size"`
+	CachedOn  time.Time `json:"cachedOn"`
+	ExpiresOn time.Time `json:"expiresOn"`
 }
 
 func NewPageCacheEntry(id, data string, evacDuration int, evacFn PageCacheEvacFn) *PageCacheEntry {
+	now := time.Now()
 	pce := &PageCacheEntry{
-		ID:    id,
-		Data:  data,
-		timer: time.NewTimer(time.Duration(evacDuration) * time.Second),
+		ID:        id,
+		Data:      data,
+		CachedOn:  now,
+		ExpiresOn: now.Add(time.Duration(evacDuration) * time.Second),
+		timer:     time.NewTimer(time.Duration(evacDuration) * time.Second),
 	}
 	go func() {
 		<-pce.timer.C
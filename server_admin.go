@@ -1,39 +1,754 @@
 package taproot
 
-import "net/http"
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/highgrav/taproot/acacia"
+	"github.com/highgrav/taproot/cron"
+	"github.com/highgrav/taproot/logging"
+	"github.com/highgrav/taproot/workers"
+	"github.com/jpillora/ipfilter"
+	"github.com/justinas/alice"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
 
+const ADMIN_DEFAULT_SHUTDOWN_TIMEOUT_SECS int = 30
+
+/*
+Creates a new admin server using an HttpConfig. The admin server exposes a JSON API for managing a running AppServer
+(page cache, cron, the work queue, signing keys, server state, IP filtering, rate limits, and Acacia policies).
+
+Every call must come from an address allowed by the admin server's own IP filter (cfg.IPFilter, which always blocks by
+default; if no allowed CIDRs are configured, only loopback addresses are allowed) and must carry the bearer token set in
+ServerConfig.AdminToken. If no token is configured, all calls are rejected.
+*/
 func (srv *AppServer) NewAdminServer(cfg HttpConfig) *WebServer {
-	return NewWebServer(nil, cfg)
+	ws := NewWebServer(nil, cfg)
+	ws.Config = cfg
+
+	ipCfg := cfg.IPFilter
+	ipCfg.BlockByDefault = true
+	if len(ipCfg.AllowedCidrs) == 0 {
+		ipCfg.AllowedCidrs = []string{"127.0.0.1/32", "::1/128"}
+	}
+	ws.ipFilter = newIpFilter(ipCfg)
+
+	if srv.Config.AdminToken == "" {
+		logging.LogToDeck(context.Background(), "warn", "ADMIN", "startup", "no admin token configured, all admin server calls will be rejected")
+	}
+
+	ws.Router.HandlerFunc(http.MethodGet, "/server", srv.admin_handle_state)
+	ws.Router.HandlerFunc(http.MethodPost, "/server/drain", srv.admin_handle_drain)
+	ws.Router.HandlerFunc(http.MethodPost, "/server/resume", srv.admin_handle_drain)
+	ws.Router.HandlerFunc(http.MethodPost, "/server/shutdown", srv.admin_handle_shutdown)
+	ws.Router.HandlerFunc(http.MethodGet, "/cache", srv.admin_handle_script_cache)
+	ws.Router.HandlerFunc(http.MethodDelete, "/cache", srv.admin_handle_script_cache)
+	ws.Router.HandlerFunc(http.MethodPost, "/cron/pause", srv.admin_handle_pause)
+	ws.Router.HandlerFunc(http.MethodPost, "/cron/resume", srv.admin_handle_pause)
+	ws.Router.HandlerFunc(http.MethodGet, "/cron", srv.admin_handle_cron_jobs)
+	ws.Router.HandlerFunc(http.MethodPost, "/cron/run", srv.admin_handle_cron_run)
+	ws.Router.HandlerFunc(http.MethodGet, "/cron/history", srv.admin_handle_cron_history)
+	ws.Router.HandlerFunc(http.MethodGet, "/work", srv.admin_handle_work)
+	ws.Router.HandlerFunc(http.MethodGet, "/work/dead", srv.admin_handle_work_dead)
+	ws.Router.HandlerFunc(http.MethodDelete, "/work/dead", srv.admin_handle_work_dead_purge)
+	ws.Router.HandlerFunc(http.MethodPost, "/work/dead/replay", srv.admin_handle_work_dead_replay)
+	ws.Router.HandlerFunc(http.MethodGet, "/work/status", srv.admin_handle_work_status)
+	ws.Router.HandlerFunc(http.MethodGet, "/work/jobs", srv.admin_handle_work_jobs)
+	ws.Router.HandlerFunc(http.MethodPost, "/work/cancel", srv.admin_handle_work_cancel)
+	ws.Router.HandlerFunc(http.MethodGet, "/keys", srv.admin_handle_keys)
+	ws.Router.HandlerFunc(http.MethodPost, "/keys/rotate", srv.admin_handle_keys_rotate)
+	ws.Router.HandlerFunc(http.MethodGet, "/ipfilter", srv.admin_handle_ip_filter)
+	ws.Router.HandlerFunc(http.MethodPost, "/ipfilter", srv.admin_handle_ip_filter)
+	ws.Router.HandlerFunc(http.MethodGet, "/ratelimit", srv.admin_handle_rate_limit)
+	ws.Router.HandlerFunc(http.MethodPost, "/ratelimit", srv.admin_handle_rate_limit)
+	ws.Router.HandlerFunc(http.MethodGet, "/acacia", srv.admin_handle_acacia)
+	ws.Router.HandlerFunc(http.MethodPost, "/acacia", srv.admin_handle_acacia_add)
+	ws.Router.HandlerFunc(http.MethodDelete, "/acacia", srv.admin_handle_acacia_flush)
+	ws.Router.HandlerFunc(http.MethodPost, "/acacia/explain", srv.admin_handle_acacia_explain)
+	ws.Router.HandlerFunc(http.MethodPost, "/acacia/reload", srv.admin_handle_acacia_reload)
+
+	ws.Handler = alice.New(srv.HandlePanic, srv.adminHandleIPFiltering(ws.ipFilter), srv.adminHandleToken).Then(ws.Router)
+	ws.Server.Handler = ws.Handler
+	return ws
 }
 
-func (srv *AppServer) admin_handle_script_cache(w http.ResponseWriter, r *http.Request) {
+/*
+Filters admin calls by the connecting address. Unlike the app server's IP filter, this deliberately ignores forwarding
+headers, since those are trivially spoofed.
+*/
+func (srv *AppServer) adminHandleIPFiltering(filter *ipfilter.IPFilter) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil || !filter.Allowed(ip) {
+				logging.LogToDeck(r.Context(), "warn", "ADMIN", "alert", "admin IP filter blocked IP "+r.RemoteAddr)
+				srv.ForbiddenResponse(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
+// Checks for a valid 'Authorization: Bearer ...' header on admin calls
+func (srv *AppServer) adminHandleToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		authz := r.Header.Get("Authorization")
+		if len(authz) > 7 && strings.EqualFold(authz[:7], "bearer ") {
+			token = strings.TrimSpace(authz[7:])
+		}
+		if srv.Config.AdminToken == "" || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(srv.Config.AdminToken)) != 1 {
+			logging.LogToDeck(r.Context(), "warn", "ADMIN", "alert", "rejected admin call with missing or invalid token from "+r.RemoteAddr)
+			srv.InvalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-func (srv *AppServer) admin_handle_pause(w http.ResponseWriter, r *http.Request) {
+// Writes an admin response, logging any failure
+func (srv *AppServer) adminWriteJSON(w http.ResponseWriter, r *http.Request, env DataEnvelope) {
+	env["ok"] = true
+	err := srv.WriteJSON(w, true, http.StatusOK, env, nil)
+	if err != nil {
+		logging.LogToDeck(r.Context(), "error", "ADMIN", "error", "admin server response: "+err.Error())
+	}
+}
 
+// Returns the server's current state and uptime
+func (srv *AppServer) admin_handle_state(w http.ResponseWriter, r *http.Request) {
+	env := DataEnvelope{}
+	env["state"] = srv.state.getState().String()
+	env["startedOn"] = srv.startedOn
+	env["uptimeSecs"] = time.Now().Sub(srv.startedOn).Seconds()
+	if srv.CronHub != nil {
+		env["cronPaused"] = srv.CronHub.IsPaused()
+	}
+	srv.adminWriteJSON(w, r, env)
 }
 
+/*
+Puts the server into (/server/drain) or takes it out of (/server/resume) drain mode. While draining, keep-alives are
+disabled, cron is paused, and new requests receive a 503 so that a load balancer can move traffic elsewhere, while
+in-flight requests are allowed to complete. Resuming leaves cron paused if it was already paused before draining.
+*/
+func (srv *AppServer) admin_handle_drain(w http.ResponseWriter, r *http.Request) {
+	draining := !strings.HasSuffix(r.URL.Path, "/resume")
+	curr := srv.state.getState()
+	if curr == SERVER_STATE_CLOSED {
+		srv.ErrorResponse(w, r, http.StatusConflict, "server is closed")
+		return
+	}
+	if draining {
+		logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "draining server")
+		srv.state.setState(SERVER_STATE_CLOSING)
+		srv.SetKeepAlivesEnabled(false)
+	} else {
+		logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "resuming server from drain")
+		srv.state.setState(SERVER_STATE_RUNNING)
+		srv.SetKeepAlivesEnabled(true)
+	}
+	if srv.CronHub != nil {
+		srv.state.Lock()
+		if draining {
+			// cron may already have been paused through /cron/pause, which resuming the server shouldn't undo
+			if curr != SERVER_STATE_CLOSING {
+				srv.state.cronPausedBeforeDrain = srv.CronHub.IsPaused()
+			}
+			srv.CronHub.SetPaused(true)
+		} else if curr == SERVER_STATE_CLOSING {
+			srv.CronHub.SetPaused(srv.state.cronPausedBeforeDrain)
+		}
+		srv.state.Unlock()
+	}
+	env := DataEnvelope{}
+	env["state"] = srv.state.getState().String()
+	srv.adminWriteJSON(w, r, env)
+}
+
+/*
+Gracefully shuts down the app server. The call returns immediately; the shutdown waits up to ?timeout= seconds (default
+30) for in-flight requests to complete, then signals ExitServerCh (if set).
+*/
 func (srv *AppServer) admin_handle_shutdown(w http.ResponseWriter, r *http.Request) {
+	timeout := ADMIN_DEFAULT_SHUTDOWN_TIMEOUT_SECS
+	if r.URL.Query().Has("timeout") {
+		t, err := strconv.Atoi(r.URL.Query().Get("timeout"))
+		if err != nil || t < 0 {
+			srv.ErrorResponse(w, r, http.StatusBadRequest, "timeout must be a non-negative number of seconds")
+			return
+		}
+		timeout = t
+	}
+	if srv.state.getState() == SERVER_STATE_CLOSED {
+		srv.ErrorResponse(w, r, http.StatusConflict, "server is already closed")
+		return
+	}
+
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "shutting down server from admin request")
+	srv.state.setState(SERVER_STATE_CLOSING)
+	if srv.CronHub != nil {
+		srv.CronHub.SetPaused(true)
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			logging.LogToDeck(context.Background(), "error", "ADMIN", "error", "error during shutdown: "+err.Error())
+		}
+		srv.state.setState(SERVER_STATE_CLOSED)
+		if srv.ExitServerCh != nil {
+			select {
+			case srv.ExitServerCh <- true:
+			default:
+			}
+		}
+	}()
+
+	env := DataEnvelope{}
+	env["state"] = SERVER_STATE_CLOSING.String()
+	env["timeoutSecs"] = timeout
+	srv.adminWriteJSON(w, r, env)
+}
+
+/*
+GET lists all cached pages. DELETE flushes the page identified by ?id=, or the entire page cache if no ID is passed.
+*/
+func (srv *AppServer) admin_handle_script_cache(w http.ResponseWriter, r *http.Request) {
+	if srv.PageCache == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "page cache is not initialized")
+		return
+	}
+	env := DataEnvelope{}
+	if r.Method == http.MethodDelete {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			srv.PageCache.FlushAll()
+		} else {
+			srv.PageCache.Flush(id)
+		}
+		env["flushed"] = id
+		srv.adminWriteJSON(w, r, env)
+		return
+	}
+	env["entries"] = srv.PageCache.List()
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Pauses (/cron/pause) or resumes (/cron/resume) the cron hub
+func (srv *AppServer) admin_handle_pause(w http.ResponseWriter, r *http.Request) {
+	if srv.CronHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "cron hub is not initialized")
+		return
+	}
+	paused := !strings.HasSuffix(r.URL.Path, "/resume")
+	srv.CronHub.SetPaused(paused)
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "cron paused: "+strconv.FormatBool(paused))
+	env := DataEnvelope{}
+	env["paused"] = paused
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Lists cron jobs and their next run times
+func (srv *AppServer) admin_handle_cron_jobs(w http.ResponseWriter, r *http.Request) {
+	if srv.CronHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "cron hub is not initialized")
+		return
+	}
+	env := DataEnvelope{}
+	env["paused"] = srv.CronHub.IsPaused()
+	env["jobs"] = srv.CronHub.Jobs()
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Runs the cron job named in ?name= immediately
+func (srv *AppServer) admin_handle_cron_run(w http.ResponseWriter, r *http.Request) {
+	if srv.CronHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "cron hub is not initialized")
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, "name is required")
+		return
+	}
+	err := srv.CronHub.RunJob(name)
+	if err == cron.ErrJobNotFound {
+		srv.ErrorResponse(w, r, http.StatusNotFound, "cron job '"+name+"' does not exist")
+		return
+	} else if err == cron.ErrJobRunning {
+		srv.ErrorResponse(w, r, http.StatusConflict, "cron job '"+name+"' is already running")
+		return
+	} else if err != nil {
+		srv.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "triggered cron job "+name)
+	env := DataEnvelope{}
+	env["triggered"] = name
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Lists the recent runs of the cron job named in ?name=, newest first
+func (srv *AppServer) admin_handle_cron_history(w http.ResponseWriter, r *http.Request) {
+	if srv.CronHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "cron hub is not initialized")
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, "name is required")
+		return
+	}
+	runs, err := srv.CronHub.History(name)
+	if err == cron.ErrJobNotFound {
+		srv.ErrorResponse(w, r, http.StatusNotFound, "cron job '"+name+"' does not exist")
+		return
+	}
+	env := DataEnvelope{}
+	env["name"] = name
+	env["runs"] = runs
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Returns the number of messages waiting on the work queue
+func (srv *AppServer) admin_handle_work(w http.ResponseWriter, r *http.Request) {
+	if srv.WorkHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "work hub is not initialized")
+		return
+	}
+	env := DataEnvelope{}
+	env["depth"] = srv.WorkHub.Depth()
+	env["scheduled"] = srv.WorkHub.ScheduledDepth()
+	env["deadLetters"] = srv.WorkHub.DeadLetterDepth()
+	env["metrics"] = srv.WorkHub.MetricsReport()
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Lists dead-lettered work requests, up to ?limit= if given
+func (srv *AppServer) admin_handle_work_dead(w http.ResponseWriter, r *http.Request) {
+	if srv.WorkHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "work hub is not initialized")
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			srv.ErrorResponse(w, r, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+	}
+	dead, err := srv.WorkHub.DeadLetters(limit)
+	if err != nil {
+		srv.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	env := DataEnvelope{}
+	env["depth"] = srv.WorkHub.DeadLetterDepth()
+	env["deadLetters"] = dead
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Puts the dead-lettered request named in ?id= (or all of them) back on the work queue
+func (srv *AppServer) admin_handle_work_dead_replay(w http.ResponseWriter, r *http.Request) {
+	if srv.WorkHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "work hub is not initialized")
+		return
+	}
+	id := r.URL.Query().Get("id")
+	n, err := srv.WorkHub.ReplayDeadLetters(id)
+	if err == workers.ErrDeadLetterNotFound {
+		srv.ErrorResponse(w, r, http.StatusNotFound, "dead letter '"+id+"' does not exist")
+		return
+	} else if err != nil {
+		srv.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "replayed "+strconv.Itoa(n)+" dead-lettered work request(s)")
+	env := DataEnvelope{}
+	env["replayed"] = n
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Deletes the dead-lettered request named in ?id=, or all of them
+func (srv *AppServer) admin_handle_work_dead_purge(w http.ResponseWriter, r *http.Request) {
+	if srv.WorkHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "work hub is not initialized")
+		return
+	}
+	id := r.URL.Query().Get("id")
+	n, err := srv.WorkHub.PurgeDeadLetters(id)
+	if err == workers.ErrDeadLetterNotFound {
+		srv.ErrorResponse(w, r, http.StatusNotFound, "dead letter '"+id+"' does not exist")
+		return
+	} else if err != nil {
+		srv.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "purged "+strconv.Itoa(n)+" dead-lettered work request(s)")
+	env := DataEnvelope{}
+	env["purged"] = n
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Returns the status of the work request named in ?id=
+func (srv *AppServer) admin_handle_work_status(w http.ResponseWriter, r *http.Request) {
+	if srv.WorkHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "work hub is not initialized")
+		return
+	}
+	id := r.URL.Query().Get("id")
+	js, err := srv.WorkHub.JobStatus(id)
+	if err == workers.ErrJobNotFound {
+		srv.ErrorResponse(w, r, http.StatusNotFound, "work '"+id+"' does not exist")
+		return
+	} else if err != nil {
+		srv.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	env := DataEnvelope{}
+	env["status"] = js
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Lists work request statuses, newest first, filtered by ?type=, ?state=, ?user= and ?limit= if given
+func (srv *AppServer) admin_handle_work_jobs(w http.ResponseWriter, r *http.Request) {
+	if srv.WorkHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "work hub is not initialized")
+		return
+	}
+	q := r.URL.Query()
+	filter := workers.JobFilter{
+		Type:   q.Get("type"),
+		State:  workers.JobState(q.Get("state")),
+		UserID: q.Get("user"),
+	}
+	if v := q.Get("limit"); v != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 0 {
+			srv.ErrorResponse(w, r, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+	}
+	jobs, err := srv.WorkHub.Jobs(filter)
+	if err != nil {
+		srv.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	env := DataEnvelope{}
+	env["jobs"] = jobs
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Cancels the work request named in ?id=, if it hasn't started yet
+func (srv *AppServer) admin_handle_work_cancel(w http.ResponseWriter, r *http.Request) {
+	if srv.WorkHub == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "work hub is not initialized")
+		return
+	}
+	id := r.URL.Query().Get("id")
+	err := srv.WorkHub.Cancel(id)
+	if err == workers.ErrJobNotFound {
+		srv.ErrorResponse(w, r, http.StatusNotFound, "work '"+id+"' does not exist")
+		return
+	} else if err == workers.ErrJobNotCancellable {
+		srv.ErrorResponse(w, r, http.StatusConflict, "work '"+id+"' has already started")
+		return
+	} else if err != nil {
+		srv.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "cancelled work "+id)
+	env := DataEnvelope{}
+	env["cancelled"] = id
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Lists the signers that can verify auth tokens (IDs and expirations only)
+func (srv *AppServer) admin_handle_keys(w http.ResponseWriter, r *http.Request) {
+	if srv.SignatureMgr == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "signature manager is not initialized")
+		return
+	}
+	env := DataEnvelope{}
+	env["signers"] = srv.SignatureMgr.ListSigners()
+	srv.adminWriteJSON(w, r, env)
+}
+
+// Rotates to a new signing key. Tokens signed with older keys remain valid until those keys expire.
+func (srv *AppServer) admin_handle_keys_rotate(w http.ResponseWriter, r *http.Request) {
+	if srv.SignatureMgr == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "signature manager is not initialized")
+		return
+	}
+	id, err := srv.SignatureMgr.Rotate()
+	if err != nil {
+		srv.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "rotated signing key to "+id)
+	env := DataEnvelope{}
+	env["current"] = id
+	srv.adminWriteJSON(w, r, env)
+}
 
+// Runtime changes to the app server's IP filter
+type adminIPFilterRequest struct {
+	AllowCidrs     []string `json:"allowCidrs"`
+	BlockCidrs     []string `json:"blockCidrs"`
+	AllowCountries []string `json:"allowCountries"`
+	BlockCountries []string `json:"blockCountries"`
+	BlockByDefault *bool    `json:"blockByDefault"`
 }
 
+/*
+GET checks whether the address passed in ?ip= is allowed by the app server's IP filter. POST applies an
+adminIPFilterRequest to the filter. Changes are not persisted to the config and are lost on restart.
+*/
 func (srv *AppServer) admin_handle_ip_filter(w http.ResponseWriter, r *http.Request) {
+	if srv.httpIpFilter == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "IP filter is not initialized")
+		return
+	}
+	env := DataEnvelope{}
+	if r.Method == http.MethodGet {
+		ip := r.URL.Query().Get("ip")
+		if ip == "" || net.ParseIP(ip) == nil {
+			srv.ErrorResponse(w, r, http.StatusBadRequest, "ip query parameter missing or invalid")
+			return
+		}
+		env["ip"] = ip
+		env["allowed"] = srv.httpIpFilter.Allowed(ip)
+		env["country"] = srv.httpIpFilter.IPToCountry(ip)
+		srv.adminWriteJSON(w, r, env)
+		return
+	}
+
+	req := adminIPFilterRequest{}
+	err := srv.ReadJSONFromBody(w, r, &req)
+	if err != nil {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, cidr := range append(append([]string{}, req.AllowCidrs...), req.BlockCidrs...) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			srv.ErrorResponse(w, r, http.StatusBadRequest, "invalid IP or CIDR '"+cidr+"'")
+			return
+		}
+	}
+	for _, cidr := range req.AllowCidrs {
+		srv.httpIpFilter.AllowIP(cidr)
+	}
+	for _, cidr := range req.BlockCidrs {
+		srv.httpIpFilter.BlockIP(cidr)
+	}
+	for _, c := range req.AllowCountries {
+		srv.httpIpFilter.AllowCountry(c)
+	}
+	for _, c := range req.BlockCountries {
+		srv.httpIpFilter.BlockCountry(c)
+	}
+	if req.BlockByDefault != nil {
+		srv.httpIpFilter.ToggleDefault(!*req.BlockByDefault)
+	}
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "updated IP filter")
+	env["updated"] = true
+	srv.adminWriteJSON(w, r, env)
+}
+
+type adminRateLimit struct {
+	RequestsPerSecond int `json:"requestsPerSecond"`
+	BurstableRequests int `json:"burstableRequests"`
+}
 
+type adminRateLimitRequest struct {
+	Global *adminRateLimit `json:"global"`
+	IP     *adminRateLimit `json:"ip"`
 }
 
+// GET returns the current global and per-IP rate limits. POST changes them.
 func (srv *AppServer) admin_handle_rate_limit(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		req := adminRateLimitRequest{}
+		err := srv.ReadJSONFromBody(w, r, &req)
+		if err != nil {
+			srv.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, lim := range []*adminRateLimit{req.Global, req.IP} {
+			if lim != nil && (lim.RequestsPerSecond < 0 || lim.BurstableRequests < 0) {
+				srv.ErrorResponse(w, r, http.StatusBadRequest, "rate limits must not be negative")
+				return
+			}
+		}
+		if req.Global != nil {
+			srv.SetGlobalRateLimit(req.Global.RequestsPerSecond, req.Global.BurstableRequests)
+		}
+		if req.IP != nil {
+			srv.SetIPRateLimit(req.IP.RequestsPerSecond, req.IP.BurstableRequests)
+		}
+		logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "updated rate limits")
+	}
 
+	env := DataEnvelope{}
+	grps, gburst := srv.GlobalRateLimit()
+	irps, iburst := srv.IPRateLimit()
+	env["global"] = adminRateLimit{RequestsPerSecond: grps, BurstableRequests: gburst}
+	env["ip"] = adminRateLimit{RequestsPerSecond: irps, BurstableRequests: iburst}
+	srv.adminWriteJSON(w, r, env)
 }
 
+// Lists all loaded Acacia policies, keyed by name
 func (srv *AppServer) admin_handle_acacia(w http.ResponseWriter, r *http.Request) {
+	if srv.Acacia == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "acacia is not initialized")
+		return
+	}
+	env := DataEnvelope{}
+	env["policies"] = srv.Acacia.Policies()
+	srv.adminWriteJSON(w, r, env)
+}
 
+// A policy to add through the admin server, either as Acacia source or in compiled JSON form
+type adminAcaciaPolicyRequest struct {
+	Name   string         `json:"name"`
+	Source string         `json:"source"`
+	Policy *acacia.Policy `json:"policy"`
 }
 
+// Adds a new Acacia policy. Policies added this way are not persisted and are lost on restart.
 func (srv *AppServer) admin_handle_acacia_add(w http.ResponseWriter, r *http.Request) {
+	if srv.Acacia == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "acacia is not initialized")
+		return
+	}
+	req := adminAcaciaPolicyRequest{}
+	err := srv.ReadJSONFromBody(w, r, &req)
+	if err != nil {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Name == "" {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, "policy name is required")
+		return
+	}
+	if (req.Source == "") == (req.Policy == nil) {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, "exactly one of source or policy is required")
+		return
+	}
+	if srv.Acacia.HasPolicy(req.Name) {
+		srv.ErrorResponse(w, r, http.StatusConflict, "policy '"+req.Name+"' already exists")
+		return
+	}
+
+	var policy acacia.Policy
+	if req.Policy != nil {
+		policy = *req.Policy
+	} else {
+		p, err := acacia.NewParser(req.Source)
+		if err != nil {
+			srv.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		policy, err = p.Parse()
+		if err != nil {
+			srv.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if len(policy.Routes) == 0 {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, "policy has no paths")
+		return
+	}
 
+	err = srv.Acacia.AddPolicy(req.Name, policy)
+	if err != nil {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "added acacia policy "+req.Name)
+	env := DataEnvelope{}
+	env["added"] = req.Name
+	srv.adminWriteJSON(w, r, env)
 }
 
+// Flushes all policies bound to the route passed in ?route=, or every policy if no route is passed.
 func (srv *AppServer) admin_handle_acacia_flush(w http.ResponseWriter, r *http.Request) {
+	if srv.Acacia == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "acacia is not initialized")
+		return
+	}
+	route := r.URL.Query().Get("route")
+	if route == "" {
+		srv.Acacia.FlushAll()
+	} else {
+		srv.Acacia.FlushAllFor(route)
+	}
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "flushed acacia policies for '"+route+"'")
+	env := DataEnvelope{}
+	env["flushed"] = route
+	srv.adminWriteJSON(w, r, env)
+}
+
+// A rights request to explain, and the route to evaluate it against
+type adminAcaciaExplainRequest struct {
+	Route   string                `json:"route"`
+	Request *acacia.RightsRequest `json:"request"`
+}
+
+// Explains how the loaded policies would evaluate a rights request for a route, without serving a request.
+func (srv *AppServer) admin_handle_acacia_explain(w http.ResponseWriter, r *http.Request) {
+	if srv.Acacia == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "acacia is not initialized")
+		return
+	}
+	req := adminAcaciaExplainRequest{}
+	err := srv.ReadJSONFromBody(w, r, &req)
+	if err != nil {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Route == "" || req.Request == nil {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, "route and request are required")
+		return
+	}
+	exp, err := srv.Acacia.Explain(r.Context(), req.Route, req.Request)
+	if err != nil {
+		srv.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	env := DataEnvelope{}
+	env["explanation"] = exp
+	srv.adminWriteJSON(w, r, env)
+}
 
+// Reloads every policy from the security policy directory. If any policy fails to load, the current policies are kept.
+func (srv *AppServer) admin_handle_acacia_reload(w http.ResponseWriter, r *http.Request) {
+	if srv.Acacia == nil {
+		srv.ErrorResponse(w, r, http.StatusConflict, "acacia is not initialized")
+		return
+	}
+	if srv.Config.SecurityPolicyDir == "" {
+		srv.ErrorResponse(w, r, http.StatusConflict, "no security policy directory is configured")
+		return
+	}
+	err := srv.Acacia.ReloadAllFrom(srv.Config.SecurityPolicyDir)
+	if err != nil {
+		srv.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	logging.LogToDeck(r.Context(), "info", "ADMIN", "info", "reloaded acacia policies")
+	env := DataEnvelope{}
+	env["generation"] = srv.Acacia.Generation()
+	env["policies"] = len(srv.Acacia.Policies())
+	srv.adminWriteJSON(w, r, env)
 }
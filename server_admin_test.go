@@ -0,0 +1,106 @@
+package taproot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/highgrav/taproot/cron"
+)
+
+// Creates an app server with just enough set up to serve admin calls, and its admin handler
+func newTestAdminServer(t *testing.T, token string, cidrs []string) (*AppServer, http.Handler) {
+	t.Helper()
+	srv := &AppServer{
+		Config:  ServerConfig{AdminToken: token},
+		Server:  NewWebServer(nil, HttpConfig{}),
+		CronHub: cron.New(),
+	}
+	t.Cleanup(srv.CronHub.Stop)
+	srv.state.setState(SERVER_STATE_RUNNING)
+	admin := srv.NewAdminServer(HttpConfig{IPFilter: IPFilterConfig{AllowedCidrs: cidrs}})
+	return srv, admin.Handler
+}
+
+// Makes an admin call from an address with a bearer token (if any), returning the status and decoded body
+func adminCall(t *testing.T, h http.Handler, method string, path string, remoteAddr string, token string) (int, DataEnvelope) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, nil)
+	r.RemoteAddr = remoteAddr
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	env := DataEnvelope{}
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("%s %s: could not decode response %q: %v", method, path, w.Body.String(), err)
+	}
+	return w.Code, env
+}
+
+func TestAdminAuth(t *testing.T) {
+	_, h := newTestAdminServer(t, "secret", nil)
+	if code, _ := adminCall(t, h, http.MethodGet, "/server", "127.0.0.1:5000", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected a call without a token to be rejected, got %d", code)
+	}
+	if code, _ := adminCall(t, h, http.MethodGet, "/server", "127.0.0.1:5000", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected a call with the wrong token to be rejected, got %d", code)
+	}
+	if code, env := adminCall(t, h, http.MethodGet, "/server", "127.0.0.1:5000", "secret"); code != http.StatusOK || env["state"] != "running" {
+		t.Errorf("expected a call with the token to succeed, got %d %v", code, env)
+	}
+	if code, _ := adminCall(t, h, http.MethodGet, "/server", "[::1]:5000", "secret"); code != http.StatusOK {
+		t.Errorf("expected a call from IPv6 loopback to succeed, got %d", code)
+	}
+
+	// with no CIDRs configured, only loopback addresses are allowed, whatever the token
+	if code, _ := adminCall(t, h, http.MethodGet, "/server", "192.0.2.1:5000", "secret"); code != http.StatusForbidden {
+		t.Errorf("expected a call from a non-loopback address to be forbidden, got %d", code)
+	}
+
+	// with no token configured, every call is rejected
+	_, h = newTestAdminServer(t, "", nil)
+	for _, token := range []string{"", "secret"} {
+		if code, _ := adminCall(t, h, http.MethodGet, "/server", "127.0.0.1:5000", token); code != http.StatusUnauthorized {
+			t.Errorf("expected a call to be rejected with no token configured, got %d", code)
+		}
+	}
+
+	// configured CIDRs replace the loopback default
+	_, h = newTestAdminServer(t, "secret", []string{"192.0.2.0/24"})
+	if code, _ := adminCall(t, h, http.MethodGet, "/server", "192.0.2.1:5000", "secret"); code != http.StatusOK {
+		t.Errorf("expected a call from an allowed CIDR to succeed, got %d", code)
+	}
+	if code, _ := adminCall(t, h, http.MethodGet, "/server", "127.0.0.1:5000", "secret"); code != http.StatusForbidden {
+		t.Errorf("expected loopback to be forbidden when it isn't in the allowed CIDRs, got %d", code)
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	srv, h := newTestAdminServer(t, "secret", nil)
+	call := func(method string, path string) DataEnvelope {
+		t.Helper()
+		code, env := adminCall(t, h, method, path, "127.0.0.1:5000", "secret")
+		if code != http.StatusOK {
+			t.Fatalf("%s %s: unexpected status %d: %v", method, path, code, env)
+		}
+		return env
+	}
+
+	if env := call(http.MethodPost, "/server/drain"); env["state"] != "closing" || !srv.CronHub.IsPaused() {
+		t.Fatalf("expected draining to close the server and pause cron, got %v", env)
+	}
+	if env := call(http.MethodPost, "/server/resume"); env["state"] != "running" || srv.CronHub.IsPaused() {
+		t.Fatalf("expected resuming to run the server and cron, got %v", env)
+	}
+
+	// cron paused before draining stays paused on resume
+	call(http.MethodPost, "/cron/pause")
+	call(http.MethodPost, "/server/drain")
+	call(http.MethodPost, "/server/resume")
+	if env := call(http.MethodGet, "/server"); env["state"] != "running" || env["cronPaused"] != true {
+		t.Fatalf("expected cron to stay paused after resuming, got %v", env)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"expvar"
 	"github.com/highgrav/taproot/common"
 	"github.com/highgrav/taproot/logging"
+	"github.com/highgrav/taproot/sse"
+	"github.com/highgrav/taproot/websock"
 	"net/http"
 	"net/http/pprof"
 	"runtime"
@@ -17,6 +19,10 @@ func (srv *AppServer) NewMetricsServer(cfg HttpConfig, usePprof bool) *WebServer
 	ws.Router.HandlerFunc(http.MethodGet, "/sse", srv.metrics_handle_sse)
 	ws.Router.HandlerFunc(http.MethodGet, "/ws", srv.metrics_handle_ws)
 	ws.Router.HandlerFunc(http.MethodGet, "/stats", srv.metrics_handle_path)
+	ws.Router.HandlerFunc(http.MethodGet, "/js", srv.metrics_handle_js)
+	ws.Router.HandlerFunc(http.MethodGet, "/work", srv.metrics_handle_workers)
+	ws.Router.HandlerFunc(http.MethodGet, "/cron", srv.metrics_handle_cron)
+	ws.Router.HandlerFunc(http.MethodGet, "/messages", srv.metrics_handle_messages)
 	ws.Router.HandlerFunc(http.MethodGet, "/", srv.metrics_handle_getpaths)
 
 	if usePprof {
@@ -41,14 +47,15 @@ func (srv *AppServer) NewMetricsServer(cfg HttpConfig, usePprof bool) *WebServer
 	return ws
 }
 
+// Returns connection, sent, dropped, and queue depth counts for each SSE hub
 func (srv *AppServer) metrics_handle_sse(w http.ResponseWriter, r *http.Request) {
 	if srv.SSEHubs == nil {
 		srv.ErrorResponse(w, r, http.StatusOK, "No SSE hubs defined")
 		return
 	}
-	st := make(map[string]int32)
+	st := make(map[string]sse.SSEMetricsReport)
 	for key, val := range srv.SSEHubs {
-		st[key] = val.TotalConns
+		st[key] = val.MetricsReport()
 	}
 	env := DataEnvelope{}
 	env["ok"] = true
@@ -59,14 +66,15 @@ func (srv *AppServer) metrics_handle_sse(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// Returns connection, sent, dropped, and queue depth counts for each WebSocket hub
 func (srv *AppServer) metrics_handle_ws(w http.ResponseWriter, r *http.Request) {
 	if srv.WSHubs == nil {
 		srv.ErrorResponse(w, r, http.StatusOK, "No WS hubs defined")
 		return
 	}
-	st := make(map[string]int32)
+	st := make(map[string]websock.WSMetricsReport)
 	for key, val := range srv.WSHubs {
-		st[key] = val.TotalConns
+		st[key] = val.MetricsReport()
 	}
 	env := DataEnvelope{}
 	env["ok"] = true
@@ -174,16 +182,78 @@ func (srv *AppServer) metrics_handle_path(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// Returns queued, running, succeeded, and failed counts for the work queue
 func (srv *AppServer) metrics_handle_workers(w http.ResponseWriter, r *http.Request) {
-
+	if srv.WorkHub == nil {
+		srv.ErrorResponse(w, r, http.StatusOK, "No work hub defined")
+		return
+	}
+	env := DataEnvelope{}
+	env["ok"] = true
+	env["stats"] = srv.WorkHub.MetricsReport()
+	err := srv.WriteJSON(w, true, 200, env, nil)
+	if err != nil {
+		logging.LogToDeck(r.Context(), "error", "METRICS", "error", "metrics server work stats: "+err.Error())
+	}
 }
 
+// Returns running, succeeded, failed, and skipped counts for cron jobs
 func (srv *AppServer) metrics_handle_cron(w http.ResponseWriter, r *http.Request) {
+	if srv.CronHub == nil {
+		srv.ErrorResponse(w, r, http.StatusOK, "No cron hub defined")
+		return
+	}
+	env := DataEnvelope{}
+	env["ok"] = true
+	env["stats"] = srv.CronHub.Metrics.Snapshot()
+	err := srv.WriteJSON(w, true, 200, env, nil)
+	if err != nil {
+		logging.LogToDeck(r.Context(), "error", "METRICS", "error", "metrics server cron stats: "+err.Error())
+	}
+}
 
+// Returns counts of hub writes relayed to and from other server instances
+func (srv *AppServer) metrics_handle_messages(w http.ResponseWriter, r *http.Request) {
+	if srv.MessageBus == nil {
+		srv.ErrorResponse(w, r, http.StatusOK, "No message bus defined")
+		return
+	}
+	env := DataEnvelope{}
+	env["ok"] = true
+	env["instance"] = srv.MessageBus.InstanceID
+	env["stats"] = srv.MessageBus.Metrics.Snapshot()
+	err := srv.WriteJSON(w, true, 200, env, nil)
+	if err != nil {
+		logging.LogToDeck(r.Context(), "error", "METRICS", "error", "metrics server message stats: "+err.Error())
+	}
 }
 
+// Returns call and latency metrics for server-side scripts, or for a single script if the script query parameter is set
 func (srv *AppServer) metrics_handle_js(w http.ResponseWriter, r *http.Request) {
+	if srv.js == nil {
+		srv.ErrorResponse(w, r, http.StatusOK, "No JS manager defined")
+		return
+	}
+	env := DataEnvelope{}
+	env["ok"] = true
+	env["pool"] = srv.js.Pool.Stats()
 
+	script := r.URL.Query().Get("script")
+	if script == "" {
+		env["scripts"] = srv.js.Metrics.All()
+	} else {
+		st, ok := srv.js.Metrics.Get(script)
+		if !ok {
+			srv.ErrorResponse(w, r, 410, "script '"+script+"' has no metrics")
+			return
+		}
+		env["stats"] = st
+		env["histogram"] = st.LatencyStats.MakeHistogram().Xile(20)
+	}
+	err := srv.WriteJSON(w, true, 200, env, nil)
+	if err != nil {
+		logging.LogToDeck(r.Context(), "error", "METRICS", "error", "metrics server js stats: "+err.Error())
+	}
 }
 
 func (srv *AppServer) metrics_handle_acacia(w http.ResponseWriter, r *http.Request) {
@@ -14,9 +14,25 @@ const (
 	SERVER_STATE_CLOSED       serverState = 4
 )
 
+func (s serverState) String() string {
+	switch s {
+	case SERVER_STATE_INITIALIZING:
+		return "initializing"
+	case SERVER_STATE_RUNNING:
+		return "running"
+	case SERVER_STATE_CLOSING:
+		return "closing"
+	case SERVER_STATE_CLOSED:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
 type serverStateManager struct {
 	sync.Mutex
-	currentState serverState
+	currentState          serverState
+	cronPausedBeforeDrain bool // whether cron was paused when the server started draining, to restore on resume
 }
 
 func (s *serverStateManager) setState(state serverState) {
@@ -24,3 +40,9 @@ func (s *serverStateManager) setState(state serverState) {
 	s.currentState = state
 	s.Unlock()
 }
+
+func (s *serverStateManager) getState() serverState {
+	s.Lock()
+	defer s.Unlock()
+	return s.currentState
+}
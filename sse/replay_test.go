@@ -0,0 +1,100 @@
+package sse
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func eventIDs(evts []SSEEvent) string {
+	ids := ""
+	for _, evt := range evts {
+		ids += evt.ID + ","
+	}
+	return ids
+}
+
+func TestMemoryReplayLog(t *testing.T) {
+	ml := NewMemoryReplayLog(ReplayRetention{MaxEvents: 4})
+	ml.Append(ReplayEntry{ClientIDs: []string{"a"}, Event: SSEEvent{ID: "1"}})
+	ml.Append(ReplayEntry{ClientIDs: []string{"b"}, Event: SSEEvent{ID: "2"}})
+	ml.Append(ReplayEntry{All: true, Event: SSEEvent{ID: "3"}})
+	ml.Append(ReplayEntry{ClientIDs: []string{"a", "b"}, Event: SSEEvent{ID: "4"}})
+
+	evts, _ := ml.Since("a", nil, "1")
+	if ids := eventIDs(evts); ids != "3,4," {
+		t.Fatalf("unexpected events %s", ids)
+	}
+	// an event the client never saw isn't a place to resume from
+	evts, _ = ml.Since("a", nil, "2")
+	if ids := eventIDs(evts); ids != "1,3,4," {
+		t.Fatalf("unexpected events %s", ids)
+	}
+	ml.Append(ReplayEntry{ClientIDs: []string{"b"}, Event: SSEEvent{ID: "5"}})
+	evts, _ = ml.Since("a", nil, "1")
+	if ids := eventIDs(evts); ids != "3,4," {
+		t.Fatalf("unexpected events after pruning %s", ids)
+	}
+
+	aged := NewMemoryReplayLog(ReplayRetention{MaxAge: time.Minute})
+	aged.Append(ReplayEntry{All: true, Time: time.Now().Add(-time.Hour), Event: SSEEvent{ID: "old"}})
+	aged.Append(ReplayEntry{All: true, Event: SSEEvent{ID: "new"}})
+	evts, _ = aged.Since("a", nil, "x")
+	if ids := eventIDs(evts); ids != "new," {
+		t.Fatalf("unexpected events %s", ids)
+	}
+}
+
+func TestFileReplayLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay", "events.jsonl")
+	fl, err := NewFileReplayLog(path, ReplayRetention{MaxEvents: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		err = fl.Append(ReplayEntry{All: true, Event: SSEEvent{ID: strconv.Itoa(i), Data: []string{"x"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fl.Close()
+
+	fl, err = NewFileReplayLog(path, ReplayRetention{MaxEvents: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Close()
+	evts, _ := fl.Since("a", nil, "8")
+	if ids := eventIDs(evts); ids != "9,10," {
+		t.Fatalf("unexpected events %s", ids)
+	}
+	fl.Append(ReplayEntry{All: true, Event: SSEEvent{ID: "11"}})
+	evts, _ = fl.Since("a", nil, "")
+	if ids := eventIDs(evts); ids != "9,10,11," {
+		t.Fatalf("unexpected events %s", ids)
+	}
+}
+
+func TestHubReplay(t *testing.T) {
+	hub := New("test")
+	hub.SetReplayLog(NewMemoryReplayLog(ReplayRetention{}))
+	hub.WriteOne("a", SSEEvent{ID: "1"})
+	hub.WriteAll(SSEEvent{ID: "2"})
+	hub.WriteOne("a", SSEEvent{EventType: "no-id"})
+	hub.WriteMany([]string{"b"}, SSEEvent{ID: "3"})
+	hub.WriteOne("a", SSEEvent{ID: "4"})
+
+	ch := make(chan SSEEvent, 1)
+	missed := hub.AddClientSince("a", "1", ch)
+	if ids := eventIDs(missed); ids != "2,4," {
+		t.Fatalf("unexpected events %s", ids)
+	}
+	hub.WriteOne("a", SSEEvent{ID: "5"})
+	if evt := <-ch; evt.ID != "5" {
+		t.Fatalf("unexpected live event %s", evt.ID)
+	}
+	if missed := hub.AddClientSince("b", "", make(chan SSEEvent)); len(missed) != 0 {
+		t.Fatalf("expected no events without a last event ID, got %s", eventIDs(missed))
+	}
+}
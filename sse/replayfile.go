@@ -0,0 +1,143 @@
+package sse
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+A ReplayLog that also appends events to a file, one JSON entry per line, so they can be replayed to clients that
+reconnect after a restart. The file is rewritten with only the retained events whenever it grows to twice the
+retained count.
+*/
+type FileReplayLog struct {
+	mu    sync.Mutex
+	mem   *MemoryReplayLog
+	path  string
+	file  *os.File
+	lines int
+}
+
+// Creates a file replay log, loading any events still retained from the file
+func NewFileReplayLog(path string, retention ReplayRetention) (*FileReplayLog, error) {
+	fl := &FileReplayLog{
+		mem:  NewMemoryReplayLog(retention),
+		path: path,
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			entry := ReplayEntry{}
+			// skips a line left partly written by a crash
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				fl.mem.add(entry)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = fl.compact()
+	if err != nil {
+		return nil, err
+	}
+	return fl, nil
+}
+
+func (fl *FileReplayLog) Append(entry ReplayEntry) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.file == nil {
+		return os.ErrClosed
+	}
+	fl.mem.mu.Lock()
+	entry = fl.mem.add(entry)
+	fl.mem.mu.Unlock()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = fl.file.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	fl.lines++
+	if fl.lines >= 2*fl.mem.retention.MaxEvents {
+		return fl.compact()
+	}
+	return nil
+}
+
+func (fl *FileReplayLog) Since(clientId string, topics []string, lastEventId string) ([]SSEEvent, error) {
+	return fl.mem.Since(clientId, topics, lastEventId)
+}
+
+// Rewrites the file with only the retained entries, and reopens it for appending
+func (fl *FileReplayLog) compact() error {
+	if fl.file != nil {
+		fl.file.Close()
+		fl.file = nil
+	}
+	entries := fl.mem.list()
+	tmp := fl.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		return err
+	}
+	// write and rename, so a crash never leaves a partial file
+	err = os.Rename(tmp, fl.path)
+	if err != nil {
+		return err
+	}
+	fl.file, err = os.OpenFile(fl.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fl.lines = len(entries)
+	return nil
+}
+
+// Closes the file; events can still be replayed from memory, but no more can be appended
+func (fl *FileReplayLog) Close() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.file == nil {
+		return nil
+	}
+	err := fl.file.Close()
+	fl.file = nil
+	return err
+}
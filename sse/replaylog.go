@@ -0,0 +1,140 @@
+package sse
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of events a replay log keeps, unless configured otherwise
+const SSE_DEFAULT_REPLAY_EVENTS int = 1000
+
+/*
+A ReplayLog keeps recent events written to an SSEHub, so that a client that reconnects with a Last-Event-ID header can
+be sent the events it missed. Only events with an ID are logged, since a client can't report having seen any others.
+*/
+type ReplayLog interface {
+	Append(entry ReplayEntry) error
+	// Returns the events for a client, or published to the topics it subscribes to, written after the one with the given
+	// ID, oldest first. If the ID is no longer in the log, every such event that's still retained is returned.
+	Since(clientId string, topics []string, lastEventId string) ([]SSEEvent, error)
+}
+
+// How much a replay log keeps. Events beyond either limit are discarded; a zero MaxAge keeps events of any age.
+type ReplayRetention struct {
+	MaxEvents int
+	MaxAge    time.Duration
+}
+
+// An event written to a hub, and who it was written to
+type ReplayEntry struct {
+	Seq       uint64
+	Time      time.Time
+	ClientIDs []string // The clients the event was written to, if it wasn't written to all of them
+	All       bool
+	Topic     string // The topic the event was published to, if any
+	Event     SSEEvent
+}
+
+func (re ReplayEntry) isFor(clientId string, topics []string) bool {
+	if re.All {
+		return true
+	}
+	if re.Topic != "" {
+		for _, pattern := range topics {
+			if TopicMatches(pattern, re.Topic) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, id := range re.ClientIDs {
+		if id == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+// A ReplayLog that keeps events in memory, so nothing can be replayed after a restart
+type MemoryReplayLog struct {
+	mu        sync.Mutex
+	retention ReplayRetention
+	entries   []ReplayEntry
+	seq       uint64
+}
+
+func NewMemoryReplayLog(retention ReplayRetention) *MemoryReplayLog {
+	if retention.MaxEvents < 1 {
+		retention.MaxEvents = SSE_DEFAULT_REPLAY_EVENTS
+	}
+	return &MemoryReplayLog{
+		retention: retention,
+		entries:   make([]ReplayEntry, 0),
+	}
+}
+
+func (ml *MemoryReplayLog) Append(entry ReplayEntry) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.add(entry)
+	return nil
+}
+
+// Adds an entry, numbering and timestamping it if needed, then discards whatever is past retention
+func (ml *MemoryReplayLog) add(entry ReplayEntry) ReplayEntry {
+	if entry.Seq <= ml.seq {
+		entry.Seq = ml.seq + 1
+	}
+	ml.seq = entry.Seq
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	ml.entries = append(ml.entries, entry)
+	ml.prune()
+	return entry
+}
+
+func (ml *MemoryReplayLog) prune() {
+	drop := len(ml.entries) - ml.retention.MaxEvents
+	if drop < 0 {
+		drop = 0
+	}
+	if ml.retention.MaxAge > 0 {
+		cutoff := time.Now().Add(-ml.retention.MaxAge)
+		for drop < len(ml.entries) && ml.entries[drop].Time.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop > 0 {
+		// copy, so the discarded entries can be collected
+		ml.entries = append(make([]ReplayEntry, 0, len(ml.entries)-drop), ml.entries[drop:]...)
+	}
+}
+
+func (ml *MemoryReplayLog) Since(clientId string, topics []string, lastEventId string) ([]SSEEvent, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.prune()
+	start := 0
+	for i := len(ml.entries) - 1; i >= 0; i-- {
+		if ml.entries[i].Event.ID == lastEventId && ml.entries[i].isFor(clientId, topics) {
+			start = i + 1
+			break
+		}
+	}
+	evts := make([]SSEEvent, 0)
+	for _, entry := range ml.entries[start:] {
+		if entry.isFor(clientId, topics) {
+			evts = append(evts, entry.Event)
+		}
+	}
+	return evts, nil
+}
+
+// Returns the retained entries, oldest first
+func (ml *MemoryReplayLog) list() []ReplayEntry {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.prune()
+	return append(make([]ReplayEntry, 0, len(ml.entries)), ml.entries...)
+}
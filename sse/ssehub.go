@@ -1,103 +1,517 @@
 package sse
 
-import "sync/atomic"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/highgrav/taproot/logging"
+	"github.com/highgrav/taproot/messages"
+	"sync/atomic"
+	"time"
+)
 
 const SSE_MIMETYPE string = "text/event-stream"
 const SSE_LAST_EVENT_SEEN_HEADER string = "Last-Event-ID"
 
+var ErrClientNotFound = errors.New("client is not connected to the hub")
+
 type SSEHub struct {
 	Name    string
 	Metrics *SSEMetrics
 	// We assume that a constant key (ideally user ID, persistent session ID, etc.) is used here
-	conns      map[string][]chan SSEEvent
-	acts       chan func() // prevents logical conflicts by single-threading operations
+	conns      map[string][]*sseClient
+	byChan     map[chan SSEEvent]*sseClient
+	topics     map[string]map[*sseClient]bool // subscribers to each topic, not counting wildcard subscriptions
+	wildcards  map[*sseClient][]string        // each client's wildcard subscriptions
+	acts       chan func()                    // prevents logical conflicts by single-threading operations
+	replay     ReplayLog
+	opts       SSEHubOptions
+	bus        atomic.Pointer[messages.MessageBus]
 	TotalConns int32
 }
 
+/*
+A connection's queue of events. Writes to the hub only ever add to queues, so a slow client can't hold up the others;
+each connection has a goroutine that moves events from its queue to the channel the client was added with.
+*/
+type sseClient struct {
+	id     string
+	topics []string
+	out    chan SSEEvent
+	queue  chan SSEEvent
+	done   chan bool
+}
+
 func (hub *SSEHub) runInternalActions() {
 	for act := range hub.acts {
 		act()
 	} // infinite loop
 }
 
-func (hub *SSEHub) AddClient(clientId string, clientChan chan SSEEvent) {
-	hub.acts <- func() {
-		if _, ok := hub.conns[clientId]; !ok {
-			hub.conns[clientId] = make([]chan SSEEvent, 0)
+// Returns the options the hub was created with, with defaults filled in
+func (hub *SSEHub) Options() SSEHubOptions {
+	return hub.opts
+}
+
+// Returns how often handlers should send a keep-alive comment, or zero for never
+func (hub *SSEHub) HeartbeatInterval() time.Duration {
+	if hub.opts.Heartbeat < 0 {
+		return 0
+	}
+	return hub.opts.Heartbeat
+}
+
+// Registers a client's channel, subscribed to the given topics. Must be called from an action.
+func (hub *SSEHub) addClient(clientId string, topics []string, clientChan chan SSEEvent) {
+	c := &sseClient{
+		id:     clientId,
+		topics: make([]string, 0),
+		out:    clientChan,
+		queue:  make(chan SSEEvent, hub.opts.BufferSize),
+		done:   make(chan bool),
+	}
+	hub.conns[clientId] = append(hub.conns[clientId], c)
+	hub.byChan[clientChan] = c
+	hub.subscribe(c, topics)
+	atomic.AddInt32(&hub.TotalConns, 1)
+	go hub.forward(c)
+}
+
+// Subscribes a connection to topics it isn't already subscribed to. Must be called from an action.
+func (hub *SSEHub) subscribe(c *sseClient, topics []string) {
+	for _, topic := range topics {
+		if hasTopic(c.topics, topic) {
+			continue
+		}
+		c.topics = append(c.topics, topic)
+		if IsTopicPattern(topic) {
+			hub.wildcards[c] = append(hub.wildcards[c], topic)
+			continue
 		}
-		hub.conns[clientId] = append(hub.conns[clientId], clientChan)
-		atomic.AddInt32(&hub.TotalConns, 1)
+		if _, ok := hub.topics[topic]; !ok {
+			hub.topics[topic] = make(map[*sseClient]bool)
+		}
+		hub.topics[topic][c] = true
 	}
 }
 
-func (hub *SSEHub) RemoveClient(clientId string, clientChan chan SSEEvent) {
-	if _, ok := hub.conns[clientId]; !ok {
-		close(clientChan)
+// Unsubscribes a connection from topics. Must be called from an action.
+func (hub *SSEHub) unsubscribe(c *sseClient, topics []string) {
+	for _, topic := range topics {
+		if !hasTopic(c.topics, topic) {
+			continue
+		}
+		c.topics = removeTopic(c.topics, topic)
+		if IsTopicPattern(topic) {
+			hub.wildcards[c] = removeTopic(hub.wildcards[c], topic)
+			if len(hub.wildcards[c]) == 0 {
+				delete(hub.wildcards, c)
+			}
+			continue
+		}
+		delete(hub.topics[topic], c)
+		if len(hub.topics[topic]) == 0 {
+			delete(hub.topics, topic)
+		}
+	}
+}
+
+func hasTopic(topics []string, topic string) bool {
+	for _, t := range topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+func removeTopic(topics []string, topic string) []string {
+	res := make([]string, 0, len(topics))
+	for _, t := range topics {
+		if t != topic {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// Moves events from a connection's queue to its channel, and closes the channel once the connection is removed
+func (hub *SSEHub) forward(c *sseClient) {
+	defer close(c.out)
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.queue:
+			select {
+			case c.out <- msg:
+				atomic.AddInt64(&hub.Metrics.Sent, 1)
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// Removes a connection from the hub. Must be called from an action.
+func (hub *SSEHub) removeClient(c *sseClient) bool {
+	clientId := c.id
+	tmpChs := make([]*sseClient, 0)
+	found := false
+	for _, v := range hub.conns[clientId] {
+		if v != c {
+			tmpChs = append(tmpChs, v)
+		} else {
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+	// clean up if necessary
+	if len(tmpChs) == 0 {
+		delete(hub.conns, clientId)
+	} else {
+		hub.conns[clientId] = tmpChs
+	}
+	hub.unsubscribe(c, c.topics)
+	delete(hub.byChan, c.out)
+	atomic.AddInt32(&hub.TotalConns, -1)
+	close(c.done)
+	return true
+}
+
+// Queues an event for a connection, applying the hub's overflow policy if its queue is full. Must be called from an action.
+func (hub *SSEHub) deliver(c *sseClient, msg SSEEvent) {
+	select {
+	case c.queue <- msg:
 		return
+	default:
 	}
-	go func() {
-		for range clientChan {
+	switch hub.opts.Overflow {
+	case OVERFLOW_DROP_NEWEST:
+		atomic.AddInt64(&hub.Metrics.Dropped, 1)
+	case OVERFLOW_DISCONNECT:
+		atomic.AddInt64(&hub.Metrics.Dropped, int64(len(c.queue))+1)
+		atomic.AddInt64(&hub.Metrics.Disconnected, 1)
+		hub.removeClient(c)
+		logging.LogToDeck(context.Background(), "warn", "SSE", "warn", "disconnected slow client "+c.id+" from hub "+hub.Name)
+	default:
+		select {
+		case <-c.queue:
+			atomic.AddInt64(&hub.Metrics.Dropped, 1)
+		default:
+		}
+		select {
+		case c.queue <- msg:
+		default:
+			atomic.AddInt64(&hub.Metrics.Dropped, 1)
 		}
-	}()
+	}
+}
+
+/*
+Adds a client's channel to the hub. Events written to the client are queued (up to the hub's buffer size) and passed
+to the channel in order; the channel is closed when the client is removed, including when the hub disconnects a client
+that falls too far behind.
+*/
+func (hub *SSEHub) AddClient(clientId string, clientChan chan SSEEvent) {
 	hub.acts <- func() {
-		tmpChs := make([]chan SSEEvent, 0)
-		for _, c := range hub.conns[clientId] {
-			if c != clientChan {
-				tmpChs = append(tmpChs, c)
+		hub.addClient(clientId, nil, clientChan)
+	}
+}
+
+/*
+Adds a client that's reconnecting after seeing the event with the given ID (from its Last-Event-ID header), and returns
+the events written to it since then, which should be sent before any that arrive on the channel. Returns no events if
+the hub has no replay log or the ID is empty.
+*/
+func (hub *SSEHub) AddClientSince(clientId string, lastEventId string, clientChan chan SSEEvent) []SSEEvent {
+	evts, _ := hub.AddClientWithTopics(clientId, nil, lastEventId, clientChan)
+	return evts
+}
+
+/*
+Adds a client subscribed to a set of topics (see CheckTopic() for what's allowed), returning any events it missed since
+the one with the given ID, as with AddClientSince(). Checking that the client may subscribe to the topics is up to the
+caller.
+*/
+func (hub *SSEHub) AddClientWithTopics(clientId string, topics []string, lastEventId string, clientChan chan SSEEvent) ([]SSEEvent, error) {
+	for _, topic := range topics {
+		if err := CheckTopic(topic, true); err != nil {
+			return nil, err
+		}
+	}
+	res := make(chan []SSEEvent, 1)
+	hub.acts <- func() {
+		// registering in the same action as reading the log means no event is missed or sent twice
+		evts := make([]SSEEvent, 0)
+		if hub.replay != nil && lastEventId != "" {
+			missed, err := hub.replay.Since(clientId, topics, lastEventId)
+			if err != nil {
+				logging.LogToDeck(context.Background(), "error", "SSE", "error", "could not read replay log of hub "+hub.Name+": "+err.Error())
 			} else {
-				atomic.AddInt32(&hub.TotalConns, -1)
+				evts = missed
 			}
 		}
-		// clean up if necessary
-		if len(tmpChs) == 0 {
-			delete(hub.conns, clientId)
-		} else {
-			hub.conns[clientId] = tmpChs
+		hub.addClient(clientId, topics, clientChan)
+		res <- evts
+	}
+	return <-res, nil
+}
+
+// Subscribes a client's channel to more topics. Checking that the client may subscribe to them is up to the caller.
+func (hub *SSEHub) Subscribe(clientChan chan SSEEvent, topics ...string) error {
+	for _, topic := range topics {
+		if err := CheckTopic(topic, true); err != nil {
+			return err
 		}
-		close(clientChan)
 	}
+	res := make(chan error, 1)
+	hub.acts <- func() {
+		c, ok := hub.byChan[clientChan]
+		if !ok {
+			res <- ErrClientNotFound
+			return
+		}
+		hub.subscribe(c, topics)
+		res <- nil
+	}
+	return <-res
 }
 
-func (hub *SSEHub) WriteOne(clientId string, msg SSEEvent) {
+func (hub *SSEHub) Unsubscribe(clientChan chan SSEEvent, topics ...string) {
 	hub.acts <- func() {
-		chs, ok := hub.conns[clientId]
-		if ok {
-			for _, ch := range chs {
-				ch <- msg
+		if c, ok := hub.byChan[clientChan]; ok {
+			hub.unsubscribe(c, topics)
+		}
+	}
+}
+
+// Returns the topics a client's channel is subscribed to, including wildcard subscriptions
+func (hub *SSEHub) Subscriptions(clientChan chan SSEEvent) []string {
+	res := make(chan []string, 1)
+	hub.acts <- func() {
+		topics := make([]string, 0)
+		if c, ok := hub.byChan[clientChan]; ok {
+			topics = append(topics, c.topics...)
+		}
+		res <- topics
+	}
+	return <-res
+}
+
+/*
+Writes an event to every connection subscribed to a topic, either directly or through a wildcard. The topic can't
+itself have wildcards. A connection subscribed more than once (say, to "orders:123" and "orders:*") gets the event once.
+*/
+func (hub *SSEHub) Publish(topic string, msg SSEEvent) error {
+	if err := CheckTopic(topic, false); err != nil {
+		return err
+	}
+	hub.publish(topic, msg)
+	hub.relay(messages.MSG_OP_PUBLISH, []string{topic}, msg)
+	return nil
+}
+
+func (hub *SSEHub) publish(topic string, msg SSEEvent) {
+	hub.acts <- func() {
+		hub.logEvent(ReplayEntry{Topic: topic, Event: msg})
+		atomic.AddInt64(&hub.Metrics.Published, 1)
+		sent := make(map[*sseClient]bool)
+		for c := range hub.topics[topic] {
+			sent[c] = true
+			hub.deliver(c, msg)
+		}
+		for c, patterns := range hub.wildcards {
+			if sent[c] {
+				continue
+			}
+			for _, pattern := range patterns {
+				if TopicMatches(pattern, topic) {
+					hub.deliver(c, msg)
+					break
+				}
 			}
 		}
 	}
 }
 
+// Sets the log that events with an ID are kept in, so they can be replayed to reconnecting clients. Nil turns replay off.
+func (hub *SSEHub) SetReplayLog(log ReplayLog) {
+	hub.acts <- func() {
+		hub.replay = log
+	}
+}
+
+// Keeps an event in the replay log, if there is one and the event has an ID. Must be called from an action.
+func (hub *SSEHub) logEvent(entry ReplayEntry) {
+	if hub.replay == nil || entry.Event.ID == "" {
+		return
+	}
+	err := hub.replay.Append(entry)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "SSE", "error", "could not log event "+entry.Event.ID+" in hub "+hub.Name+": "+err.Error())
+	}
+}
+
+// Removes a client's channel from the hub and closes it, unless the hub has already done so
+func (hub *SSEHub) RemoveClient(clientId string, clientChan chan SSEEvent) {
+	hub.acts <- func() {
+		if c, ok := hub.byChan[clientChan]; ok && c.id == clientId {
+			hub.removeClient(c)
+		}
+	}
+}
+
+func (hub *SSEHub) WriteOne(clientId string, msg SSEEvent) {
+	hub.writeOne(clientId, msg)
+	hub.relay(messages.MSG_OP_ONE, []string{clientId}, msg)
+}
+
+func (hub *SSEHub) writeOne(clientId string, msg SSEEvent) {
+	hub.acts <- func() {
+		hub.logEvent(ReplayEntry{ClientIDs: []string{clientId}, Event: msg})
+		for _, c := range hub.conns[clientId] {
+			hub.deliver(c, msg)
+		}
+	}
+}
+
 func (broker *SSEHub) WriteMany(clientIds []string, msg SSEEvent) {
+	// copies the IDs, since the caller may reuse its slice
+	clientIds = append([]string(nil), clientIds...)
+	broker.writeMany(clientIds, msg)
+	broker.relay(messages.MSG_OP_MANY, clientIds, msg)
+}
+
+func (broker *SSEHub) writeMany(clientIds []string, msg SSEEvent) {
 	broker.acts <- func() {
+		broker.logEvent(ReplayEntry{ClientIDs: clientIds, Event: msg})
 		for _, id := range clientIds {
-			chs, ok := broker.conns[id]
-			if ok {
-				for _, ch := range chs {
-					ch <- msg
-				}
+			for _, c := range broker.conns[id] {
+				broker.deliver(c, msg)
 			}
 		}
 	}
 }
 
 func (broker *SSEHub) WriteAll(msg SSEEvent) {
+	broker.writeAll(msg)
+	broker.relay(messages.MSG_OP_ALL, nil, msg)
+}
+
+func (broker *SSEHub) writeAll(msg SSEEvent) {
 	broker.acts <- func() {
+		broker.logEvent(ReplayEntry{All: true, Event: msg})
 		for _, v := range broker.conns {
 			for _, c := range v {
-				c <- msg
+				broker.deliver(c, msg)
+			}
+		}
+	}
+}
+
+/*
+Relays the hub's writes to the same hub on other server instances through a message bus, and delivers theirs to the
+clients connected here. Events written here and relayed from elsewhere are kept in the hub's replay log alike. Nil
+stops relaying.
+*/
+func (hub *SSEHub) SetMessageBus(bus *messages.MessageBus) {
+	if old := hub.bus.Swap(bus); old != nil && old != bus {
+		old.RemoveTarget(messages.MSG_KIND_SSE, hub.Name)
+	}
+	if bus != nil {
+		bus.AddTarget(messages.MSG_KIND_SSE, hub.Name, hub)
+	}
+}
+
+// Sends a write to the message bus, if there is one
+func (hub *SSEHub) relay(op string, targets []string, msg SSEEvent) {
+	bus := hub.bus.Load()
+	if bus == nil {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "SSE", "error", "could not relay event from hub "+hub.Name+": "+err.Error())
+		return
+	}
+	bus.Send(messages.Message{
+		Kind:    messages.MSG_KIND_SSE,
+		Hub:     hub.Name,
+		Op:      op,
+		Targets: targets,
+		Payload: data,
+	})
+}
+
+// Delivers an event relayed from another instance to the clients connected here, without relaying it again
+func (hub *SSEHub) HandleMessage(msg messages.Message) error {
+	evt := SSEEvent{}
+	err := json.Unmarshal(msg.Payload, &evt)
+	if err != nil {
+		return err
+	}
+	switch msg.Op {
+	case messages.MSG_OP_ONE:
+		for _, id := range msg.Targets {
+			hub.writeOne(id, evt)
+		}
+	case messages.MSG_OP_MANY:
+		hub.writeMany(msg.Targets, evt)
+	case messages.MSG_OP_ALL:
+		hub.writeAll(evt)
+	case messages.MSG_OP_PUBLISH:
+		for _, topic := range msg.Targets {
+			if err := CheckTopic(topic, false); err != nil {
+				return err
+			}
+			hub.publish(topic, evt)
+		}
+	default:
+		return errors.New("unknown message op " + msg.Op)
+	}
+	return nil
+}
+
+// Returns the hub's metrics, along with how many events are queued
+func (hub *SSEHub) MetricsReport() SSEMetricsReport {
+	res := make(chan SSEMetricsReport, 1)
+	hub.acts <- func() {
+		depth, maxDepth := 0, 0
+		for _, v := range hub.conns {
+			for _, c := range v {
+				n := len(c.queue)
+				depth += n
+				if n > maxDepth {
+					maxDepth = n
+				}
 			}
 		}
+		report := hub.Metrics.Snapshot(atomic.LoadInt32(&hub.TotalConns), depth, maxDepth)
+		report.Topics = len(hub.topics)
+		report.WildcardSubscribers = len(hub.wildcards)
+		res <- report
 	}
+	return <-res
 }
 
 func New(name string) *SSEHub {
+	return NewWithOptions(name, SSEHubOptions{})
+}
+
+// Creates a hub with the given queue size, overflow policy and heartbeat; anything not set uses the default
+func NewWithOptions(name string, opts SSEHubOptions) *SSEHub {
 	broker := &SSEHub{
 		Name:       name,
 		Metrics:    &SSEMetrics{},
-		conns:      make(map[string][]chan SSEEvent),
+		conns:      make(map[string][]*sseClient),
+		byChan:     make(map[chan SSEEvent]*sseClient),
+		topics:     make(map[string]map[*sseClient]bool),
+		wildcards:  make(map[*sseClient][]string),
 		acts:       make(chan func()),
+		opts:       opts.normalize(),
 		TotalConns: 0,
 	}
 	go broker.runInternalActions()
@@ -0,0 +1,120 @@
+package sse
+
+import (
+	"github.com/highgrav/taproot/messages"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSlowClient(t *testing.T) {
+	hub := NewWithOptions("test", SSEHubOptions{BufferSize: 2})
+	slow, fast := make(chan SSEEvent), make(chan SSEEvent)
+	hub.AddClient("slow", slow)
+	hub.AddClient("fast", fast)
+	got := make(chan string, 10)
+	go func() {
+		for evt := range fast {
+			got <- evt.ID
+		}
+	}()
+
+	// a client that isn't reading doesn't hold up the hub, or the other clients
+	for i := 1; i <= 5; i++ {
+		hub.WriteAll(SSEEvent{ID: strconv.Itoa(i)})
+		select {
+		case id := <-got:
+			if id != strconv.Itoa(i) {
+				t.Fatalf("expected event %d, got %s", i, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d wasn't delivered", i)
+		}
+	}
+
+	report := hub.MetricsReport()
+	if report.Connections != 2 || report.Dropped < 2 || report.MaxQueueDepth != 2 {
+		t.Fatalf("unexpected metrics %+v", report)
+	}
+	// the slow client gets the newest events
+	last := ""
+	for last != "5" {
+		select {
+		case evt := <-slow:
+			last = evt.ID
+		case <-time.After(time.Second):
+			t.Fatalf("slow client stopped at event %s", last)
+		}
+	}
+	hub.RemoveClient("slow", slow)
+	if _, ok := <-slow; ok {
+		t.Fatal("expected a removed client's channel to be closed")
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	hub := NewWithOptions("test", SSEHubOptions{BufferSize: 1, Overflow: OVERFLOW_DROP_NEWEST})
+	ch := make(chan SSEEvent)
+	hub.AddClient("a", ch)
+	for i := 1; i <= 5; i++ {
+		hub.WriteOne("a", SSEEvent{ID: strconv.Itoa(i)})
+	}
+	if evt := <-ch; evt.ID != "1" {
+		t.Fatalf("expected the oldest event to be kept, got %s", evt.ID)
+	}
+
+	hub = NewWithOptions("test", SSEHubOptions{BufferSize: 1, Overflow: OVERFLOW_DISCONNECT})
+	ch = make(chan SSEEvent)
+	hub.AddClient("a", ch)
+	for i := 1; i <= 5; i++ {
+		hub.WriteOne("a", SSEEvent{ID: strconv.Itoa(i)})
+	}
+	timeout := time.After(time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-ch:
+		case <-timeout:
+			t.Fatal("expected a slow client to be disconnected")
+		}
+	}
+	if report := hub.MetricsReport(); report.Connections != 0 || report.Disconnected != 1 {
+		t.Fatalf("unexpected metrics %+v", report)
+	}
+	// removing a client the hub has already disconnected does nothing
+	hub.RemoveClient("a", ch)
+}
+
+func TestMessageBus(t *testing.T) {
+	// two hubs with the same name on buses sharing a broker stand in for two server instances
+	broker := messages.NewMemoryBroker()
+	hubs := make([]*SSEHub, 0)
+	for i := 0; i < 2; i++ {
+		bus, err := messages.NewMessageBus(broker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { bus.Close() })
+		hub := New("test")
+		hub.SetReplayLog(NewMemoryReplayLog(ReplayRetention{}))
+		hub.SetMessageBus(bus)
+		hubs = append(hubs, hub)
+	}
+	a, b := make(chan SSEEvent, 10), make(chan SSEEvent, 10)
+	hubs[0].AddClient("a", a)
+	hubs[1].AddClientWithTopics("b", []string{"orders:*"}, "", b)
+
+	hubs[0].WriteOne("b", SSEEvent{ID: "1"})
+	hubs[0].Publish("orders:123", SSEEvent{ID: "2"})
+	if ids := receive(t, b) + receive(t, b); ids != "12" {
+		t.Fatalf("unexpected events %s", ids)
+	}
+	hubs[1].WriteOne("a", SSEEvent{ID: "3"})
+	hubs[1].WriteAll(SSEEvent{ID: "4"})
+	if ids := receive(t, a) + receive(t, a) + receive(t, b); ids != "344" {
+		t.Fatalf("unexpected events %s", ids)
+	}
+	// relayed events are replayed by the instance a client reconnects to
+	if missed := hubs[1].AddClientSince("b", "1", make(chan SSEEvent, 1)); eventIDs(missed) != "4," {
+		t.Fatalf("unexpected replayed events %s", eventIDs(missed))
+	}
+}
@@ -1,4 +1,36 @@
 package sse
 
+import "sync/atomic"
+
+// Running counts for an SSEHub, updated atomically
 type SSEMetrics struct {
+	Sent         int64 // Events handed to connections
+	Published    int64 // Events published to topics
+	Dropped      int64 // Events dropped because a connection's queue was full
+	Disconnected int64 // Connections removed because their queue was full
+}
+
+// A snapshot of an SSEHub's metrics
+type SSEMetricsReport struct {
+	Connections         int32 `json:"connections"`
+	Sent                int64 `json:"sent"`
+	Published           int64 `json:"published"`
+	Dropped             int64 `json:"dropped"`
+	Disconnected        int64 `json:"disconnected"`
+	QueueDepth          int   `json:"queueDepth"`          // Events queued across all connections
+	MaxQueueDepth       int   `json:"maxQueueDepth"`       // Events queued for the most backed-up connection
+	Topics              int   `json:"topics"`              // Topics with at least one subscriber, not counting wildcards
+	WildcardSubscribers int   `json:"wildcardSubscribers"` // Connections with wildcard subscriptions
+}
+
+func (m *SSEMetrics) Snapshot(conns int32, depth int, maxDepth int) SSEMetricsReport {
+	return SSEMetricsReport{
+		Connections:   conns,
+		Sent:          atomic.LoadInt64(&m.Sent),
+		Published:     atomic.LoadInt64(&m.Published),
+		Dropped:       atomic.LoadInt64(&m.Dropped),
+		Disconnected:  atomic.LoadInt64(&m.Disconnected),
+		QueueDepth:    depth,
+		MaxQueueDepth: maxDepth,
+	}
 }
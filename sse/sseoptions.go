@@ -0,0 +1,37 @@
+package sse
+
+import "time"
+
+const (
+	SSE_DEFAULT_BUFFER_SIZE int           = 64               // Events queued for each connection, unless configured otherwise
+	SSE_DEFAULT_HEARTBEAT   time.Duration = 30 * time.Second // How often handlers send a keep-alive comment, unless configured otherwise
+)
+
+// What a hub does with an event for a connection whose queue is full
+type OverflowPolicy string
+
+const (
+	OVERFLOW_DROP_OLDEST OverflowPolicy = "drop-oldest" // The oldest queued event is dropped to make room (the default)
+	OVERFLOW_DROP_NEWEST OverflowPolicy = "drop-newest" // The new event is dropped
+	OVERFLOW_DISCONNECT  OverflowPolicy = "disconnect"  // The connection is removed from the hub, and its channel closed
+)
+
+type SSEHubOptions struct {
+	BufferSize int
+	Overflow   OverflowPolicy
+	Heartbeat  time.Duration // Negative for no heartbeat
+}
+
+// Fills in defaults for anything not set
+func (opts SSEHubOptions) normalize() SSEHubOptions {
+	if opts.BufferSize < 1 {
+		opts.BufferSize = SSE_DEFAULT_BUFFER_SIZE
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = OVERFLOW_DROP_OLDEST
+	}
+	if opts.Heartbeat == 0 {
+		opts.Heartbeat = SSE_DEFAULT_HEARTBEAT
+	}
+	return opts
+}
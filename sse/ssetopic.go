@@ -0,0 +1,63 @@
+package sse
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+Topics are names made of segments separated by colons, such as "orders:123" or "chat:room-7". Clients can subscribe to
+a single topic or use wildcards: a "*" segment matches any one segment ("orders:*" matches "orders:123" but not
+"orders:123:items"), and a final "**" segment matches one or more segments ("orders:**" matches both).
+*/
+const (
+	SSE_TOPIC_SEPARATOR         string = ":"
+	SSE_TOPIC_WILDCARD          string = "*"
+	SSE_TOPIC_MULTIPLE_WILDCARD string = "**"
+)
+
+var ErrInvalidTopic = errors.New("invalid topic")
+
+// Checks that a topic is well-formed; wildcards are only allowed in subscriptions
+func CheckTopic(topic string, allowWildcards bool) error {
+	if topic == "" {
+		return ErrInvalidTopic
+	}
+	segs := strings.Split(topic, SSE_TOPIC_SEPARATOR)
+	for i, seg := range segs {
+		if seg == "" {
+			return ErrInvalidTopic
+		}
+		if seg == SSE_TOPIC_WILDCARD || seg == SSE_TOPIC_MULTIPLE_WILDCARD {
+			if !allowWildcards || (seg == SSE_TOPIC_MULTIPLE_WILDCARD && i != len(segs)-1) {
+				return ErrInvalidTopic
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if a topic has wildcards in it
+func IsTopicPattern(topic string) bool {
+	for _, seg := range strings.Split(topic, SSE_TOPIC_SEPARATOR) {
+		if seg == SSE_TOPIC_WILDCARD || seg == SSE_TOPIC_MULTIPLE_WILDCARD {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if a topic is matched by a subscription, which may have wildcards
+func TopicMatches(pattern string, topic string) bool {
+	pats := strings.Split(pattern, SSE_TOPIC_SEPARATOR)
+	segs := strings.Split(topic, SSE_TOPIC_SEPARATOR)
+	for i, pat := range pats {
+		if pat == SSE_TOPIC_MULTIPLE_WILDCARD {
+			return len(segs) > i
+		}
+		if i >= len(segs) || (pat != SSE_TOPIC_WILDCARD && pat != segs[i]) {
+			return false
+		}
+	}
+	return len(pats) == len(segs)
+}
@@ -0,0 +1,102 @@
+package sse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		matches bool
+	}{
+		{"orders:123", "orders:123", true},
+		{"orders:123", "orders:124", false},
+		{"orders:*", "orders:123", true},
+		{"orders:*", "orders:123:items", false},
+		{"orders:*", "orders", false},
+		{"*:123", "orders:123", true},
+		{"orders:**", "orders:123:items", true},
+		{"orders:**", "orders", false},
+	}
+	for _, test := range tests {
+		if TopicMatches(test.pattern, test.topic) != test.matches {
+			t.Errorf("expected %s matching %s to be %v", test.pattern, test.topic, test.matches)
+		}
+	}
+	for _, topic := range []string{"", "orders:", "::", "orders:**:items"} {
+		if CheckTopic(topic, true) == nil {
+			t.Errorf("expected %q to be invalid", topic)
+		}
+	}
+	if CheckTopic("orders:*", false) == nil {
+		t.Error("expected wildcards to be refused")
+	}
+}
+
+func receive(t *testing.T, ch chan SSEEvent) string {
+	t.Helper()
+	select {
+	case evt := <-ch:
+		return evt.ID
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+	}
+	return ""
+}
+
+func TestPublish(t *testing.T) {
+	hub := New("test")
+	hub.SetReplayLog(NewMemoryReplayLog(ReplayRetention{}))
+	exact, wild, both := make(chan SSEEvent, 4), make(chan SSEEvent, 4), make(chan SSEEvent, 4)
+	if _, err := hub.AddClientWithTopics("a", []string{"orders:1"}, "", exact); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.AddClientWithTopics("b", []string{"orders:*"}, "", wild); err != nil {
+		t.Fatal(err)
+	}
+	hub.AddClient("c", both)
+	if err := hub.Subscribe(both, "orders:1", "orders:**"); err != nil {
+		t.Fatal(err)
+	}
+
+	hub.Publish("orders:1", SSEEvent{ID: "1"})
+	hub.Publish("orders:2", SSEEvent{ID: "2"})
+	hub.Publish("orders:2:items", SSEEvent{ID: "3"})
+	if err := hub.Publish("orders:*", SSEEvent{ID: "4"}); err != ErrInvalidTopic {
+		t.Fatalf("expected ErrInvalidTopic, got %v", err)
+	}
+	hub.Unsubscribe(exact, "orders:1")
+	hub.Publish("orders:1", SSEEvent{ID: "5"})
+
+	if id := receive(t, exact); id != "1" {
+		t.Fatalf("unexpected event %s", id)
+	}
+	for _, want := range []string{"1", "2", "5"} {
+		if id := receive(t, wild); id != want {
+			t.Fatalf("expected event %s, got %s", want, id)
+		}
+	}
+	// a connection subscribed twice gets each event once
+	for _, want := range []string{"1", "2", "3", "5"} {
+		if id := receive(t, both); id != want {
+			t.Fatalf("expected event %s, got %s", want, id)
+		}
+	}
+	if topics := hub.Subscriptions(exact); len(topics) != 0 {
+		t.Fatalf("unexpected subscriptions %v", topics)
+	}
+	if report := hub.MetricsReport(); report.Published != 4 || report.Topics != 1 || report.WildcardSubscribers != 2 {
+		t.Fatalf("unexpected metrics %+v", report)
+	}
+
+	// published events are replayed to subscribers
+	missed, _ := hub.AddClientWithTopics("d", []string{"orders:2"}, "1", make(chan SSEEvent))
+	if ids := eventIDs(missed); ids != "2," {
+		t.Fatalf("unexpected replayed events %s", ids)
+	}
+	if err := hub.Subscribe(make(chan SSEEvent), "orders:1"); err != ErrClientNotFound {
+		t.Fatalf("expected ErrClientNotFound, got %v", err)
+	}
+}
@@ -8,3 +8,11 @@ type IWebSocketHandler interface {
 	GetChannels() (wsReader, wsWriter chan WSFrame, err error)
 	Cancel() error
 }
+
+/*
+A handler that also implements IWebSocketConnHandler is given its hub and connection once the connection has been
+added to the hub, so that it can join rooms or write to other connections.
+*/
+type IWebSocketConnHandler interface {
+	Connected(hub *WSHub, wsconn *WSConn)
+}
@@ -3,10 +3,16 @@ package websock
 import (
 	"bufio"
 	"context"
+	"errors"
+	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/highgrav/taproot/authn"
 	"github.com/highgrav/taproot/logging"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type WSConn struct {
@@ -14,13 +20,35 @@ type WSConn struct {
 	User      authn.User
 	Conn      net.Conn
 	Buf       *bufio.ReadWriter
-	closeChan chan bool
-	CloseChan chan bool
+	closeChan chan bool // closed when the connection is asked to close
+	CloseChan chan bool // closed once the connection has stopped writing
 	Reader    chan WSFrame
 	Writer    chan WSFrame
+	state     *wsConnState // shared by copies of the WSConn
+}
+
+type wsConnState struct {
+	opts      WSConnOptions
+	queue     chan WSFrame
+	metrics   atomic.Pointer[WSMetrics]
+	writeMu   sync.Mutex // frames from the writer and pongs from the reader mustn't interleave
+	closeOnce sync.Once
+}
+
+// Counts something in the metrics of the hub the connection belongs to, if any
+func (st *wsConnState) count(fn func(m *WSMetrics)) {
+	if m := st.metrics.Load(); m != nil {
+		fn(m)
+	}
 }
 
 func NewWSConn(id string, user authn.User, conn net.Conn, buf *bufio.ReadWriter) WSConn {
+	return NewWSConnWithOptions(id, user, conn, buf, WSConnOptions{})
+}
+
+// Creates a connection with the given queue size, overflow policy and ping interval; anything not set uses the default
+func NewWSConnWithOptions(id string, user authn.User, conn net.Conn, buf *bufio.ReadWriter, opts WSConnOptions) WSConn {
+	opts = opts.normalize()
 	wsc := WSConn{
 		Key:       id,
 		User:      user,
@@ -30,39 +58,124 @@ func NewWSConn(id string, user authn.User, conn net.Conn, buf *bufio.ReadWriter)
 		CloseChan: make(chan bool),
 		Reader:    make(chan WSFrame),
 		Writer:    make(chan WSFrame),
+		state: &wsConnState{
+			opts:  opts,
+			queue: make(chan WSFrame, opts.BufferSize),
+		},
 	}
 	go wsc.process()
 	return wsc
 }
 
+// Asks the connection to stop; safe to call more than once
 func (wsc *WSConn) Close() {
-	if wsc.closeChan != nil {
-		wsc.closeChan <- true
+	if wsc.state == nil {
+		return
 	}
+	wsc.state.closeOnce.Do(func() {
+		close(wsc.closeChan)
+	})
+}
+
+/*
+Queues a frame to be written to the client without waiting, applying the connection's overflow policy if its queue is
+full. Returns false if the frame was dropped or the connection is closed.
+*/
+func (wsc *WSConn) Send(frame WSFrame) bool {
+	st := wsc.state
+	if st == nil {
+		return false
+	}
+	select {
+	case <-wsc.closeChan:
+		return false
+	default:
+	}
+	select {
+	case st.queue <- frame:
+		return true
+	default:
+	}
+	switch st.opts.Overflow {
+	case OVERFLOW_DROP_NEWEST:
+		st.count(func(m *WSMetrics) { atomic.AddInt64(&m.Dropped, 1) })
+		return false
+	case OVERFLOW_DISCONNECT:
+		st.count(func(m *WSMetrics) {
+			atomic.AddInt64(&m.Dropped, int64(len(st.queue))+1)
+			atomic.AddInt64(&m.Disconnected, 1)
+		})
+		logging.LogToDeck(context.Background(), "warn", "WS", "warn", "disconnecting slow ws client "+wsc.Key)
+		wsc.Close()
+		return false
+	}
+	select {
+	case <-st.queue:
+		st.count(func(m *WSMetrics) { atomic.AddInt64(&m.Dropped, 1) })
+	default:
+	}
+	select {
+	case st.queue <- frame:
+		return true
+	default:
+		st.count(func(m *WSMetrics) { atomic.AddInt64(&m.Dropped, 1) })
+		return false
+	}
+}
+
+// Returns how many frames are waiting to be written
+func (wsc *WSConn) QueueDepth() int {
+	if wsc.state == nil {
+		return 0
+	}
+	return len(wsc.state.queue)
 }
 
 func (wsc *WSConn) process() {
-	var isClosed bool = false
+	st := wsc.state
+	// a connection we've heard nothing from, not even a pong, in two pings is dead
+	timeout := 2 * st.opts.PingInterval
+	if timeout < 0 {
+		timeout = 0
+	}
+
 	// write
 	go func() {
+		defer close(wsc.CloseChan)
+		var ping <-chan time.Time
+		if st.opts.PingInterval > 0 {
+			ticker := time.NewTicker(st.opts.PingInterval)
+			defer ticker.Stop()
+			ping = ticker.C
+		}
 		for {
+			var toWrite WSFrame
 			select {
-			case closed := <-wsc.closeChan:
-				if closed {
-					isClosed = true
-					wsc.CloseChan <- true
-					return
-				}
-			case toWrite := <-wsc.Writer:
-				if isClosed {
-					return
-				}
-				err := wsutil.WriteServerMessage(wsc.Conn, toWrite.Op, toWrite.Data)
-				if err != nil {
-					logging.LogToDeck(context.Background(), "error", "WS", "error", "caught error writing ws client data in "+wsc.Key+": "+err.Error())
+			case <-wsc.closeChan:
+				return
+			case <-ping:
+				toWrite = WSFrame{Op: ws.OpPing}
+			case toWrite = <-st.queue:
+			case frame, ok := <-wsc.Writer:
+				if !ok {
 					wsc.Close()
 					return
 				}
+				toWrite = frame
+			}
+			st.writeMu.Lock()
+			if timeout > 0 {
+				wsc.Conn.SetWriteDeadline(time.Now().Add(timeout))
+			}
+			err := wsutil.WriteServerMessage(wsc.Conn, toWrite.Op, toWrite.Data)
+			st.writeMu.Unlock()
+			if err != nil {
+				logging.LogToDeck(context.Background(), "error", "WS", "error", "caught error writing ws client data in "+wsc.Key+": "+err.Error())
+				wsc.Close()
+				return
+			}
+			if toWrite.Op != ws.OpPing {
+				st.count(func(m *WSMetrics) { atomic.AddInt64(&m.Sent, 1) })
 			}
 		}
 	}()
@@ -70,20 +183,68 @@ func (wsc *WSConn) process() {
 	// read -- this should break as soon as the connection fails, so we don't need to clean up
 	go func() {
 		for {
-			msg, op, err := wsutil.ReadClientData(wsc.Conn)
+			msg, op, err := wsc.readFrame(timeout)
 			if err != nil {
-				logging.LogToDeck(context.Background(), "error", "WS", "error", "caught error reading ws client data in "+wsc.Key+": "+err.Error())
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					st.count(func(m *WSMetrics) { atomic.AddInt64(&m.TimedOut, 1) })
+					logging.LogToDeck(context.Background(), "info", "WS", "info", "ws client "+wsc.Key+" timed out")
+				} else {
+					logging.LogToDeck(context.Background(), "error", "WS", "error", "caught error reading ws client data in "+wsc.Key+": "+err.Error())
+				}
 				wsc.Close()
 				return
 			}
-			if isClosed {
-				return
-			}
-			wsc.Reader <- WSFrame{
+			select {
+			case wsc.Reader <- WSFrame{
 				Op:   op,
 				Data: msg,
+			}:
+			case <-wsc.closeChan:
+				return
 			}
 		}
 	}()
+}
 
+// Reads the next text or binary message from the client, answering control frames along the way
+func (wsc *WSConn) readFrame(timeout time.Duration) ([]byte, ws.OpCode, error) {
+	st := wsc.state
+	ctrl := wsutil.ControlFrameHandler(wsc.Conn, ws.StateServerSide)
+	lockedCtrl := func(hdr ws.Header, r io.Reader) error {
+		st.writeMu.Lock()
+		defer st.writeMu.Unlock()
+		return ctrl(hdr, r)
+	}
+	rd := wsutil.Reader{
+		Source:         wsc.Conn,
+		State:          ws.StateServerSide,
+		CheckUTF8:      true,
+		OnIntermediate: lockedCtrl,
+	}
+	for {
+		if timeout > 0 {
+			wsc.Conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			return nil, 0, err
+		}
+		if hdr.OpCode.IsControl() {
+			err = lockedCtrl(hdr, &rd)
+			if err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if hdr.OpCode&(ws.OpText|ws.OpBinary) == 0 {
+			err = rd.Discard()
+			if err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		data, err := io.ReadAll(&rd)
+		return data, hdr.OpCode, err
+	}
 }
@@ -0,0 +1,94 @@
+package websock
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/highgrav/taproot/authn"
+)
+
+func TestSlowConn(t *testing.T) {
+	hub := NewWSHubWithOptions("test", WSConnOptions{BufferSize: 2, PingInterval: -1})
+	server, client := net.Pipe()
+	defer client.Close()
+	wsc := NewWSConnWithOptions("a", authn.User{}, server, nil, hub.ConnOptions())
+	hub.AddClient(&wsc)
+
+	// nothing reads from the client end, so the first write blocks and the rest queue up
+	written := make(chan bool)
+	go func() {
+		for i := 1; i <= 6; i++ {
+			hub.WriteOne("a", WSFrame{Op: ws.OpText, Data: []byte(strconv.Itoa(i))})
+		}
+		hub.WriteAll(WSFrame{Op: ws.OpText, Data: []byte("7")})
+		written <- true
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("a connection that isn't reading held up the hub")
+	}
+	report := hub.MetricsReport()
+	if report.Connections != 1 || report.Dropped < 4 || report.QueueDepth != 2 {
+		t.Fatalf("unexpected metrics %+v", report)
+	}
+	last := ""
+	for last != "7" {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		data, _, err := wsutil.ReadServerData(client)
+		if err != nil {
+			t.Fatalf("stopped at frame %s: %v", last, err)
+		}
+		last = string(data)
+	}
+	hub.RemoveClient(&wsc)
+	select {
+	case <-wsc.CloseChan:
+	case <-time.After(time.Second):
+		t.Fatal("expected a removed connection to stop")
+	}
+}
+
+func TestPing(t *testing.T) {
+	opts := WSConnOptions{PingInterval: 20 * time.Millisecond, Overflow: OVERFLOW_DISCONNECT}
+	hub := NewWSHubWithOptions("test", opts)
+
+	// a client that answers pings stays connected
+	server, client := net.Pipe()
+	defer client.Close()
+	alive := NewWSConnWithOptions("alive", authn.User{}, server, nil, hub.ConnOptions())
+	hub.AddClient(&alive)
+	go func() {
+		for {
+			if _, _, err := wsutil.ReadServerData(client); err != nil {
+				return
+			}
+		}
+	}()
+
+	// one that doesn't is closed
+	server2, client2 := net.Pipe()
+	defer client2.Close()
+	dead := NewWSConnWithOptions("dead", authn.User{}, server2, nil, hub.ConnOptions())
+	hub.AddClient(&dead)
+	go io.Copy(io.Discard, client2)
+
+	select {
+	case <-dead.CloseChan:
+	case <-time.After(time.Second):
+		t.Fatal("expected a connection that doesn't answer pings to be closed")
+	}
+	select {
+	case <-alive.CloseChan:
+		t.Fatal("a connection that answers pings was closed")
+	default:
+	}
+	if report := hub.MetricsReport(); report.TimedOut != 1 {
+		t.Fatalf("unexpected metrics %+v", report)
+	}
+}
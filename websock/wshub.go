@@ -2,14 +2,15 @@ package websock
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"github.com/highgrav/taproot/common"
 	"github.com/highgrav/taproot/logging"
+	"github.com/highgrav/taproot/messages"
 	"sync"
 	"sync/atomic"
 )
 
-// TODO
-
 const (
 	WS_HEADER_CLIENT_UPGRADE           string = "upgrade"
 	WS_HEADER_CLIENT_CONNECTION        string = "connection"
@@ -27,19 +28,34 @@ type WSConnContainer struct {
 
 type WSHub struct {
 	sync.Mutex
-	Name       string
-	Metrics    *WSMetrics
-	conns      map[string]*WSConnContainer
-	acts       chan func()
-	TotalConns int32
+	Name           string
+	Metrics        *WSMetrics
+	conns          map[string]*WSConnContainer
+	rooms          map[string]*wsRoom
+	connRooms      map[*WSConn][]string // the rooms each connection is in
+	acts           chan func()
+	connOpts       WSConnOptions
+	bus            atomic.Pointer[messages.MessageBus]
+	presence       *wsPresenceQueue
+	presenceFrames atomic.Bool
+	TotalConns     int32
 }
 
 func NewWSHub(id string) *WSHub {
+	return NewWSHubWithOptions(id, WSConnOptions{})
+}
+
+// Creates a hub whose connections use the given queue size, overflow policy and ping interval
+func NewWSHubWithOptions(id string, opts WSConnOptions) *WSHub {
 	hub := &WSHub{
 		Name:       id,
 		Metrics:    &WSMetrics{},
 		conns:      make(map[string]*WSConnContainer),
+		rooms:      make(map[string]*wsRoom),
+		connRooms:  make(map[*WSConn][]string),
 		acts:       make(chan func()),
+		connOpts:   opts.normalize(),
+		presence:   newPresenceQueue(),
 		TotalConns: 0,
 	}
 
@@ -47,13 +63,21 @@ func NewWSHub(id string) *WSHub {
 	return hub
 }
 
+// Returns the options to create the hub's connections with
+func (hub *WSHub) ConnOptions() WSConnOptions {
+	return hub.connOpts
+}
+
 func (hub *WSHub) AddClient(wsconn *WSConn) {
 	hub.acts <- func() {
 		if _, ok := hub.conns[wsconn.Key]; !ok {
 			hub.conns[wsconn.Key] = &WSConnContainer{
-				Conns: []*WSConn{wsconn},
+				Conns: make([]*WSConn, 0),
 			}
 		}
+		if wsconn.state != nil {
+			wsconn.state.metrics.Store(hub.Metrics)
+		}
 		hub.conns[wsconn.Key].Lock()
 		hub.conns[wsconn.Key].Conns = append(hub.conns[wsconn.Key].Conns, wsconn)
 		atomic.AddInt32(&hub.TotalConns, 1)
@@ -84,11 +108,8 @@ func (hub *WSHub) RemoveClient(wsconn *WSConn) {
 				wss = append(wss, val)
 			} else {
 				logging.LogToDeck(context.Background(), "info", "WS", "info", "Closing WS conn "+wsconn.Key)
-				close(val.Reader)
-				close(val.Writer)
-				if val.Conn != nil {
-					val.Conn.Close()
-				}
+				hub.leaveAll(val)
+				closeConn(val)
 				atomic.AddInt32(&hub.TotalConns, -1)
 			}
 		}
@@ -106,7 +127,8 @@ func (hub *WSHub) RemoveClients(clientId string) {
 		if vals, ok := hub.conns[clientId]; ok {
 			vals.Lock()
 			for _, val := range vals.Conns {
-				val.closeChan <- true
+				hub.leaveAll(val)
+				closeConn(val)
 				atomic.AddInt32(&hub.TotalConns, -1)
 			}
 			vals.Unlock()
@@ -115,17 +137,166 @@ func (hub *WSHub) RemoveClients(clientId string) {
 	}
 }
 
+// Stops a connection and closes its socket, so its reader stops too
+func closeConn(wsconn *WSConn) {
+	wsconn.Close()
+	if wsconn.Conn != nil {
+		wsconn.Conn.Close()
+	}
+}
+
+// Queues a frame for each of a client's connections. Writes never wait on a slow connection.
+func (hub *WSHub) WriteOne(clientId string, frame WSFrame) {
+	hub.writeOne(clientId, frame)
+	hub.relay(messages.MSG_OP_ONE, []string{clientId}, frame)
+}
+
+func (hub *WSHub) writeOne(clientId string, frame WSFrame) {
+	hub.acts <- func() {
+		if vals, ok := hub.conns[clientId]; ok {
+			vals.Lock()
+			for _, val := range vals.Conns {
+				val.Send(frame)
+			}
+			vals.Unlock()
+		}
+	}
+}
+
+func (hub *WSHub) WriteMany(clientIds []string, frame WSFrame) {
+	// copies the IDs, since the caller may reuse its slice
+	clientIds = append([]string(nil), clientIds...)
+	hub.writeMany(clientIds, frame)
+	hub.relay(messages.MSG_OP_MANY, clientIds, frame)
+}
+
+func (hub *WSHub) writeMany(clientIds []string, frame WSFrame) {
+	hub.acts <- func() {
+		for _, id := range clientIds {
+			if vals, ok := hub.conns[id]; ok {
+				vals.Lock()
+				for _, val := range vals.Conns {
+					val.Send(frame)
+				}
+				vals.Unlock()
+			}
+		}
+	}
+}
+
+func (hub *WSHub) WriteAll(frame WSFrame) {
+	hub.writeAll(frame)
+	hub.relay(messages.MSG_OP_ALL, nil, frame)
+}
+
+func (hub *WSHub) writeAll(frame WSFrame) {
+	hub.acts <- func() {
+		for _, vals := range hub.conns {
+			vals.Lock()
+			for _, val := range vals.Conns {
+				val.Send(frame)
+			}
+			vals.Unlock()
+		}
+	}
+}
+
+// Relays the hub's writes to the same hub on other server instances through a message bus, and delivers theirs here. Nil stops relaying.
+func (hub *WSHub) SetMessageBus(bus *messages.MessageBus) {
+	if old := hub.bus.Swap(bus); old != nil && old != bus {
+		old.RemoveTarget(messages.MSG_KIND_WS, hub.Name)
+	}
+	if bus != nil {
+		bus.AddTarget(messages.MSG_KIND_WS, hub.Name, hub)
+	}
+}
+
+// Sends a write to the message bus, if there is one
+func (hub *WSHub) relay(op string, targets []string, frame WSFrame) {
+	bus := hub.bus.Load()
+	if bus == nil {
+		return
+	}
+	data, err := json.Marshal(frame)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "WS", "error", "could not relay frame from hub "+hub.Name+": "+err.Error())
+		return
+	}
+	bus.Send(messages.Message{
+		Kind:    messages.MSG_KIND_WS,
+		Hub:     hub.Name,
+		Op:      op,
+		Targets: targets,
+		Payload: data,
+	})
+}
+
+// Delivers a frame relayed from another instance to the connections here, without relaying it again
+func (hub *WSHub) HandleMessage(msg messages.Message) error {
+	frame := WSFrame{}
+	err := json.Unmarshal(msg.Payload, &frame)
+	if err != nil {
+		return err
+	}
+	switch msg.Op {
+	case messages.MSG_OP_ONE:
+		for _, id := range msg.Targets {
+			hub.writeOne(id, frame)
+		}
+	case messages.MSG_OP_MANY:
+		hub.writeMany(msg.Targets, frame)
+	case messages.MSG_OP_ALL:
+		hub.writeAll(frame)
+	case messages.MSG_OP_ROOM:
+		for _, room := range msg.Targets {
+			room := room
+			hub.acts <- func() {
+				hub.sendRoom(room, nil, frame)
+			}
+		}
+	default:
+		return errors.New("unknown message op " + msg.Op)
+	}
+	return nil
+}
+
+// Returns the hub's metrics, along with how many frames are queued
+func (hub *WSHub) MetricsReport() WSMetricsReport {
+	res := make(chan WSMetricsReport, 1)
+	hub.acts <- func() {
+		depth, maxDepth := 0, 0
+		for _, vals := range hub.conns {
+			vals.Lock()
+			for _, val := range vals.Conns {
+				n := val.QueueDepth()
+				depth += n
+				if n > maxDepth {
+					maxDepth = n
+				}
+			}
+			vals.Unlock()
+		}
+		report := hub.Metrics.Snapshot(atomic.LoadInt32(&hub.TotalConns), depth, maxDepth)
+		report.Rooms = len(hub.rooms)
+		res <- report
+	}
+	return <-res
+}
+
+// Returns a client key that isn't in use, reserving it for a connection that's about to be added
 func (hub *WSHub) GenerateNewId(len int) string {
-	hub.Lock()
-	id := common.CreateRandString(len)
-	_, ok := hub.conns[id]
-	for ok {
-		id = common.CreateRandString(len)
-		_, ok = hub.conns[id]
-	}
-	hub.conns[id] = &WSConnContainer{
-		Conns: make([]*WSConn, 0),
-	}
-	hub.Unlock()
-	return id
+	res := make(chan string, 1)
+	hub.acts <- func() {
+		id := common.CreateRandString(len)
+		_, ok := hub.conns[id]
+		for ok {
+			id = common.CreateRandString(len)
+			_, ok = hub.conns[id]
+		}
+		hub.conns[id] = &WSConnContainer{
+			Conns: make([]*WSConn, 0),
+		}
+		res <- id
+	}
+	return <-res
 }
@@ -1,4 +1,35 @@
 package websock
 
+import "sync/atomic"
+
+// Running counts for a WSHub's connections, updated atomically
 type WSMetrics struct {
+	Sent         int64 // Frames written to connections
+	Dropped      int64 // Frames dropped because a connection's queue was full
+	Disconnected int64 // Connections closed because their queue was full
+	TimedOut     int64 // Connections closed because nothing was read from them in time
+}
+
+// A snapshot of a WSHub's metrics
+type WSMetricsReport struct {
+	Connections   int32 `json:"connections"`
+	Sent          int64 `json:"sent"`
+	Dropped       int64 `json:"dropped"`
+	Disconnected  int64 `json:"disconnected"`
+	TimedOut      int64 `json:"timedOut"`
+	QueueDepth    int   `json:"queueDepth"`    // Frames queued across all connections
+	MaxQueueDepth int   `json:"maxQueueDepth"` // Frames queued for the most backed-up connection
+	Rooms         int   `json:"rooms"`         // Rooms with connections in them
+}
+
+func (m *WSMetrics) Snapshot(conns int32, depth int, maxDepth int) WSMetricsReport {
+	return WSMetricsReport{
+		Connections:   conns,
+		Sent:          atomic.LoadInt64(&m.Sent),
+		Dropped:       atomic.LoadInt64(&m.Dropped),
+		Disconnected:  atomic.LoadInt64(&m.Disconnected),
+		TimedOut:      atomic.LoadInt64(&m.TimedOut),
+		QueueDepth:    depth,
+		MaxQueueDepth: maxDepth,
+	}
 }
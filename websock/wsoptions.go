@@ -0,0 +1,41 @@
+package websock
+
+import "time"
+
+const (
+	WS_DEFAULT_BUFFER_SIZE   int           = 64               // Frames queued for each connection, unless configured otherwise
+	WS_DEFAULT_PING_INTERVAL time.Duration = 30 * time.Second // How often connections are pinged, unless configured otherwise
+)
+
+// What a connection does with a frame when its queue is full
+type OverflowPolicy string
+
+const (
+	OVERFLOW_DROP_OLDEST OverflowPolicy = "drop-oldest" // The oldest queued frame is dropped to make room (the default)
+	OVERFLOW_DROP_NEWEST OverflowPolicy = "drop-newest" // The new frame is dropped
+	OVERFLOW_DISCONNECT  OverflowPolicy = "disconnect"  // The connection is closed
+)
+
+/*
+Options for a connection's outgoing queue. A connection is pinged every PingInterval (negative for never), and is
+closed if nothing, not even a pong, is read from it for two intervals.
+*/
+type WSConnOptions struct {
+	BufferSize   int
+	Overflow     OverflowPolicy
+	PingInterval time.Duration
+}
+
+// Fills in defaults for anything not set
+func (opts WSConnOptions) normalize() WSConnOptions {
+	if opts.BufferSize < 1 {
+		opts.BufferSize = WS_DEFAULT_BUFFER_SIZE
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = OVERFLOW_DROP_OLDEST
+	}
+	if opts.PingInterval == 0 {
+		opts.PingInterval = WS_DEFAULT_PING_INTERVAL
+	}
+	return opts
+}
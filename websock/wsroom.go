@@ -0,0 +1,385 @@
+package websock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gobwas/ws"
+	"github.com/highgrav/taproot/logging"
+	"github.com/highgrav/taproot/messages"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The kinds of presence event
+const (
+	WS_PRESENCE_JOIN  string = "join"
+	WS_PRESENCE_LEAVE string = "leave"
+)
+
+// The type of the JSON frames presence events are sent to rooms in, if the hub sends them
+const WS_PRESENCE_FRAME_TYPE string = "presence"
+
+var (
+	ErrConnNotFound    = errors.New("connection is not in the hub")
+	ErrInvalidRoomName = errors.New("invalid room name")
+)
+
+/*
+A room is a named group of connections in a hub, which can be written to together. Connections join and leave rooms
+individually, but presence is tracked by client key: a client is present in a room while any of its connections are.
+*/
+type wsRoom struct {
+	conns   map[*WSConn]bool
+	members map[string]*WSPresence
+}
+
+// A client present in a room
+type WSPresence struct {
+	Key         string    `json:"key"`
+	UserID      string    `json:"userId"`
+	Connections int       `json:"connections"` // The client's connections in the room
+	JoinedAt    time.Time `json:"joinedAt"`
+}
+
+// Reports a client joining a room (with its first connection) or leaving it (with its last)
+type WSPresenceEvent struct {
+	Event  string    `json:"event"`
+	Hub    string    `json:"hub"`
+	Room   string    `json:"room"`
+	Key    string    `json:"key"`
+	UserID string    `json:"userId"`
+	Time   time.Time `json:"time"`
+}
+
+type wsPresenceFrame struct {
+	Type string `json:"type"`
+	WSPresenceEvent
+}
+
+/*
+Presence events waiting to be passed to the hub's listeners. Listeners are called from their own goroutine, in order,
+so that they can use the hub without holding it up; the queue has no limit, since dropping a leave event would leave a
+client present forever as far as a listener is concerned.
+*/
+type wsPresenceQueue struct {
+	mu        sync.Mutex
+	events    []WSPresenceEvent
+	listeners []func(evt WSPresenceEvent)
+	wake      chan bool
+}
+
+func newPresenceQueue() *wsPresenceQueue {
+	pq := &wsPresenceQueue{
+		events:    make([]WSPresenceEvent, 0),
+		listeners: make([]func(evt WSPresenceEvent), 0),
+		wake:      make(chan bool, 1),
+	}
+	go pq.run()
+	return pq
+}
+
+func (pq *wsPresenceQueue) add(evt WSPresenceEvent) {
+	pq.mu.Lock()
+	if len(pq.listeners) == 0 {
+		pq.mu.Unlock()
+		return
+	}
+	pq.events = append(pq.events, evt)
+	pq.mu.Unlock()
+	select {
+	case pq.wake <- true:
+	default:
+	}
+}
+
+func (pq *wsPresenceQueue) run() {
+	for range pq.wake {
+		pq.mu.Lock()
+		evts := pq.events
+		listeners := pq.listeners
+		pq.events = make([]WSPresenceEvent, 0)
+		pq.mu.Unlock()
+		for _, evt := range evts {
+			for _, fn := range listeners {
+				fn(evt)
+			}
+		}
+	}
+}
+
+func checkRoomName(room string) error {
+	if room == "" {
+		return ErrInvalidRoomName
+	}
+	return nil
+}
+
+// Returns the hub's own copy of a connection, which may have been added as a different copy. Must be called from an action.
+func (hub *WSHub) findConn(wsconn *WSConn) *WSConn {
+	if wsconn == nil {
+		return nil
+	}
+	vals, ok := hub.conns[wsconn.Key]
+	if !ok {
+		return nil
+	}
+	vals.Lock()
+	defer vals.Unlock()
+	for _, val := range vals.Conns {
+		if val == wsconn || (val.state != nil && val.state == wsconn.state) {
+			return val
+		}
+	}
+	return nil
+}
+
+// Adds a connection to a room, announcing the client if it wasn't already present. Must be called from an action.
+func (hub *WSHub) join(room string, wsconn *WSConn) {
+	r, ok := hub.rooms[room]
+	if !ok {
+		r = &wsRoom{
+			conns:   make(map[*WSConn]bool),
+			members: make(map[string]*WSPresence),
+		}
+		hub.rooms[room] = r
+	}
+	if r.conns[wsconn] {
+		return
+	}
+	r.conns[wsconn] = true
+	hub.connRooms[wsconn] = append(hub.connRooms[wsconn], room)
+	member, ok := r.members[wsconn.Key]
+	if ok {
+		member.Connections++
+		return
+	}
+	r.members[wsconn.Key] = &WSPresence{
+		Key:         wsconn.Key,
+		UserID:      wsconn.User.UserID,
+		Connections: 1,
+		JoinedAt:    time.Now(),
+	}
+	hub.announce(WS_PRESENCE_JOIN, room, wsconn)
+}
+
+// Removes a connection from a room, announcing the client if it has no connections left there. Must be called from an action.
+func (hub *WSHub) leave(room string, wsconn *WSConn) {
+	r, ok := hub.rooms[room]
+	if !ok || !r.conns[wsconn] {
+		return
+	}
+	delete(r.conns, wsconn)
+	rooms := make([]string, 0, len(hub.connRooms[wsconn]))
+	for _, v := range hub.connRooms[wsconn] {
+		if v != room {
+			rooms = append(rooms, v)
+		}
+	}
+	if len(rooms) == 0 {
+		delete(hub.connRooms, wsconn)
+	} else {
+		hub.connRooms[wsconn] = rooms
+	}
+	if member, ok := r.members[wsconn.Key]; ok {
+		member.Connections--
+		if member.Connections <= 0 {
+			delete(r.members, wsconn.Key)
+			hub.announce(WS_PRESENCE_LEAVE, room, wsconn)
+		}
+	}
+	if len(r.conns) == 0 {
+		delete(hub.rooms, room)
+	}
+}
+
+// Removes a connection from every room it's in. Must be called from an action.
+func (hub *WSHub) leaveAll(wsconn *WSConn) {
+	rooms := append([]string(nil), hub.connRooms[wsconn]...)
+	for _, room := range rooms {
+		hub.leave(room, wsconn)
+	}
+}
+
+// Passes a presence event to the hub's listeners, and sends it to the room if the hub sends presence frames. Must be called from an action.
+func (hub *WSHub) announce(event string, room string, wsconn *WSConn) {
+	evt := WSPresenceEvent{
+		Event:  event,
+		Hub:    hub.Name,
+		Room:   room,
+		Key:    wsconn.Key,
+		UserID: wsconn.User.UserID,
+		Time:   time.Now(),
+	}
+	hub.presence.add(evt)
+	if !hub.presenceFrames.Load() {
+		return
+	}
+	data, err := json.Marshal(wsPresenceFrame{Type: WS_PRESENCE_FRAME_TYPE, WSPresenceEvent: evt})
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "WS", "error", "could not encode presence event for room "+room+" in hub "+hub.Name+": "+err.Error())
+		return
+	}
+	frame := WSFrame{Op: ws.OpText, Data: data}
+	hub.sendRoom(room, nil, frame)
+	hub.relay(messages.MSG_OP_ROOM, []string{room}, frame)
+}
+
+// Queues a frame for every connection in a room but one (which may be nil). Must be called from an action.
+func (hub *WSHub) sendRoom(room string, except *WSConn, frame WSFrame) {
+	r, ok := hub.rooms[room]
+	if !ok {
+		return
+	}
+	for wsconn := range r.conns {
+		if wsconn != except {
+			wsconn.Send(frame)
+		}
+	}
+}
+
+// Adds a connection to a room. The connection must have been added to the hub, and is removed from its rooms when it's removed from the hub.
+func (hub *WSHub) Join(room string, wsconn *WSConn) error {
+	if err := checkRoomName(room); err != nil {
+		return err
+	}
+	res := make(chan error, 1)
+	hub.acts <- func() {
+		val := hub.findConn(wsconn)
+		if val == nil {
+			res <- ErrConnNotFound
+			return
+		}
+		hub.join(room, val)
+		res <- nil
+	}
+	return <-res
+}
+
+func (hub *WSHub) Leave(room string, wsconn *WSConn) {
+	hub.acts <- func() {
+		if val := hub.findConn(wsconn); val != nil {
+			hub.leave(room, val)
+		}
+	}
+}
+
+// Adds all of a client's current connections to a room, returning how many there were
+func (hub *WSHub) JoinClient(room string, clientId string) (int, error) {
+	if err := checkRoomName(room); err != nil {
+		return 0, err
+	}
+	res := make(chan int, 1)
+	hub.acts <- func() {
+		n := 0
+		if vals, ok := hub.conns[clientId]; ok {
+			vals.Lock()
+			conns := append([]*WSConn(nil), vals.Conns...)
+			vals.Unlock()
+			for _, val := range conns {
+				hub.join(room, val)
+				n++
+			}
+		}
+		res <- n
+	}
+	return <-res, nil
+}
+
+// Removes all of a client's connections from a room
+func (hub *WSHub) LeaveClient(room string, clientId string) {
+	hub.acts <- func() {
+		if r, ok := hub.rooms[room]; ok {
+			for wsconn := range r.conns {
+				if wsconn.Key == clientId {
+					hub.leave(room, wsconn)
+				}
+			}
+		}
+	}
+}
+
+// Returns the names of the rooms with connections in them, sorted
+func (hub *WSHub) Rooms() []string {
+	res := make(chan []string, 1)
+	hub.acts <- func() {
+		rooms := make([]string, 0, len(hub.rooms))
+		for room := range hub.rooms {
+			rooms = append(rooms, room)
+		}
+		res <- rooms
+	}
+	rooms := <-res
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Returns the rooms a connection is in, sorted
+func (hub *WSHub) RoomsOf(wsconn *WSConn) []string {
+	res := make(chan []string, 1)
+	hub.acts <- func() {
+		rooms := make([]string, 0)
+		if val := hub.findConn(wsconn); val != nil {
+			rooms = append(rooms, hub.connRooms[val]...)
+		}
+		res <- rooms
+	}
+	rooms := <-res
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Returns the clients present in a room on this server, in the order they joined
+func (hub *WSHub) Presence(room string) []WSPresence {
+	res := make(chan []WSPresence, 1)
+	hub.acts <- func() {
+		members := make([]WSPresence, 0)
+		if r, ok := hub.rooms[room]; ok {
+			for _, member := range r.members {
+				members = append(members, *member)
+			}
+		}
+		res <- members
+	}
+	members := <-res
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].Key < members[j].Key
+		}
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+	return members
+}
+
+/*
+Adds a function to be called whenever a client joins or leaves a room. Listeners are called in order from a goroutine
+of their own, so they may use the hub (say, to write the event to the room), but a slow listener delays the events
+after it.
+*/
+func (hub *WSHub) OnPresence(fn func(evt WSPresenceEvent)) {
+	hub.presence.mu.Lock()
+	defer hub.presence.mu.Unlock()
+	hub.presence.listeners = append(hub.presence.listeners, fn)
+}
+
+/*
+Sets whether presence events are sent to the connections in a room, as JSON text frames with a "type" of "presence",
+along with the event's fields (such as {"type":"presence","event":"join","room":"chat","key":"...","userId":"..."}).
+*/
+func (hub *WSHub) SetPresenceFrames(enabled bool) {
+	hub.presenceFrames.Store(enabled)
+}
+
+// Queues a frame for every connection in a room
+func (hub *WSHub) WriteRoom(room string, frame WSFrame) {
+	hub.WriteRoomExcept(room, nil, frame)
+}
+
+// Queues a frame for every connection in a room except one, such as the connection a chat message came from
+func (hub *WSHub) WriteRoomExcept(room string, except *WSConn, frame WSFrame) {
+	hub.acts <- func() {
+		hub.sendRoom(room, hub.findConn(except), frame)
+	}
+	hub.relay(messages.MSG_OP_ROOM, []string{room}, frame)
+}
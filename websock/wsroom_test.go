@@ -0,0 +1,109 @@
+package websock
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/highgrav/taproot/authn"
+)
+
+// Adds a connection to a hub, returning it and a channel of the text frames its client receives
+func addTestConn(t *testing.T, hub *WSHub, key string) (*WSConn, chan string) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	wsc := NewWSConnWithOptions(key, authn.User{UserID: "user-" + key}, server, nil, hub.ConnOptions())
+	hub.AddClient(&wsc)
+	got := make(chan string, 10)
+	go func() {
+		for {
+			data, _, err := wsutil.ReadServerData(client)
+			if err != nil {
+				return
+			}
+			got <- string(data)
+		}
+	}()
+	return &wsc, got
+}
+
+func expectFrame(t *testing.T, got chan string, want string) {
+	t.Helper()
+	select {
+	case data := <-got:
+		if !strings.Contains(data, want) {
+			t.Fatalf("expected a frame with %s, got %s", want, data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a frame with %s", want)
+	}
+}
+
+func TestRooms(t *testing.T) {
+	hub := NewWSHubWithOptions("test", WSConnOptions{PingInterval: -1})
+	events := make(chan WSPresenceEvent, 10)
+	hub.OnPresence(func(evt WSPresenceEvent) {
+		events <- evt
+	})
+	a1, gotA1 := addTestConn(t, hub, "a")
+	a2, gotA2 := addTestConn(t, hub, "a")
+	b, gotB := addTestConn(t, hub, "b")
+	for _, wsc := range []*WSConn{a1, a2, b} {
+		if err := hub.Join("chat", wsc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hub.Join("chat", &WSConn{Key: "nobody"}); err != ErrConnNotFound {
+		t.Fatalf("expected ErrConnNotFound, got %v", err)
+	}
+
+	// a client is present once, however many connections it has in the room
+	members := hub.Presence("chat")
+	if len(members) != 2 || members[0].Key != "a" || members[0].Connections != 2 || members[1].UserID != "user-b" {
+		t.Fatalf("unexpected presence %+v", members)
+	}
+	for _, want := range []string{"a", "b"} {
+		if evt := <-events; evt.Event != WS_PRESENCE_JOIN || evt.Key != want || evt.Room != "chat" {
+			t.Fatalf("unexpected event %+v", evt)
+		}
+	}
+
+	hub.WriteRoomExcept("chat", a1, WSFrame{Op: ws.OpText, Data: []byte("hello")})
+	expectFrame(t, gotA2, "hello")
+	expectFrame(t, gotB, "hello")
+	select {
+	case data := <-gotA1:
+		t.Fatalf("the excepted connection got %s", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// a client leaves when its last connection does, and presence frames tell the rest of the room
+	hub.SetPresenceFrames(true)
+	hub.RemoveClient(a1)
+	hub.Leave("chat", a2)
+	evt := <-events
+	if evt.Event != WS_PRESENCE_LEAVE || evt.Key != "a" {
+		t.Fatalf("unexpected event %+v", evt)
+	}
+	select {
+	case data := <-gotB:
+		frame := wsPresenceFrame{}
+		if err := json.Unmarshal([]byte(data), &frame); err != nil || frame.Type != WS_PRESENCE_FRAME_TYPE || frame.Event != WS_PRESENCE_LEAVE {
+			t.Fatalf("unexpected presence frame %s", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a presence frame")
+	}
+	if rooms := hub.RoomsOf(b); len(rooms) != 1 || rooms[0] != "chat" {
+		t.Fatalf("unexpected rooms %v", rooms)
+	}
+	hub.RemoveClient(b)
+	if rooms := hub.Rooms(); len(rooms) != 0 {
+		t.Fatalf("expected empty rooms to be removed, got %v", rooms)
+	}
+}
@@ -0,0 +1,45 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"github.com/highgrav/taproot/logging"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// Moves a request that has run out of attempts to the dead-letter queue
+func (wq *WorkQueue) deadLetter(msg *WorkRequest) error {
+	return wq.backend.DeadLetter(msg)
+}
+
+// Returns the number of requests on the dead-letter queue
+func (wq *WorkQueue) DeadLetterDepth() int {
+	n, err := wq.backend.DeadLetterDepth()
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not get dead-letter queue depth: "+err.Error())
+	}
+	return n
+}
+
+// Lists the requests on the dead-letter queue, oldest first, up to limit (zero for all of them)
+func (wq *WorkQueue) DeadLetters(limit int) ([]WorkRequest, error) {
+	return wq.backend.DeadLetters(limit)
+}
+
+/*
+Puts dead-lettered requests back on the work queue with their attempt counts reset. If id is empty, every dead letter
+is replayed. Returns the number of requests replayed.
+*/
+func (wq *WorkQueue) ReplayDeadLetters(id string) (int, error) {
+	return wq.backend.ReplayDeadLetters(id, func(msg *WorkRequest) {
+		msg.Attempts = 0
+		msg.LastError = ""
+		wq.jobPending(msg)
+	})
+}
+
+// Deletes dead-lettered requests. If id is empty, every dead letter is deleted. Returns the number of requests deleted.
+func (wq *WorkQueue) PurgeDeadLetters(id string) (int, error) {
+	return wq.backend.PurgeDeadLetters(id)
+}
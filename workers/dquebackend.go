@@ -0,0 +1,363 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"github.com/highgrav/taproot/logging"
+	"github.com/joncrlsn/dque"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+DQueBackend is a QueueBackend that keeps messages on local disk using github.com/joncrlsn/dque. It is durable between
+restarts, but limited to a single server instance.
+
+The live queue and the dead-letter queue (with a '-dead' suffix) are dque queues. Messages scheduled to run later are
+each stored in their own file, in a directory with a '-scheduled' suffix, and moved onto the live queue as they come
+due.
+*/
+type DQueBackend struct {
+	queue     *dque.DQue
+	dead      *dque.DQue
+	deadMu    sync.Mutex
+	schedMu   sync.Mutex
+	schedDir  string
+	scheduled map[string]scheduledEntry // by request ID
+	schedWake chan bool
+	closeOnce sync.Once
+	closed    chan bool
+}
+
+// A request waiting on disk for its scheduled time
+type scheduledEntry struct {
+	runAt time.Time
+	file  string
+}
+
+type scheduledRequest struct {
+	RunAt time.Time
+	Msg   *WorkRequest
+}
+
+func msgBuilder() interface{} {
+	return &WorkRequest{}
+}
+
+// Opens (or creates) the queues for a WorkQueue in a directory
+func NewDQueBackend(name string, saveDir string, segmentSz int) (*DQueBackend, error) {
+	dq, err := dque.NewOrOpen(name, saveDir, segmentSz, msgBuilder)
+	if err != nil {
+		return nil, err
+	}
+	dead, err := dque.NewOrOpen(name+"-dead", saveDir, segmentSz, msgBuilder)
+	if err != nil {
+		dq.Close()
+		return nil, err
+	}
+	schedDir := filepath.Join(saveDir, name+"-scheduled")
+	err = os.MkdirAll(schedDir, 0755)
+	if err != nil {
+		dq.Close()
+		dead.Close()
+		return nil, err
+	}
+	b := &DQueBackend{
+		queue:     dq,
+		dead:      dead,
+		schedDir:  schedDir,
+		scheduled: make(map[string]scheduledEntry),
+		schedWake: make(chan bool, 1),
+		closed:    make(chan bool),
+	}
+	err = b.loadScheduled()
+	if err != nil {
+		dq.Close()
+		dead.Close()
+		return nil, err
+	}
+	go b.promoteScheduled()
+	return b, nil
+}
+
+func (b *DQueBackend) Enqueue(msg *WorkRequest, runAt time.Time) error {
+	if !runAt.After(time.Now()) {
+		return b.enqueue(b.queue, msg)
+	}
+	if msg.ID == "" || msg.ID != filepath.Base(msg.ID) || strings.HasPrefix(msg.ID, ".") {
+		return ErrInvalidJobID
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(scheduledRequest{RunAt: runAt, Msg: msg})
+	if err != nil {
+		return err
+	}
+	// the run time is kept in the file name, so the schedule can be rebuilt without decoding every request
+	file := filepath.Join(b.schedDir, strconv.FormatInt(runAt.UnixNano(), 10)+"-"+msg.ID+".gob")
+	b.schedMu.Lock()
+	err = os.WriteFile(file, buf.Bytes(), 0644)
+	if err == nil {
+		b.scheduled[msg.ID] = scheduledEntry{runAt: runAt, file: file}
+	}
+	b.schedMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case b.schedWake <- true:
+	default:
+	}
+	return nil
+}
+
+func (b *DQueBackend) enqueue(q *dque.DQue, msg *WorkRequest) error {
+	err := q.Enqueue(msg)
+	if err == dque.ErrQueueClosed {
+		return ErrQueueClosed
+	}
+	return err
+}
+
+func (b *DQueBackend) Dequeue() (*WorkRequest, error) {
+	item, err := b.queue.DequeueBlock()
+	if err == dque.ErrQueueClosed {
+		return nil, ErrQueueClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.(*WorkRequest), nil
+}
+
+// Removes a scheduled message. Messages already on the live queue can't be removed.
+func (b *DQueBackend) Remove(id string) (bool, error) {
+	b.schedMu.Lock()
+	defer b.schedMu.Unlock()
+	entry, ok := b.scheduled[id]
+	if !ok {
+		return false, nil
+	}
+	delete(b.scheduled, id)
+	err := os.Remove(entry.file)
+	if err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+	return true, nil
+}
+
+func (b *DQueBackend) Depth() (int, error) {
+	return b.queue.Size(), nil
+}
+
+func (b *DQueBackend) ScheduledDepth() (int, error) {
+	b.schedMu.Lock()
+	defer b.schedMu.Unlock()
+	return len(b.scheduled), nil
+}
+
+func (b *DQueBackend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+	})
+	b.dead.Close()
+	err := b.queue.Close()
+	if err == dque.ErrQueueClosed {
+		return nil
+	}
+	return err
+}
+
+// Rebuilds the schedule from the files left by a previous run
+func (b *DQueBackend) loadScheduled() error {
+	entries, err := os.ReadDir(b.schedDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".gob") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSuffix(name, ".gob"), "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		ns, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		b.scheduled[parts[1]] = scheduledEntry{runAt: time.Unix(0, ns), file: filepath.Join(b.schedDir, name)}
+	}
+	return nil
+}
+
+// goroutine to move scheduled messages onto the queue as they come due
+func (b *DQueBackend) promoteScheduled() {
+	for {
+		next := b.promoteDue()
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-b.schedWake:
+			timer.Stop()
+		case <-b.closed:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Enqueues every scheduled message that is due, and returns when the next one is due (zero if none are scheduled)
+func (b *DQueBackend) promoteDue() time.Time {
+	now := time.Now()
+	due := make([]scheduledEntry, 0)
+	var next time.Time
+	b.schedMu.Lock()
+	for id, entry := range b.scheduled {
+		if !entry.runAt.After(now) {
+			due = append(due, entry)
+			delete(b.scheduled, id)
+		} else if next.IsZero() || entry.runAt.Before(next) {
+			next = entry.runAt
+		}
+	}
+	b.schedMu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].runAt.Before(due[j].runAt)
+	})
+	for _, entry := range due {
+		b.promote(entry)
+	}
+	return next
+}
+
+func (b *DQueBackend) promote(entry scheduledEntry) {
+	data, err := os.ReadFile(entry.file)
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not read scheduled work '"+entry.file+"': "+err.Error())
+		return
+	}
+	sr := scheduledRequest{}
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&sr)
+	if err != nil {
+		// the file is left alone, so it's retried on the next restart (e.g. once its data type is registered)
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not decode scheduled work '"+entry.file+"': "+err.Error())
+		return
+	}
+	err = b.enqueue(b.queue, sr.Msg)
+	if err == ErrQueueClosed {
+		return
+	} else if err != nil {
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not enqueue scheduled work '"+sr.Msg.ID+"', moving to dead-letter queue: "+err.Error())
+		sr.Msg.LastError = err.Error()
+		err = b.DeadLetter(sr.Msg)
+		if err != nil {
+			return
+		}
+	}
+	// if the server stops before this, the message runs again on restart; handlers should be idempotent anyway
+	os.Remove(entry.file)
+}
+
+func (b *DQueBackend) DeadLetter(msg *WorkRequest) error {
+	b.deadMu.Lock()
+	defer b.deadMu.Unlock()
+	return b.enqueue(b.dead, msg)
+}
+
+func (b *DQueBackend) DeadLetterDepth() (int, error) {
+	return b.dead.Size(), nil
+}
+
+/*
+Removes every request from the dead-letter queue, passes each one to keep, and puts back the ones keep returns true
+for, preserving their order. Must be called while holding deadMu.
+*/
+func (b *DQueBackend) drainDeadLetters(keep func(msg *WorkRequest) bool) error {
+	count := b.dead.Size()
+	for i := 0; i < count; i++ {
+		item, err := b.dead.Dequeue()
+		if err == dque.ErrEmpty {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		msg := item.(*WorkRequest)
+		if keep(msg) {
+			err = b.dead.Enqueue(msg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *DQueBackend) DeadLetters(limit int) ([]WorkRequest, error) {
+	b.deadMu.Lock()
+	defer b.deadMu.Unlock()
+	res := make([]WorkRequest, 0)
+	err := b.drainDeadLetters(func(msg *WorkRequest) bool {
+		if limit <= 0 || len(res) < limit {
+			res = append(res, *msg)
+		}
+		return true
+	})
+	return res, err
+}
+
+func (b *DQueBackend) ReplayDeadLetters(id string, prepare func(msg *WorkRequest)) (int, error) {
+	b.deadMu.Lock()
+	defer b.deadMu.Unlock()
+	replayed := 0
+	var replayErr error
+	err := b.drainDeadLetters(func(msg *WorkRequest) bool {
+		if replayErr != nil || (id != "" && msg.ID != id) {
+			return true
+		}
+		prepare(msg)
+		replayErr = b.queue.Enqueue(msg)
+		if replayErr != nil {
+			return true
+		}
+		replayed++
+		return false
+	})
+	if err == nil {
+		err = replayErr
+	}
+	if err == nil && id != "" && replayed == 0 {
+		err = ErrDeadLetterNotFound
+	}
+	return replayed, err
+}
+
+func (b *DQueBackend) PurgeDeadLetters(id string) (int, error) {
+	b.deadMu.Lock()
+	defer b.deadMu.Unlock()
+	purged := 0
+	err := b.drainDeadLetters(func(msg *WorkRequest) bool {
+		if id != "" && msg.ID != id {
+			return true
+		}
+		purged++
+		return false
+	})
+	if err == nil && id != "" && purged == 0 {
+		err = ErrDeadLetterNotFound
+	}
+	return purged, err
+}
@@ -0,0 +1,132 @@
+package workers
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+type JobState string
+
+const (
+	JOB_SCHEDULED JobState = "scheduled" // Waiting for its scheduled time; see WorkQueue.EnqueueAt()
+	JOB_PENDING   JobState = "pending"   // On the queue, waiting to run
+	JOB_RUNNING   JobState = "running"   // Its handlers are running
+	JOB_RETRYING  JobState = "retrying"  // Failed, and waiting out a backoff before it runs again
+	JOB_SUCCEEDED JobState = "succeeded" // Every handler succeeded
+	JOB_FAILED    JobState = "failed"    // Ran out of attempts, and was moved to the dead-letter queue
+	JOB_CANCELLED JobState = "cancelled" // Cancelled before it ran; see WorkQueue.Cancel()
+)
+
+// The last known state of a work request, keyed by its ID
+type JobStatus struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	UserID       string    `json:"userId,omitempty"` // The user who submitted the work, if any
+	State        JobState  `json:"state"`
+	Attempts     int       `json:"attempts"`
+	Progress     float64   `json:"progress"` // Set by handlers through WorkQueue.ReportProgress()
+	EnqueuedOn   time.Time `json:"enqueuedOn"`
+	ScheduledFor time.Time `json:"scheduledFor"` // For scheduled jobs, when they're due to run
+	StartedOn    time.Time `json:"startedOn"`
+	EndedOn      time.Time `json:"endedOn"`
+	UpdatedOn    time.Time `json:"updatedOn"`
+	Messages     []string  `json:"messages"`
+	Result       any       `json:"result"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Returns true if the job has succeeded, failed for good, or been cancelled
+func (js JobStatus) Finished() bool {
+	return js.State == JOB_SUCCEEDED || js.State == JOB_FAILED || js.State == JOB_CANCELLED
+}
+
+// Selects jobs from a JobStatusStore. Empty fields match everything.
+type JobFilter struct {
+	Type   string
+	UserID string
+	State  JobState
+	Limit  int
+}
+
+func (f JobFilter) matches(js JobStatus) bool {
+	return (f.Type == "" || f.Type == js.Type) &&
+		(f.UserID == "" || f.UserID == js.UserID) &&
+		(f.State == "" || f.State == js.State)
+}
+
+// Sorts jobs newest first and applies the filter's limit
+func (f JobFilter) apply(jobs []JobStatus) []JobStatus {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].EnqueuedOn.After(jobs[j].EnqueuedOn)
+	})
+	if f.Limit > 0 && len(jobs) > f.Limit {
+		jobs = jobs[:f.Limit]
+	}
+	return jobs
+}
+
+// Stores job statuses for a WorkQueue
+type JobStatusStore interface {
+	Save(status JobStatus) error
+	Get(id string) (JobStatus, error) // Returns ErrJobNotFound if there is no status for the ID
+	List(filter JobFilter) ([]JobStatus, error)
+	Prune(before time.Time) (int, error) // Deletes finished jobs last updated before a time
+}
+
+// A JobStatusStore that keeps statuses in memory, and so loses them on restart
+type MemoryJobStatusStore struct {
+	sync.RWMutex
+	jobs map[string]JobStatus
+}
+
+func NewMemoryJobStatusStore() *MemoryJobStatusStore {
+	return &MemoryJobStatusStore{
+		jobs: make(map[string]JobStatus),
+	}
+}
+
+func (ms *MemoryJobStatusStore) Save(status JobStatus) error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.jobs[status.ID] = status
+	return nil
+}
+
+func (ms *MemoryJobStatusStore) Get(id string) (JobStatus, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	js, ok := ms.jobs[id]
+	if !ok {
+		return JobStatus{}, ErrJobNotFound
+	}
+	return js, nil
+}
+
+func (ms *MemoryJobStatusStore) List(filter JobFilter) ([]JobStatus, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	res := make([]JobStatus, 0)
+	for _, js := range ms.jobs {
+		if filter.matches(js) {
+			res = append(res, js)
+		}
+	}
+	return filter.apply(res), nil
+}
+
+func (ms *MemoryJobStatusStore) Prune(before time.Time) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	n := 0
+	for id, js := range ms.jobs {
+		if js.Finished() && js.UpdatedOn.Before(before) {
+			delete(ms.jobs, id)
+			n++
+		}
+	}
+	return n, nil
+}
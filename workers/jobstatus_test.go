@@ -0,0 +1,167 @@
+package workers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJobStatus(t *testing.T) {
+	dir := t.TempDir()
+	wq, err := New("test", dir, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range wq.Status {
+		}
+	}()
+
+	var mu sync.Mutex
+	seen := make(map[string][]JobState)
+	wq.OnStatusChange(func(js JobStatus) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[js.ID] = append(seen[js.ID], js.State)
+	})
+	wq.SetRetryPolicy("export", RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	wq.AddWorkFunc("export", func(msg *WorkRequest) WorkStatusReport {
+		wq.ReportProgress(msg.ID, 0.5, "halfway")
+		if msg.Data.(string) == "fail" {
+			return WorkStatusReport{Type: msg.Type, ID: msg.ID, Messages: []string{"giving up"}, Error: errors.New("disk full")}
+		}
+		return WorkStatusReport{Type: msg.Type, ID: msg.ID, Messages: []string{"done"}, Result: "export.csv"}
+	})
+
+	ok := NewWorkRequest("export", "ok")
+	ok.UserID = "u1"
+	fail := NewWorkRequest("export", "fail")
+	for _, msg := range []*WorkRequest{ok, fail} {
+		if err := wq.Enqueue(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "jobs to finish", func() bool {
+		a, _ := wq.JobStatus(ok.ID)
+		b, _ := wq.JobStatus(fail.ID)
+		return a.Finished() && b.Finished()
+	})
+
+	js, err := wq.JobStatus(ok.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if js.State != JOB_SUCCEEDED || js.UserID != "u1" || js.Result != "export.csv" || js.Progress != 1 ||
+		len(js.Messages) != 2 || js.StartedOn.IsZero() || js.EndedOn.Before(js.StartedOn) {
+		t.Fatalf("unexpected status %+v", js)
+	}
+	js, _ = wq.JobStatus(fail.ID)
+	if js.State != JOB_FAILED || js.Attempts != 2 || js.Error != "disk full" {
+		t.Fatalf("unexpected status %+v", js)
+	}
+	mu.Lock()
+	states := seen[fail.ID]
+	mu.Unlock()
+	expected := []JobState{JOB_PENDING, JOB_RUNNING, JOB_RUNNING, JOB_RETRYING, JOB_RUNNING, JOB_RUNNING, JOB_FAILED}
+	if len(states) != len(expected) {
+		t.Fatalf("unexpected state changes %v", states)
+	}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Fatalf("unexpected state changes %v", states)
+		}
+	}
+	if _, err := wq.JobStatus("nope"); err != ErrJobNotFound {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+
+	// statuses survive a restart, and can be listed and pruned
+	store, err := NewFileJobStatusStore(dir + "/test-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := store.List(JobFilter{State: JOB_FAILED})
+	if err != nil || len(jobs) != 1 || jobs[0].ID != fail.ID {
+		t.Fatalf("unexpected jobs %v, %v", jobs, err)
+	}
+	if _, err := store.Get("../test"); err != ErrJobNotFound {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+	n, err := store.Prune(time.Now().Add(time.Minute))
+	if err != nil || n != 2 {
+		t.Fatalf("expected to prune 2 jobs, pruned %d: %v", n, err)
+	}
+}
+
+func TestScheduledWork(t *testing.T) {
+	wq, err := New("test", t.TempDir(), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range wq.Status {
+		}
+	}()
+	var mu sync.Mutex
+	ran := make(map[string]time.Time)
+	wq.AddWorkFunc("remind", func(msg *WorkRequest) WorkStatusReport {
+		mu.Lock()
+		defer mu.Unlock()
+		ran[msg.ID] = time.Now()
+		return WorkStatusReport{Type: msg.Type, ID: msg.ID}
+	})
+
+	start := time.Now()
+	soon := NewWorkRequest("remind", "soon")
+	cancelled := NewWorkRequest("remind", "cancelled")
+	later := NewWorkRequest("remind", "later")
+	if err := wq.EnqueueAfter(soon, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := wq.EnqueueAfter(cancelled, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := wq.EnqueueAt(later, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if js, _ := wq.JobStatus(soon.ID); js.State != JOB_SCHEDULED || wq.ScheduledDepth() != 3 {
+		t.Fatalf("unexpected status %+v", js)
+	}
+	if err := wq.Cancel(cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "scheduled work to run", func() bool {
+		js, _ := wq.JobStatus(soon.ID)
+		return js.State == JOB_SUCCEEDED
+	})
+	mu.Lock()
+	if ran[soon.ID].Sub(start) < 50*time.Millisecond {
+		t.Fatal("scheduled work ran early")
+	}
+	if _, ok := ran[cancelled.ID]; ok {
+		t.Fatal("cancelled work ran")
+	}
+	mu.Unlock()
+	if err := wq.Cancel(soon.ID); err != ErrJobNotCancellable {
+		t.Fatalf("expected ErrJobNotCancellable, got %v", err)
+	}
+	if err := wq.Cancel("nope"); err != ErrJobNotFound {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+
+	// the schedule is rebuilt from disk on restart
+	if wq.ScheduledDepth() != 1 {
+		t.Fatalf("expected 1 scheduled request, got %d", wq.ScheduledDepth())
+	}
+	b := wq.backend.(*DQueBackend)
+	reloaded := &DQueBackend{schedDir: b.schedDir, scheduled: make(map[string]scheduledEntry)}
+	if err := reloaded.loadScheduled(); err != nil {
+		t.Fatal(err)
+	}
+	b.schedMu.Lock()
+	defer b.schedMu.Unlock()
+	if len(reloaded.scheduled) != 1 || !reloaded.scheduled[later.ID].runAt.Equal(b.scheduled[later.ID].runAt) {
+		t.Fatalf("unexpected schedule after reload %v", reloaded.scheduled)
+	}
+}
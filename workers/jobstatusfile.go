@@ -0,0 +1,119 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrInvalidJobID = errors.New("invalid job ID")
+
+/*
+A JobStatusStore that writes each status to its own JSON file in a directory, so statuses survive restarts along with
+the queue itself. This is the default store for a WorkQueue.
+*/
+type FileJobStatusStore struct {
+	dir string
+}
+
+func NewFileJobStatusStore(dir string) (*FileJobStatusStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileJobStatusStore{dir: dir}, nil
+}
+
+func (fs *FileJobStatusStore) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", ErrInvalidJobID
+	}
+	return filepath.Join(fs.dir, id+".json"), nil
+}
+
+func (fs *FileJobStatusStore) Save(status JobStatus) error {
+	path, err := fs.path(status.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	// write and rename, so a reader never sees a partial file
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (fs *FileJobStatusStore) Get(id string) (JobStatus, error) {
+	path, err := fs.path(id)
+	if err != nil {
+		return JobStatus{}, ErrJobNotFound
+	}
+	return fs.read(path)
+}
+
+func (fs *FileJobStatusStore) read(path string) (JobStatus, error) {
+	js := JobStatus{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return js, ErrJobNotFound
+	}
+	if err != nil {
+		return js, err
+	}
+	err = json.Unmarshal(data, &js)
+	return js, err
+}
+
+// Calls fn with every stored status, skipping any that can't be read
+func (fs *FileJobStatusStore) each(fn func(path string, js JobStatus)) error {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(fs.dir, e.Name())
+		js, err := fs.read(path)
+		if err != nil {
+			continue
+		}
+		fn(path, js)
+	}
+	return nil
+}
+
+func (fs *FileJobStatusStore) List(filter JobFilter) ([]JobStatus, error) {
+	res := make([]JobStatus, 0)
+	err := fs.each(func(path string, js JobStatus) {
+		if filter.matches(js) {
+			res = append(res, js)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filter.apply(res), nil
+}
+
+func (fs *FileJobStatusStore) Prune(before time.Time) (int, error) {
+	n := 0
+	err := fs.each(func(path string, js JobStatus) {
+		if js.Finished() && js.UpdatedOn.Before(before) {
+			if os.Remove(path) == nil {
+				n++
+			}
+		}
+	})
+	return n, err
+}
@@ -0,0 +1,124 @@
+package workers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/highgrav/taproot/dbutils"
+	"time"
+)
+
+/*
+A JobStatusStore that keeps statuses in a database table, so that every instance sharing a SQLBackend sees the same
+statuses. Supports the same databases as SQLBackend.
+*/
+type SQLJobStatusStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+	table   string
+}
+
+// Creates a job status store using a table in an open database, creating the table if it doesn't exist
+func NewSQLJobStatusStore(db *sql.DB, driver string, table string) (*SQLJobStatusStore, error) {
+	dialect, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	err = dbutils.CheckTableName(table)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS " + table + ` (
+		id VARCHAR(64) PRIMARY KEY,
+		msg_type VARCHAR(255) NOT NULL,
+		user_id VARCHAR(255) NOT NULL,
+		state VARCHAR(16) NOT NULL,
+		enqueued_at BIGINT NOT NULL,
+		updated_at BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLJobStatusStore{db: db, dialect: dialect, table: table}, nil
+}
+
+func (ss *SQLJobStatusStore) sql(query string) string {
+	return ss.dialect.Bind(query, ss.table)
+}
+
+func (ss *SQLJobStatusStore) Save(status JobStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	_, err = ss.db.Exec(ss.sql(`INSERT INTO {table} (id, msg_type, user_id, state, enqueued_at, updated_at, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET msg_type = excluded.msg_type, user_id = excluded.user_id, state = excluded.state,
+		enqueued_at = excluded.enqueued_at, updated_at = excluded.updated_at, data = excluded.data`),
+		status.ID, status.Type, status.UserID, string(status.State), status.EnqueuedOn.UnixNano(), status.UpdatedOn.UnixNano(), string(data))
+	return err
+}
+
+func (ss *SQLJobStatusStore) Get(id string) (JobStatus, error) {
+	js := JobStatus{}
+	var data string
+	err := ss.db.QueryRow(ss.sql("SELECT data FROM {table} WHERE id = ?"), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return js, ErrJobNotFound
+	}
+	if err != nil {
+		return js, err
+	}
+	err = json.Unmarshal([]byte(data), &js)
+	return js, err
+}
+
+func (ss *SQLJobStatusStore) List(filter JobFilter) ([]JobStatus, error) {
+	query := "SELECT data FROM {table} WHERE 1 = 1"
+	args := make([]any, 0)
+	if filter.Type != "" {
+		query += " AND msg_type = ?"
+		args = append(args, filter.Type)
+	}
+	if filter.UserID != "" {
+		query += " AND user_id = ?"
+		args = append(args, filter.UserID)
+	}
+	if filter.State != "" {
+		query += " AND state = ?"
+		args = append(args, string(filter.State))
+	}
+	query += " ORDER BY enqueued_at DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+	rows, err := ss.db.Query(ss.sql(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]JobStatus, 0)
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		js := JobStatus{}
+		if json.Unmarshal([]byte(data), &js) == nil {
+			res = append(res, js)
+		}
+	}
+	return res, rows.Err()
+}
+
+func (ss *SQLJobStatusStore) Prune(before time.Time) (int, error) {
+	res, err := ss.db.Exec(ss.sql("DELETE FROM {table} WHERE state IN (?, ?, ?) AND updated_at < ?"),
+		string(JOB_SUCCEEDED), string(JOB_FAILED), string(JOB_CANCELLED), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
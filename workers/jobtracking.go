@@ -0,0 +1,193 @@
+package workers
+
+import (
+	"context"
+	"github.com/highgrav/taproot/logging"
+	"time"
+)
+
+// How long the statuses of finished jobs are kept, unless the queue is configured otherwise
+const WORK_DEFAULT_STATUS_RETENTION time.Duration = 7 * 24 * time.Hour
+
+// Called with a job's status each time it changes
+type JobStatusListener func(status JobStatus)
+
+// Replaces the queue's job status store
+func (wq *WorkQueue) SetJobStatusStore(store JobStatusStore) {
+	wq.statusMu.Lock()
+	defer wq.statusMu.Unlock()
+	wq.statusStore = store
+}
+
+// Sets how long the statuses of finished jobs are kept. Zero or less keeps them forever.
+func (wq *WorkQueue) SetStatusRetention(d time.Duration) {
+	wq.statusMu.Lock()
+	defer wq.statusMu.Unlock()
+	wq.statusRetention = d
+}
+
+/*
+Adds a function that is called whenever a job's status changes. Listeners are called in order, on the goroutine
+that changed the status, so they shouldn't block.
+*/
+func (wq *WorkQueue) OnStatusChange(fn JobStatusListener) {
+	wq.listenerMu.Lock()
+	defer wq.listenerMu.Unlock()
+	wq.statusListeners = append(wq.statusListeners, fn)
+}
+
+// Gets the status of the job with a work request ID
+func (wq *WorkQueue) JobStatus(id string) (JobStatus, error) {
+	wq.statusMu.Lock()
+	store := wq.statusStore
+	wq.statusMu.Unlock()
+	return store.Get(id)
+}
+
+// Lists job statuses, newest first
+func (wq *WorkQueue) Jobs(filter JobFilter) ([]JobStatus, error) {
+	wq.statusMu.Lock()
+	store := wq.statusStore
+	wq.statusMu.Unlock()
+	return store.List(filter)
+}
+
+/*
+Records how far along a running job is, from 0 to 1, with an optional message. Handlers call this to drive progress
+bars; the update goes to the status listeners like any other change.
+*/
+func (wq *WorkQueue) ReportProgress(id string, progress float64, message string) error {
+	_, err := wq.JobStatus(id)
+	if err != nil {
+		return err
+	}
+	wq.updateJobStatus(id, func(js *JobStatus) {
+		js.Progress = progress
+		if message != "" {
+			js.Messages = append(js.Messages, message)
+		}
+	})
+	return nil
+}
+
+// Applies a change to a job's status, saves it, and passes it on to the listeners
+func (wq *WorkQueue) updateJobStatus(id string, fn func(js *JobStatus)) {
+	wq.updateJobStatusIf(id, func(js *JobStatus) bool {
+		fn(js)
+		return true
+	})
+}
+
+// Like updateJobStatus(), but leaves the status alone if fn returns false. Returns the result of fn.
+func (wq *WorkQueue) updateJobStatusIf(id string, fn func(js *JobStatus) bool) bool {
+	wq.statusMu.Lock()
+	js, err := wq.statusStore.Get(id)
+	if err != nil {
+		js = JobStatus{ID: id}
+	}
+	if !fn(&js) {
+		wq.statusMu.Unlock()
+		return false
+	}
+	js.UpdatedOn = time.Now()
+	err = wq.statusStore.Save(js)
+	wq.statusMu.Unlock()
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not save status of work '"+id+"': "+err.Error())
+	}
+
+	wq.listenerMu.RLock()
+	listeners := wq.statusListeners
+	wq.listenerMu.RUnlock()
+	for _, fn := range listeners {
+		fn(js)
+	}
+	return true
+}
+
+func (wq *WorkQueue) jobPending(msg *WorkRequest) {
+	wq.updateJobStatus(msg.ID, func(js *JobStatus) {
+		js.Type = msg.Type
+		js.UserID = msg.UserID
+		js.State = JOB_PENDING
+		js.Attempts = msg.Attempts
+		if js.EnqueuedOn.IsZero() || msg.Attempts == 0 {
+			js.EnqueuedOn = time.Now()
+		}
+	})
+}
+
+// Marks a job as running, unless it has been cancelled. Returns false if it has.
+func (wq *WorkQueue) jobStarted(msg *WorkRequest) bool {
+	return wq.updateJobStatusIf(msg.ID, func(js *JobStatus) bool {
+		if js.State == JOB_CANCELLED {
+			return false
+		}
+		js.Type = msg.Type
+		js.UserID = msg.UserID
+		js.State = JOB_RUNNING
+		js.Attempts = msg.Attempts
+		js.Progress = 0
+		js.StartedOn = msg.LastAttemptOn
+		js.EndedOn = time.Time{}
+		js.Messages = nil
+		js.Result = nil
+		js.Error = ""
+		return true
+	})
+}
+
+// Records the outcome of an attempt, collecting the messages, results and errors from its handlers' reports
+func (wq *WorkQueue) jobAttempted(msg *WorkRequest, results []WorkStatusReport, state JobState) {
+	wq.updateJobStatus(msg.ID, func(js *JobStatus) {
+		js.State = state
+		var vals []any
+		for _, res := range results {
+			js.Messages = append(js.Messages, res.Messages...)
+			if res.Result != nil {
+				vals = append(vals, res.Result)
+			}
+		}
+		switch len(vals) {
+		case 0:
+			js.Result = nil
+		case 1:
+			js.Result = vals[0]
+		default:
+			js.Result = vals
+		}
+		js.Error = msg.LastError
+		if state == JOB_SUCCEEDED {
+			js.Error = ""
+			js.Progress = 1
+		}
+		if state != JOB_RETRYING {
+			js.EndedOn = time.Now()
+		}
+	})
+}
+
+func (wq *WorkQueue) jobFailed(msg *WorkRequest) {
+	wq.updateJobStatus(msg.ID, func(js *JobStatus) {
+		js.Type = msg.Type
+		js.State = JOB_FAILED
+		js.Error = msg.LastError
+		js.EndedOn = time.Now()
+	})
+}
+
+// Deletes the statuses of jobs that finished longer ago than the retention period
+func (wq *WorkQueue) pruneJobStatuses() {
+	for range time.Tick(time.Hour) {
+		wq.statusMu.Lock()
+		store, retention := wq.statusStore, wq.statusRetention
+		wq.statusMu.Unlock()
+		if retention <= 0 {
+			continue
+		}
+		_, err := store.Prune(time.Now().Add(-retention))
+		if err != nil {
+			logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not prune job statuses: "+err.Error())
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package workers
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrQueueClosed = errors.New("work queue is closed")
+
+/*
+QueueBackend stores the messages for a WorkQueue: the live queue, messages scheduled to run later, and the dead-letter
+queue. DQueBackend keeps them on local disk for a single server instance; SQLBackend keeps them in a database, so
+several instances can share one queue. Implementations must be safe for concurrent use.
+*/
+type QueueBackend interface {
+	// Adds a message to the queue, to be dequeued once runAt has passed (or right away, if it's zero)
+	Enqueue(msg *WorkRequest, runAt time.Time) error
+	// Removes and returns the next message that's due, blocking until there is one. Returns ErrQueueClosed once closed.
+	Dequeue() (*WorkRequest, error)
+	// Removes a message that's waiting to run. Returns false if there's no such message, or it can't be removed.
+	Remove(id string) (bool, error)
+	// Returns the number of messages that are due
+	Depth() (int, error)
+	// Returns the number of messages waiting for their time to run
+	ScheduledDepth() (int, error)
+
+	// Adds a message to the dead-letter queue
+	DeadLetter(msg *WorkRequest) error
+	DeadLetterDepth() (int, error)
+	// Lists dead letters, oldest first, up to limit (zero for all of them)
+	DeadLetters(limit int) ([]WorkRequest, error)
+	/*
+		Moves the dead letter with an ID (or all of them, if id is empty) back onto the queue, passing each to prepare
+		first. Returns the number moved, or ErrDeadLetterNotFound if there's no dead letter with the ID.
+	*/
+	ReplayDeadLetters(id string, prepare func(msg *WorkRequest)) (int, error)
+	// Deletes the dead letter with an ID, or all of them. Returns the number deleted.
+	PurgeDeadLetters(id string) (int, error)
+
+	// Closes the backend, unblocking any call to Dequeue()
+	Close() error
+}
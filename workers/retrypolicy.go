@@ -0,0 +1,49 @@
+package workers
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+A RetryPolicy controls what happens when a handler for a message type returns a WorkStatusReport with an Error.
+The message is run again (with all of its handlers, so handlers should be idempotent) until it has been attempted
+MaxAttempts times, waiting an exponentially increasing backoff between attempts; after that, it is moved to the
+dead-letter queue.
+*/
+type RetryPolicy struct {
+	MaxAttempts    int           // Total number of attempts, including the first; values below 1 are treated as 1
+	InitialBackoff time.Duration // Delay before the first retry
+	MaxBackoff     time.Duration // Upper limit on the delay between attempts (zero for no limit)
+	Multiplier     float64       // Growth factor for each further retry (values below 1 are treated as 2)
+	Jitter         float64       // Fraction of each delay, from 0 to 1, that is randomized to spread out retries
+}
+
+// The policy used for message types without one of their own: failed messages go straight to the dead-letter queue
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 1,
+}
+
+// Returns true if a message that has been attempted this many times should be tried again
+func (rp RetryPolicy) ShouldRetry(attempts int) bool {
+	return attempts < rp.MaxAttempts
+}
+
+// Returns how long to wait before the next attempt, after the given number of attempts have failed
+func (rp RetryPolicy) Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	mult := rp.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+	delay := float64(rp.InitialBackoff) * math.Pow(mult, float64(attempts-1))
+	if rp.MaxBackoff > 0 && delay > float64(rp.MaxBackoff) {
+		delay = float64(rp.MaxBackoff)
+	}
+	jitter := math.Min(math.Max(rp.Jitter, 0), 1)
+	delay = delay - (delay * jitter * rand.Float64())
+	return time.Duration(delay)
+}
@@ -0,0 +1,84 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"github.com/highgrav/taproot/logging"
+	"time"
+)
+
+var ErrJobNotCancellable = errors.New("job has already started")
+
+/*
+Places a message on the queue to be run at a given time. Until then, the message is held by the queue's backend, so
+it survives restarts; a message that comes due while the server is down runs when it starts again. Times in the past
+run as soon as possible.
+*/
+func (wq *WorkQueue) EnqueueAt(msg *WorkRequest, runAt time.Time) error {
+	if !runAt.After(time.Now()) {
+		return wq.Enqueue(msg)
+	}
+	wq.updateJobStatus(msg.ID, func(js *JobStatus) {
+		js.Type = msg.Type
+		js.UserID = msg.UserID
+		js.State = JOB_SCHEDULED
+		js.Attempts = msg.Attempts
+		js.EnqueuedOn = time.Now()
+		js.ScheduledFor = runAt
+	})
+	err := wq.backend.Enqueue(msg, runAt)
+	if err != nil {
+		msg.LastError = err.Error()
+		wq.jobFailed(msg)
+	}
+	return err
+}
+
+// Places a message on the queue to be run once a duration has passed. See EnqueueAt().
+func (wq *WorkQueue) EnqueueAfter(msg *WorkRequest, d time.Duration) error {
+	return wq.EnqueueAt(msg, time.Now().Add(d))
+}
+
+// Returns the number of messages waiting for their scheduled time, including retries waiting out their backoff
+func (wq *WorkQueue) ScheduledDepth() int {
+	n, err := wq.backend.ScheduledDepth()
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not get scheduled work depth: "+err.Error())
+	}
+	return n
+}
+
+/*
+Cancels a job that hasn't started yet, whether it's scheduled, waiting on the queue, or waiting to be retried. Returns
+ErrJobNotFound if there's no such job, or ErrJobNotCancellable if it's already running or finished.
+*/
+func (wq *WorkQueue) Cancel(id string) error {
+	removed, err := wq.backend.Remove(id)
+	if err != nil {
+		return err
+	}
+	var state JobState
+	var msgType string
+	cancelled := wq.updateJobStatusIf(id, func(js *JobStatus) bool {
+		state, msgType = js.State, js.Type
+		switch js.State {
+		case JOB_SCHEDULED, JOB_PENDING, JOB_RETRYING:
+			js.State = JOB_CANCELLED
+			js.EndedOn = time.Now()
+			return true
+		}
+		return false
+	})
+	if removed && state == JOB_RETRYING {
+		wq.Metrics.requeued(msgType)
+	}
+	if cancelled || removed {
+		// a request the backend couldn't remove stays queued, and is skipped when it's dequeued
+		logging.LogToDeck(context.Background(), "info", "WORK", "info", "cancelled work '"+id+"'")
+		return nil
+	}
+	if state == "" {
+		return ErrJobNotFound
+	}
+	return ErrJobNotCancellable
+}
@@ -0,0 +1,323 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"github.com/highgrav/taproot/dbutils"
+	"github.com/highgrav/taproot/logging"
+	"sync"
+	"time"
+)
+
+// How often a SQLBackend checks for messages enqueued by other instances, unless configured otherwise
+const WORK_DEFAULT_POLL_INTERVAL time.Duration = time.Second
+
+/*
+SQLBackend is a QueueBackend that keeps messages in a database table, so that several server instances can share one
+durable queue. Postgres and SQLite are supported; on Postgres, instances claim messages with SELECT ... FOR UPDATE
+SKIP LOCKED, so they never block on (or double-process) each other's messages. SQLite is best suited to tests and
+single-machine deployments.
+
+Messages are encoded with encoding/gob, as with DQueBackend, so custom Data types need to be registered with
+gob.Register(). Scheduled messages and dead letters live in the same table, distinguished by their run time and a
+dead flag. A message is deleted from the table when it's dequeued, so, as with DQueBackend, a message that's running
+when its server stops isn't run again.
+*/
+type SQLBackend struct {
+	PollInterval time.Duration
+	db           *sql.DB
+	dialect      sqlDialect
+	table        string
+	wake         chan bool
+	closeOnce    sync.Once
+	closed       chan bool
+}
+
+/*
+Creates a SQL backend using a table in an open database, creating the table if it doesn't exist. The driver is the
+name the database was opened with, such as "postgres" or "sqlite3".
+*/
+func NewSQLBackend(db *sql.DB, driver string, table string) (*SQLBackend, error) {
+	dialect, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	err = dbutils.CheckTableName(table)
+	if err != nil {
+		return nil, err
+	}
+	b := &SQLBackend{
+		PollInterval: WORK_DEFAULT_POLL_INTERVAL,
+		db:           db,
+		dialect:      dialect,
+		table:        table,
+		wake:         make(chan bool, 1),
+		closed:       make(chan bool),
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS " + table + ` (
+		id VARCHAR(64) PRIMARY KEY,
+		msg_type VARCHAR(255) NOT NULL,
+		dead INTEGER NOT NULL DEFAULT 0,
+		run_at BIGINT NOT NULL,
+		enqueued_at BIGINT NOT NULL,
+		data ` + dialect.blobType + ` NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS " + table + "_due ON " + table + " (dead, run_at)")
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Fills in the table name and rewrites the parameters of a query for the database
+func (b *SQLBackend) sql(query string) string {
+	return b.dialect.Bind(query, b.table)
+}
+
+func (b *SQLBackend) exec(query string, args ...any) (sql.Result, error) {
+	return b.db.Exec(b.sql(query), args...)
+}
+
+func (b *SQLBackend) count(query string, args ...any) (int, error) {
+	n := 0
+	err := b.db.QueryRow(b.sql(query), args...).Scan(&n)
+	return n, err
+}
+
+func encodeRequest(msg *WorkRequest) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(msg)
+	return buf.Bytes(), err
+}
+
+func decodeRequest(data []byte) (*WorkRequest, error) {
+	msg := &WorkRequest{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(msg)
+	return msg, err
+}
+
+func (b *SQLBackend) Enqueue(msg *WorkRequest, runAt time.Time) error {
+	now := time.Now()
+	if runAt.IsZero() {
+		runAt = now
+	}
+	data, err := encodeRequest(msg)
+	if err != nil {
+		return err
+	}
+	_, err = b.exec("INSERT INTO {table} (id, msg_type, dead, run_at, enqueued_at, data) VALUES (?, ?, 0, ?, ?, ?)",
+		msg.ID, msg.Type, runAt.UnixNano(), now.UnixNano(), data)
+	if err != nil {
+		return err
+	}
+	select {
+	case b.wake <- true:
+	default:
+	}
+	return nil
+}
+
+// Waits for messages enqueued by this instance, or polls for ones enqueued by others
+func (b *SQLBackend) Dequeue() (*WorkRequest, error) {
+	for {
+		select {
+		case <-b.closed:
+			return nil, ErrQueueClosed
+		default:
+		}
+		msg, err := b.claim()
+		if err != nil || msg != nil {
+			return msg, err
+		}
+		timer := time.NewTimer(b.PollInterval)
+		select {
+		case <-timer.C:
+		case <-b.wake:
+			timer.Stop()
+		case <-b.closed:
+			timer.Stop()
+			return nil, ErrQueueClosed
+		}
+	}
+}
+
+// Takes the next message that's due off the queue, or returns nil if there isn't one
+func (b *SQLBackend) claim() (*WorkRequest, error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	var data []byte
+	err = tx.QueryRow(b.sql("SELECT id, data FROM {table} WHERE dead = 0 AND run_at <= ? ORDER BY run_at, enqueued_at LIMIT 1"+b.dialect.skipLocked),
+		time.Now().UnixNano()).Scan(&id, &data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := decodeRequest(data)
+	if err != nil {
+		// keep it where it can be seen and replayed, rather than losing it or retrying it forever
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not decode work '"+id+"', moving to dead-letter queue: "+err.Error())
+		_, err = tx.Exec(b.sql("UPDATE {table} SET dead = 1 WHERE id = ?"), id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, tx.Commit()
+	}
+	_, err = tx.Exec(b.sql("DELETE FROM {table} WHERE id = ?"), id)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (b *SQLBackend) Remove(id string) (bool, error) {
+	res, err := b.exec("DELETE FROM {table} WHERE id = ? AND dead = 0", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (b *SQLBackend) Depth() (int, error) {
+	return b.count("SELECT COUNT(*) FROM {table} WHERE dead = 0 AND run_at <= ?", time.Now().UnixNano())
+}
+
+func (b *SQLBackend) ScheduledDepth() (int, error) {
+	return b.count("SELECT COUNT(*) FROM {table} WHERE dead = 0 AND run_at > ?", time.Now().UnixNano())
+}
+
+// Stops Dequeue(). The database itself is left open, since it belongs to the caller.
+func (b *SQLBackend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+	})
+	return nil
+}
+
+func (b *SQLBackend) DeadLetter(msg *WorkRequest) error {
+	data, err := encodeRequest(msg)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	_, err = b.exec("INSERT INTO {table} (id, msg_type, dead, run_at, enqueued_at, data) VALUES (?, ?, 1, ?, ?, ?)",
+		msg.ID, msg.Type, now, now, data)
+	return err
+}
+
+func (b *SQLBackend) DeadLetterDepth() (int, error) {
+	return b.count("SELECT COUNT(*) FROM {table} WHERE dead = 1")
+}
+
+// Lists dead letters, oldest first, skipping any that can't be decoded. A limit of zero or less lists all of them.
+func (b *SQLBackend) deadLetters(id string, limit int) ([]*WorkRequest, error) {
+	query := "SELECT data FROM {table} WHERE dead = 1"
+	args := make([]any, 0)
+	if id != "" {
+		query += " AND id = ?"
+		args = append(args, id)
+	}
+	query += " ORDER BY enqueued_at"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := b.db.Query(b.sql(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*WorkRequest, 0)
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := decodeRequest(data)
+		if err != nil {
+			continue
+		}
+		res = append(res, msg)
+	}
+	return res, rows.Err()
+}
+
+func (b *SQLBackend) DeadLetters(limit int) ([]WorkRequest, error) {
+	msgs, err := b.deadLetters("", limit)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]WorkRequest, len(msgs))
+	for i, msg := range msgs {
+		res[i] = *msg
+	}
+	return res, nil
+}
+
+func (b *SQLBackend) ReplayDeadLetters(id string, prepare func(msg *WorkRequest)) (int, error) {
+	msgs, err := b.deadLetters(id, 0)
+	if err != nil {
+		return 0, err
+	}
+	if id != "" && len(msgs) == 0 {
+		return 0, ErrDeadLetterNotFound
+	}
+	replayed := 0
+	for _, msg := range msgs {
+		prepare(msg)
+		data, err := encodeRequest(msg)
+		if err != nil {
+			return replayed, err
+		}
+		// only replays a dead letter once, even if another instance is replaying at the same time
+		res, err := b.exec("UPDATE {table} SET dead = 0, run_at = ?, data = ? WHERE id = ? AND dead = 1", time.Now().UnixNano(), data, msg.ID)
+		if err != nil {
+			return replayed, err
+		}
+		n, _ := res.RowsAffected()
+		replayed += int(n)
+	}
+	if replayed > 0 {
+		select {
+		case b.wake <- true:
+		default:
+		}
+	}
+	return replayed, nil
+}
+
+func (b *SQLBackend) PurgeDeadLetters(id string) (int, error) {
+	query := "DELETE FROM {table} WHERE dead = 1"
+	args := make([]any, 0)
+	if id != "" {
+		query += " AND id = ?"
+		args = append(args, id)
+	}
+	res, err := b.exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && id != "" && n == 0 {
+		err = ErrDeadLetterNotFound
+	}
+	return int(n), err
+}
@@ -0,0 +1,129 @@
+package workers
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/highgrav/taproot/dbutils"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestRebind(t *testing.T) {
+	pg, _ := dialectFor("postgres")
+	if q := pg.Bind("SELECT a FROM {table} WHERE b = ? AND c <= ?"+pg.skipLocked, "t"); q != "SELECT a FROM t WHERE b = $1 AND c <= $2 FOR UPDATE SKIP LOCKED" {
+		t.Fatalf("unexpected query %s", q)
+	}
+	if _, err := dialectFor("oracle"); err != dbutils.ErrUnsupportedDriver {
+		t.Fatalf("expected ErrUnsupportedDriver, got %v", err)
+	}
+}
+
+func newSQLQueue(t *testing.T, db *sql.DB) *WorkQueue {
+	t.Helper()
+	backend, err := NewSQLBackend(db, "sqlite3", "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.PollInterval = 10 * time.Millisecond
+	store, err := NewSQLJobStatusStore(db, "sqlite3", "work_status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wq := NewWithBackend(backend, store)
+	t.Cleanup(func() { wq.Close() })
+	go func() {
+		for range wq.Status {
+		}
+	}()
+	return wq
+}
+
+func TestSQLBackend(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "work.db")+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := NewSQLBackend(db, "sqlite3", "work; DROP TABLE x"); err != dbutils.ErrInvalidTableName {
+		t.Fatalf("expected ErrInvalidTableName, got %v", err)
+	}
+
+	// two queues sharing a database stand in for two server instances
+	var mu sync.Mutex
+	runs := make(map[string]int)
+	handler := func(msg *WorkRequest) WorkStatusReport {
+		mu.Lock()
+		runs[msg.ID]++
+		mu.Unlock()
+		if msg.Data.(string) == "fail" {
+			return WorkStatusReport{Type: msg.Type, ID: msg.ID, Error: errors.New("failed")}
+		}
+		return WorkStatusReport{Type: msg.Type, ID: msg.ID, Result: msg.Data}
+	}
+	a, b := newSQLQueue(t, db), newSQLQueue(t, db)
+	a.AddWorkFunc("job", handler)
+	b.AddWorkFunc("job", handler)
+
+	ids := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		msg := NewWorkRequest("job", "ok")
+		if err := a.Enqueue(msg); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	later := NewWorkRequest("job", "later")
+	if err := a.EnqueueAfter(later, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	cancelled := NewWorkRequest("job", "cancelled")
+	if err := a.EnqueueAfter(cancelled, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Cancel(cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+	fail := NewWorkRequest("job", "fail")
+	if err := b.Enqueue(fail); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "work to finish", func() bool {
+		js, _ := b.JobStatus(later.ID)
+		return js.State == JOB_SUCCEEDED && a.DeadLetterDepth() == 1
+	})
+	mu.Lock()
+	for _, id := range append(ids, later.ID) {
+		if runs[id] != 1 {
+			t.Fatalf("work '%s' ran %d times", id, runs[id])
+		}
+	}
+	if runs[cancelled.ID] != 0 {
+		t.Fatal("cancelled work ran")
+	}
+	mu.Unlock()
+	// either instance can see the status of work the other ran
+	js, err := a.JobStatus(ids[0])
+	if err != nil || js.State != JOB_SUCCEEDED || js.Result != "ok" {
+		t.Fatalf("unexpected status %+v: %v", js, err)
+	}
+	if a.Depth() != 0 || a.ScheduledDepth() != 0 {
+		t.Fatalf("unexpected depths %d, %d", a.Depth(), a.ScheduledDepth())
+	}
+
+	dead, err := b.DeadLetters(0)
+	if err != nil || len(dead) != 1 || dead[0].ID != fail.ID || dead[0].LastError != "failed" {
+		t.Fatalf("unexpected dead letters %v: %v", dead, err)
+	}
+	if n, err := a.ReplayDeadLetters(fail.ID); err != nil || n != 1 {
+		t.Fatalf("replay failed: %d, %v", n, err)
+	}
+	waitFor(t, "replayed work to fail again", func() bool { return b.DeadLetterDepth() == 1 })
+	if n, err := b.PurgeDeadLetters(""); err != nil || n != 1 || a.DeadLetterDepth() != 0 {
+		t.Fatalf("purge failed: %d, %v", n, err)
+	}
+}
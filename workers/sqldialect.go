@@ -0,0 +1,24 @@
+package workers
+
+import (
+	"github.com/highgrav/taproot/dbutils"
+)
+
+// The differences between the databases the SQL backend and job status store support, beyond those in dbutils.SQLDialect
+type sqlDialect struct {
+	dbutils.SQLDialect
+	blobType   string
+	skipLocked string // appended to the query that claims a message, so other instances skip it rather than wait
+}
+
+func dialectFor(driver string) (sqlDialect, error) {
+	d, err := dbutils.DialectFor(driver)
+	if err != nil {
+		return sqlDialect{}, err
+	}
+	if d.Name == dbutils.SQL_DIALECT_POSTGRES {
+		return sqlDialect{SQLDialect: d, blobType: "BYTEA", skipLocked: " FOR UPDATE SKIP LOCKED"}, nil
+	}
+	// SQLite locks the whole database for writes, so there's nothing to skip
+	return sqlDialect{SQLDialect: d, blobType: "BLOB"}, nil
+}
@@ -1,4 +1,118 @@
 package workers
 
+import (
+	"sync"
+)
+
+// Counts for a single message type
+type WorkTypeMetrics struct {
+	Concurrency  int   `json:"concurrency"`
+	Running      int64 `json:"running"`
+	Retrying     int64 `json:"retrying"` // Waiting out a backoff before running again
+	Succeeded    int64 `json:"succeeded"`
+	Failed       int64 `json:"failed"` // Failed attempts, including ones that will be retried
+	Retried      int64 `json:"retried"`
+	DeadLettered int64 `json:"deadLettered"`
+}
+
+// A snapshot of a WorkQueue's metrics
+type WorkMetricsReport struct {
+	Queued       int                        `json:"queued"`
+	Scheduled    int                        `json:"scheduled"`
+	DeadLetters  int                        `json:"deadLetters"`
+	Running      int64                      `json:"running"`
+	Retrying     int64                      `json:"retrying"`
+	Succeeded    int64                      `json:"succeeded"`
+	Failed       int64                      `json:"failed"`
+	Retried      int64                      `json:"retried"`
+	DeadLettered int64                      `json:"deadLettered"`
+	Types        map[string]WorkTypeMetrics `json:"types"`
+}
+
+// WorkMetrics keeps running counts of the work a WorkQueue has handled, by message type.
 type WorkMetrics struct {
+	sync.Mutex
+	types map[string]*WorkTypeMetrics
+}
+
+func NewWorkMetrics() *WorkMetrics {
+	return &WorkMetrics{
+		types: make(map[string]*WorkTypeMetrics),
+	}
+}
+
+// Applies a change to the counts for a message type
+func (wm *WorkMetrics) update(msgType string, fn func(m *WorkTypeMetrics)) {
+	wm.Lock()
+	defer wm.Unlock()
+	m, ok := wm.types[msgType]
+	if !ok {
+		m = &WorkTypeMetrics{}
+		wm.types[msgType] = m
+	}
+	fn(m)
+}
+
+func (wm *WorkMetrics) started(msgType string) {
+	wm.update(msgType, func(m *WorkTypeMetrics) { m.Running++ })
+}
+
+func (wm *WorkMetrics) finished(msgType string, ok bool) {
+	wm.update(msgType, func(m *WorkTypeMetrics) {
+		m.Running--
+		if ok {
+			m.Succeeded++
+		} else {
+			m.Failed++
+		}
+	})
+}
+
+func (wm *WorkMetrics) retrying(msgType string) {
+	wm.update(msgType, func(m *WorkTypeMetrics) {
+		m.Retrying++
+		m.Retried++
+	})
+}
+
+func (wm *WorkMetrics) requeued(msgType string) {
+	wm.update(msgType, func(m *WorkTypeMetrics) {
+		// retries outlive a restart, but the counts don't
+		if m.Retrying > 0 {
+			m.Retrying--
+		}
+	})
+}
+
+func (wm *WorkMetrics) deadLettered(msgType string) {
+	wm.update(msgType, func(m *WorkTypeMetrics) { m.DeadLettered++ })
+}
+
+/*
+Returns the current counts, totalled and by message type. The queue depths come from the WorkQueue, since only it
+knows them; see WorkQueue.MetricsReport().
+*/
+func (wm *WorkMetrics) Snapshot(queued int, scheduled int, deadLetters int, concurrency func(string) int) WorkMetricsReport {
+	wm.Lock()
+	defer wm.Unlock()
+	rpt := WorkMetricsReport{
+		Queued:      queued,
+		Scheduled:   scheduled,
+		DeadLetters: deadLetters,
+		Types:       make(map[string]WorkTypeMetrics),
+	}
+	for k, v := range wm.types {
+		m := *v
+		if concurrency != nil {
+			m.Concurrency = concurrency(k)
+		}
+		rpt.Types[k] = m
+		rpt.Running += m.Running
+		rpt.Retrying += m.Retrying
+		rpt.Succeeded += m.Succeeded
+		rpt.Failed += m.Failed
+		rpt.Retried += m.Retried
+		rpt.DeadLettered += m.DeadLettered
+	}
+	return rpt
 }
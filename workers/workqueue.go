@@ -1,28 +1,98 @@
 package workers
 
 import (
-	"github.com/joncrlsn/dque"
+	"context"
+	"fmt"
+	"github.com/highgrav/taproot/common"
+	"github.com/highgrav/taproot/logging"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
+// Number of requests of a single type that run at once, unless the type is configured otherwise
+const WORK_DEFAULT_CONCURRENCY int = 8
+
 /*
 The WorkQueue is a centralized message queue that takes messages and dispatches them to specified functions.
-Messages are serialized to disk and are durable between restarts, but currently are limited to a single server instance.
+Messages are durable between restarts, and are stored by a QueueBackend: by default a DQueBackend, which keeps them on
+local disk for a single server instance, or a SQLBackend, which lets several instances share one queue.
+
+A queue created with New() or NewWithBackend() starts taking messages at once. One created with NewDeferred() or
+NewWithBackendDeferred() waits until Start() is called, so that handlers can be registered first; messages enqueued
+before then wait in the backend.
+
+Each message type has its own pool of workers. When every worker for a message's type is busy, the queue stops
+dequeuing until one frees up, so bursts of work wait in the backend rather than in memory.
+
+Messages can also be scheduled to run later, with EnqueueAt() and EnqueueAfter(), and cancelled until they start.
+The status of each request is tracked by its ID from the time it's enqueued; see JobStatus() and OnStatusChange().
 */
 type WorkQueue struct {
-	Status         chan WorkStatusReport
-	Metrics        *WorkMetrics
-	queue          *dque.DQue
-	workHandlers   map[string][]WorkHandler
-	resultHandlers map[string][]ResultHandler
+	Status             chan WorkStatusReport
+	Metrics            *WorkMetrics
+	backend            QueueBackend
+	handlerMu          sync.RWMutex
+	workHandlers       map[string][]WorkHandler
+	resultHandlers     map[string][]ResultHandler
+	retryMu            sync.RWMutex
+	retryPolicies      map[string]RetryPolicy
+	poolMu             sync.Mutex
+	pools              map[string]*common.WorkerPool[*WorkRequest, bool]
+	concurrency        map[string]int
+	defaultConcurrency int
+	statusMu           sync.Mutex
+	statusStore        JobStatusStore
+	statusRetention    time.Duration
+	listenerMu         sync.RWMutex
+	statusListeners    []JobStatusListener
+	startOnce          sync.Once
 }
 
 // Places a message on the queue for processing
 func (wq *WorkQueue) Enqueue(msg *WorkRequest) error {
-	return wq.queue.Enqueue(msg)
+	// the status is recorded first, since the message could start running as soon as it's on the queue
+	wq.jobPending(msg)
+	err := wq.backend.Enqueue(msg, time.Time{})
+	if err != nil {
+		msg.LastError = err.Error()
+		wq.jobFailed(msg)
+	}
+	return err
+}
+
+// Starts taking messages off the queue and dispatching them. Safe to call more than once.
+func (wq *WorkQueue) Start() {
+	wq.startOnce.Do(func() {
+		go wq.processMsgs()
+	})
+}
+
+// Returns the number of messages waiting on the queue
+func (wq *WorkQueue) Depth() int {
+	n, err := wq.backend.Depth()
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not get work queue depth: "+err.Error())
+	}
+	return n
+}
+
+// Stops taking messages from the backend and closes it. Work that's already running is allowed to finish.
+func (wq *WorkQueue) Close() error {
+	err := wq.backend.Close()
+	wq.poolMu.Lock()
+	defer wq.poolMu.Unlock()
+	for k, pool := range wq.pools {
+		delete(wq.pools, k)
+		go pool.Stop()
+	}
+	return err
 }
 
 // Adds a function to process a specified message type
 func (wq *WorkQueue) AddWorkFunc(msgType string, fn WorkHandler) {
+	wq.handlerMu.Lock()
+	defer wq.handlerMu.Unlock()
 	if _, ok := wq.workHandlers[msgType]; !ok {
 		wq.workHandlers[msgType] = make([]WorkHandler, 0)
 	}
@@ -30,22 +100,98 @@ func (wq *WorkQueue) AddWorkFunc(msgType string, fn WorkHandler) {
 }
 
 func (wq *WorkQueue) AddResultsFunc(msgType string, fn ResultHandler) {
+	wq.handlerMu.Lock()
+	defer wq.handlerMu.Unlock()
 	if _, ok := wq.resultHandlers[msgType]; !ok {
 		wq.resultHandlers[msgType] = make([]ResultHandler, 0)
 	}
 	wq.resultHandlers[msgType] = append(wq.resultHandlers[msgType], fn)
 }
 
+// Sets the retry policy for a message type, replacing DefaultRetryPolicy
+func (wq *WorkQueue) SetRetryPolicy(msgType string, policy RetryPolicy) {
+	wq.retryMu.Lock()
+	defer wq.retryMu.Unlock()
+	wq.retryPolicies[msgType] = policy
+}
+
+// Gets the retry policy for a message type
+func (wq *WorkQueue) RetryPolicy(msgType string) RetryPolicy {
+	wq.retryMu.RLock()
+	defer wq.retryMu.RUnlock()
+	policy, ok := wq.retryPolicies[msgType]
+	if !ok {
+		return DefaultRetryPolicy
+	}
+	return policy
+}
+
+// Sets the number of requests of a message type that may run at once
+func (wq *WorkQueue) SetConcurrency(msgType string, n int) {
+	wq.poolMu.Lock()
+	defer wq.poolMu.Unlock()
+	wq.concurrency[msgType] = n
+	if pool, ok := wq.pools[msgType]; ok {
+		// running jobs finish on the old pool; new ones go to a pool of the new size
+		delete(wq.pools, msgType)
+		go pool.Stop()
+	}
+}
+
+// Sets the number of requests that may run at once for message types without their own setting
+func (wq *WorkQueue) SetDefaultConcurrency(n int) {
+	wq.poolMu.Lock()
+	defer wq.poolMu.Unlock()
+	wq.defaultConcurrency = n
+	for k, pool := range wq.pools {
+		if _, ok := wq.concurrency[k]; !ok {
+			delete(wq.pools, k)
+			go pool.Stop()
+		}
+	}
+}
+
+// Returns the number of requests of a message type that may run at once
+func (wq *WorkQueue) Concurrency(msgType string) int {
+	wq.poolMu.Lock()
+	defer wq.poolMu.Unlock()
+	return wq.concurrencyLocked(msgType)
+}
+
+func (wq *WorkQueue) concurrencyLocked(msgType string) int {
+	if n, ok := wq.concurrency[msgType]; ok && n > 0 {
+		return n
+	}
+	if wq.defaultConcurrency > 0 {
+		return wq.defaultConcurrency
+	}
+	return WORK_DEFAULT_CONCURRENCY
+}
+
+// Gets the worker pool for a message type, creating it the first time the type is seen
+func (wq *WorkQueue) poolFor(msgType string) *common.WorkerPool[*WorkRequest, bool] {
+	wq.poolMu.Lock()
+	defer wq.poolMu.Unlock()
+	pool, ok := wq.pools[msgType]
+	if !ok {
+		pool = common.NewWorkerPool(wq.concurrencyLocked(msgType), wq.runHandlers)
+		wq.pools[msgType] = pool
+	}
+	return pool
+}
+
+// Returns a snapshot of the queue's metrics
+func (wq *WorkQueue) MetricsReport() WorkMetricsReport {
+	return wq.Metrics.Snapshot(wq.Depth(), wq.ScheduledDepth(), wq.DeadLetterDepth(), wq.Concurrency)
+}
+
 func (wq *WorkQueue) processResults() {
-	for {
-		select {
-		case res := <-wq.Status:
-			hs := wq.resultHandlers[res.Type]
-			for _, fn := range hs {
-				go func() {
-					fn(res)
-				}()
-			}
+	for res := range wq.Status {
+		wq.handlerMu.RLock()
+		hs := wq.resultHandlers[res.Type]
+		wq.handlerMu.RUnlock()
+		for _, fn := range hs {
+			go fn(res)
 		}
 	}
 }
@@ -53,46 +199,190 @@ func (wq *WorkQueue) processResults() {
 // goroutine to process incoming messages and dispatch them
 func (wq *WorkQueue) processMsgs() {
 	for {
-		msg, err := wq.queue.DequeueBlock()
+		msg, err := wq.backend.Dequeue()
+		if err == ErrQueueClosed {
+			return
+		}
 		if err != nil {
 			wq.Status <- WorkStatusReport{
 				Status: "failed to dequeue msg",
 				Error:  err,
 			}
-		} else {
-			fns, ok := wq.workHandlers[msg.(*WorkRequest).Type]
-			if ok {
-				for _, fn := range fns {
-					go func() {
-						res := fn(msg.(*WorkRequest))
-						wq.Status <- res
-					}()
-				}
+			// don't spin while the backend is unavailable
+			time.Sleep(time.Second)
+			continue
+		}
+		wq.handlerMu.RLock()
+		_, ok := wq.workHandlers[msg.Type]
+		wq.handlerMu.RUnlock()
+		if !ok {
+			// kept on the dead-letter queue, so it can be replayed once a handler is added
+			msg.LastError = "no handler for work type '" + msg.Type + "'"
+			logging.LogToDeck(context.Background(), "error", "WORK", "error", "work '"+msg.ID+"' has no handler for type '"+msg.Type+"', moving to dead-letter queue")
+			wq.Metrics.deadLettered(msg.Type)
+			err = wq.deadLetter(msg)
+			if err != nil {
+				logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not dead-letter work '"+msg.ID+"': "+err.Error())
+			}
+			wq.jobFailed(msg)
+			continue
+		}
+		// blocks until a worker for this type is free, which holds the rest of the queue on disk
+		for {
+			_, err = wq.poolFor(msg.Type).Submit(msg)
+			if err != common.ErrPoolStopped {
+				break
 			}
 		}
 	}
 }
 
-func msgBuilder() interface{} {
-	return &WorkRequest{}
+/*
+Runs each of a message's handlers, reporting their results, and retries or dead-letters the message if any failed.
+Returns true if every handler succeeded.
+*/
+func (wq *WorkQueue) runHandlers(msg *WorkRequest) bool {
+	wq.handlerMu.RLock()
+	fns := wq.workHandlers[msg.Type]
+	wq.handlerMu.RUnlock()
+	if msg.Attempts > 0 {
+		wq.Metrics.requeued(msg.Type)
+	}
+	msg.Attempts++
+	msg.LastAttemptOn = time.Now()
+	if !wq.jobStarted(msg) {
+		logging.LogToDeck(context.Background(), "info", "WORK", "info", "skipping cancelled work '"+msg.ID+"'")
+		return true
+	}
+	wq.Metrics.started(msg.Type)
+
+	results := make([]WorkStatusReport, len(fns))
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn WorkHandler) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					results[i] = WorkStatusReport{
+						Type:   msg.Type,
+						ID:     msg.ID,
+						Msg:    *msg,
+						Status: "panicked",
+						Error:  fmt.Errorf("work handler panicked: %v", r),
+					}
+				}
+			}()
+			results[i] = fn(msg)
+		}(i, fn)
+	}
+	wg.Wait()
+
+	var failure error
+	for _, res := range results {
+		if res.Error != nil {
+			failure = res.Error
+		}
+	}
+	wq.Metrics.finished(msg.Type, failure == nil)
+	state := JOB_SUCCEEDED
+	if failure != nil {
+		msg.LastError = failure.Error()
+		state = JOB_RETRYING
+		if !wq.RetryPolicy(msg.Type).ShouldRetry(msg.Attempts) {
+			state = JOB_FAILED
+		}
+	}
+	// the status is recorded before the results go out, so results handlers see it, and before a retry is scheduled,
+	// since a short backoff could run the message again before retry() returns
+	wq.jobAttempted(msg, results, state)
+	for _, res := range results {
+		wq.Status <- res
+	}
+	if failure != nil {
+		wq.retry(msg)
+	}
+	return failure == nil
 }
 
-// Creates a new MQ
+// Schedules a failed message to be run again after its backoff, or moves it to the dead-letter queue
+func (wq *WorkQueue) retry(msg *WorkRequest) {
+	policy := wq.RetryPolicy(msg.Type)
+	if !policy.ShouldRetry(msg.Attempts) {
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", fmt.Sprintf("work '%s' (%s) failed after %d attempt(s), moving to dead-letter queue: %s", msg.ID, msg.Type, msg.Attempts, msg.LastError))
+		wq.Metrics.deadLettered(msg.Type)
+		err := wq.deadLetter(msg)
+		if err != nil {
+			logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not dead-letter work '"+msg.ID+"': "+err.Error())
+		}
+		return
+	}
+
+	delay := policy.Backoff(msg.Attempts)
+	logging.LogToDeck(context.Background(), "info", "WORK", "info", fmt.Sprintf("work '%s' (%s) failed on attempt %d, retrying in %s: %s", msg.ID, msg.Type, msg.Attempts, delay, msg.LastError))
+	// the message waits out its backoff in the backend, so a pending retry survives a restart
+	wq.Metrics.retrying(msg.Type)
+	err := wq.backend.Enqueue(msg, time.Now().Add(delay))
+	if err != nil {
+		logging.LogToDeck(context.Background(), "error", "WORK", "error", "could not requeue work '"+msg.ID+"', moving to dead-letter queue: "+err.Error())
+		wq.Metrics.requeued(msg.Type)
+		wq.Metrics.deadLettered(msg.Type)
+		wq.deadLetter(msg)
+		wq.jobFailed(msg)
+	}
+}
+
+// Creates a new MQ, stored on local disk by a DQueBackend
 func New(name string, saveDir string, segmentSz int) (*WorkQueue, error) {
-	dq, err := dque.NewOrOpen(name, saveDir, segmentSz, msgBuilder)
+	wq, err := NewDeferred(name, saveDir, segmentSz)
+	if err != nil {
+		return nil, err
+	}
+	wq.Start()
+	return wq, nil
+}
+
+// Creates a new MQ, stored on local disk by a DQueBackend, that doesn't take messages until Start() is called
+func NewDeferred(name string, saveDir string, segmentSz int) (*WorkQueue, error) {
+	backend, err := NewDQueBackend(name, saveDir, segmentSz)
 	if err != nil {
 		return nil, err
 	}
+	store, err := NewFileJobStatusStore(filepath.Join(saveDir, name+"-status"))
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	return NewWithBackendDeferred(backend, store), nil
+}
+
+// Creates a new MQ with a given backend and job status store. If store is nil, statuses are kept in memory.
+func NewWithBackend(backend QueueBackend, store JobStatusStore) *WorkQueue {
+	wq := NewWithBackendDeferred(backend, store)
+	wq.Start()
+	return wq
+}
+
+// Creates a new MQ with a given backend and job status store, as with NewWithBackend(), that doesn't take messages until Start() is called
+func NewWithBackendDeferred(backend QueueBackend, store JobStatusStore) *WorkQueue {
+	if store == nil {
+		store = NewMemoryJobStatusStore()
+	}
 	wq := &WorkQueue{
-		Status:         make(chan WorkStatusReport),
-		Metrics:        &WorkMetrics{},
-		queue:          dq,
-		workHandlers:   make(map[string][]WorkHandler),
-		resultHandlers: make(map[string][]ResultHandler),
+		Status:          make(chan WorkStatusReport),
+		Metrics:         NewWorkMetrics(),
+		backend:         backend,
+		workHandlers:    make(map[string][]WorkHandler),
+		resultHandlers:  make(map[string][]ResultHandler),
+		retryPolicies:   make(map[string]RetryPolicy),
+		pools:           make(map[string]*common.WorkerPool[*WorkRequest, bool]),
+		concurrency:     make(map[string]int),
+		statusStore:     store,
+		statusRetention: WORK_DEFAULT_STATUS_RETENTION,
 	}
 
-	// start infinite loop to process messages and results
-	go wq.processMsgs()
+	// messages are processed once Start() is called
 	go wq.processResults()
-	return wq, nil
+	go wq.pruneJobStatuses()
+	return wq
 }
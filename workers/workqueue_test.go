@@ -0,0 +1,259 @@
+package workers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	rp := RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, v := range expected {
+		if d := rp.Backoff(i + 1); d != v {
+			t.Fatalf("attempt %d: expected %s, got %s", i+1, v, d)
+		}
+	}
+	rp.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := rp.Backoff(2); d < 100*time.Millisecond || d > 200*time.Millisecond {
+			t.Fatalf("jittered backoff %s out of range", d)
+		}
+	}
+	if !rp.ShouldRetry(4) || rp.ShouldRetry(5) {
+		t.Fatal("unexpected ShouldRetry result")
+	}
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	wq, err := New("test", t.TempDir(), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range wq.Status {
+		}
+	}()
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	wq.SetRetryPolicy("flaky", RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	wq.AddWorkFunc("flaky", func(msg *WorkRequest) WorkStatusReport {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[msg.ID]++
+		if msg.Data.(string) == "always" || attempts[msg.ID] < 3 {
+			return WorkStatusReport{Type: msg.Type, ID: msg.ID, Error: errors.New("db blip")}
+		}
+		return WorkStatusReport{Type: msg.Type, ID: msg.ID}
+	})
+	wq.AddWorkFunc("panics", func(msg *WorkRequest) WorkStatusReport {
+		panic("boom")
+	})
+
+	recovers := NewWorkRequest("flaky", "recovers")
+	always := NewWorkRequest("flaky", "always")
+	panics := NewWorkRequest("panics", "")
+	for _, msg := range []*WorkRequest{recovers, always, panics} {
+		if err := wq.Enqueue(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "dead letters", func() bool { return wq.DeadLetterDepth() == 2 })
+	waitFor(t, "retried work to succeed", func() bool {
+		js, _ := wq.JobStatus(recovers.ID)
+		return js.State == JOB_SUCCEEDED
+	})
+
+	mu.Lock()
+	if attempts[recovers.ID] != 3 || attempts[always.ID] != 3 {
+		t.Fatalf("unexpected attempts: %v", attempts)
+	}
+	mu.Unlock()
+
+	dead, err := wq.DeadLetters(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]WorkRequest{}
+	for _, v := range dead {
+		ids[v.ID] = v
+	}
+	if ids[always.ID].Attempts != 3 || ids[always.ID].LastError != "db blip" {
+		t.Fatalf("unexpected dead letter %+v", ids[always.ID])
+	}
+	if ids[panics.ID].Attempts != 1 || ids[panics.ID].LastError == "" {
+		t.Fatalf("unexpected dead letter %+v", ids[panics.ID])
+	}
+
+	if _, err := wq.ReplayDeadLetters("nope"); err != ErrDeadLetterNotFound {
+		t.Fatalf("expected ErrDeadLetterNotFound, got %v", err)
+	}
+	n, err := wq.ReplayDeadLetters(always.ID)
+	if err != nil || n != 1 {
+		t.Fatalf("replay failed: %d, %v", n, err)
+	}
+	// the replayed request gets three more attempts, then is dead-lettered again
+	waitFor(t, "replayed attempts", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts[always.ID] == 6
+	})
+	waitFor(t, "dead letter", func() bool { return wq.DeadLetterDepth() == 2 })
+
+	n, err = wq.PurgeDeadLetters("")
+	if err != nil || n != 2 || wq.DeadLetterDepth() != 0 {
+		t.Fatalf("purge failed: %d, %v", n, err)
+	}
+}
+
+func TestConcurrencyAndMetrics(t *testing.T) {
+	wq, err := New("test", t.TempDir(), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range wq.Status {
+		}
+	}()
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	release := make(chan bool)
+	wq.SetConcurrency("slow", 2)
+	wq.AddWorkFunc("slow", func(msg *WorkRequest) WorkStatusReport {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if msg.Data.(string) == "fail" {
+			return WorkStatusReport{Type: msg.Type, ID: msg.ID, Error: errors.New("failed")}
+		}
+		return WorkStatusReport{Type: msg.Type, ID: msg.ID}
+	})
+
+	for i := 0; i < 10; i++ {
+		data := "ok"
+		if i%5 == 0 {
+			data = "fail"
+		}
+		if err := wq.Enqueue(NewWorkRequest("slow", data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "workers to start", func() bool { return wq.MetricsReport().Running == 2 })
+	// two running, and one dequeued waiting for a worker; the rest stay on disk
+	time.Sleep(20 * time.Millisecond)
+	if d := wq.Depth(); d != 7 {
+		t.Fatalf("expected 7 messages left on the queue, got %d", d)
+	}
+
+	close(release)
+	waitFor(t, "work to finish", func() bool {
+		rpt := wq.MetricsReport()
+		return rpt.Succeeded+rpt.Failed == 10
+	})
+	rpt := wq.MetricsReport()
+	if maxRunning != 2 {
+		t.Fatalf("expected at most 2 concurrent workers, saw %d", maxRunning)
+	}
+	if rpt.Succeeded != 8 || rpt.Failed != 2 || rpt.Queued != 0 || rpt.Running != 0 {
+		t.Fatalf("unexpected metrics %+v", rpt)
+	}
+	if rpt.Types["slow"].Concurrency != 2 {
+		t.Fatalf("unexpected concurrency %d", rpt.Types["slow"].Concurrency)
+	}
+	waitFor(t, "dead letters", func() bool { return wq.MetricsReport().DeadLetters == 2 })
+}
+
+func TestDeferredStart(t *testing.T) {
+	wq, err := NewDeferred("test", t.TempDir(), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range wq.Status {
+		}
+	}()
+
+	// work enqueued before its handler is added waits until the queue is started
+	early := NewWorkRequest("early", "")
+	if err := wq.Enqueue(early); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if d := wq.Depth(); d != 1 {
+		t.Fatalf("expected work to wait for Start(), %d left on the queue", d)
+	}
+	ran := make(chan string, 1)
+	wq.AddWorkFunc("early", func(msg *WorkRequest) WorkStatusReport {
+		ran <- msg.ID
+		return WorkStatusReport{Type: msg.Type, ID: msg.ID}
+	})
+	wq.Start()
+	wq.Start()
+	select {
+	case id := <-ran:
+		if id != early.ID {
+			t.Fatalf("unexpected work %s", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("work enqueued before its handler did not run")
+	}
+}
+
+func TestUnhandledWork(t *testing.T) {
+	wq, err := New("test", t.TempDir(), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range wq.Status {
+		}
+	}()
+
+	// work with no handler is dead-lettered rather than dropped, and can be replayed once one is added
+	orphan := NewWorkRequest("orphan", "")
+	if err := wq.Enqueue(orphan); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "dead letter", func() bool { return wq.DeadLetterDepth() == 1 })
+	js, _ := wq.JobStatus(orphan.ID)
+	if js.State != JOB_FAILED || js.Error != "no handler for work type 'orphan'" {
+		t.Fatalf("unexpected status %+v", js)
+	}
+	ran := make(chan string, 1)
+	wq.AddWorkFunc("orphan", func(msg *WorkRequest) WorkStatusReport {
+		ran <- msg.ID
+		return WorkStatusReport{Type: msg.Type, ID: msg.ID}
+	})
+	if n, err := wq.ReplayDeadLetters(orphan.ID); err != nil || n != 1 {
+		t.Fatalf("replay failed: %d, %v", n, err)
+	}
+	select {
+	case id := <-ran:
+		if id != orphan.ID {
+			t.Fatalf("unexpected work %s", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replayed work did not run")
+	}
+}
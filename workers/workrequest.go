@@ -1,11 +1,18 @@
 package workers
 
-import "github.com/highgrav/taproot/common"
+import (
+	"github.com/highgrav/taproot/common"
+	"time"
+)
 
 type WorkRequest struct {
-	Type string
-	ID   string
-	Data any
+	Type          string
+	ID            string
+	Data          any
+	UserID        string    // The user who submitted the request, if any; used to route status updates
+	Attempts      int       // Number of times the request's handlers have been run
+	LastError     string    // The error from the most recent failed attempt, if any
+	LastAttemptOn time.Time // When the request's handlers were last run
 }
 
 func NewWorkRequest(msgType string, t any) *WorkRequest {